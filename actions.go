@@ -0,0 +1,215 @@
+package sshw
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// installKeyScript 从标准输入读取公钥，不存在时追加到 authorized_keys
+const installKeyScript = `umask 077; read -r key; mkdir -p ~/.ssh && touch ~/.ssh/authorized_keys && ` +
+	`(grep -qxF "$key" ~/.ssh/authorized_keys || echo "$key" >> ~/.ssh/authorized_keys)`
+
+// Exec 在节点上执行命令，输出到当前终端
+// 不转发标准输入，避免命令结束后仍有读取终端的 goroutine
+func (c *defaultClient) Exec(cmd string) error {
+	client, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return run(client, cmd, os.Stdout, os.Stderr)
+}
+
+// run 在已建立的连接上执行命令
+func run(client *ssh.Client, cmd string, stdout, stderr io.Writer) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdout = stdout
+	session.Stderr = stderr
+	return session.Run(cmd)
+}
+
+// CopyID 把公钥添加到节点的 ~/.ssh/authorized_keys，已存在时不重复添加
+func (c *defaultClient) CopyID(publicKey []byte) error {
+	key, _, _, _, err := ssh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	line := bytes.TrimSpace(publicKey)
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	client, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdin = bytes.NewReader(append(line, '\n'))
+	session.Stderr = os.Stderr
+	if err := session.Run(installKeyScript); err != nil {
+		return err
+	}
+	l.Infof("installed %s key %s on %s\n", key.Type(), ssh.FingerprintSHA256(key), c.node.Path())
+	return nil
+}
+
+// Tunnel 建立本地端口转发，spec 格式同 ssh -L：[bind_address:]port:host:hostport
+// 阻塞直到收到中断信号
+func (c *defaultClient) Tunnel(spec string) error {
+	local, remote, err := parseForward(spec)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	listener, err := net.Listen("tcp", local)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	closed := make(chan error, 1)
+	go func() {
+		closed <- client.Wait()
+	}()
+	go func() {
+		select {
+		case <-interrupt:
+		case <-closed:
+		}
+		listener.Close()
+	}()
+
+	l.Infof("forwarding %s -> %s via %s, press Ctrl-C to stop\n", listener.Addr(), remote, c.node.Path())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return nil
+		}
+		go forward(client, conn, remote)
+	}
+}
+
+// forward 把本地连接经 SSH 转发到远程地址
+func forward(client *ssh.Client, conn net.Conn, remote string) {
+	defer conn.Close()
+	rconn, err := client.Dial("tcp", remote)
+	if err != nil {
+		l.Error("tunnel:", err)
+		return
+	}
+	defer rconn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		io.Copy(rconn, conn)
+		rconn.Close()
+		wg.Done()
+	}()
+	go func() {
+		io.Copy(conn, rconn)
+		conn.Close()
+		wg.Done()
+	}()
+	wg.Wait()
+}
+
+// parseForward 解析 [bind_address:]port:host:hostport，未指定监听地址时只监听本机
+func parseForward(spec string) (local, remote string, err error) {
+	var parts []string
+	// 支持 [::1]:8080:[fe80::1]:80 形式的 IPv6 地址
+	for rest := spec; rest != ""; {
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return "", "", fmt.Errorf("invalid forward %q", spec)
+			}
+			parts = append(parts, rest[1:end])
+			rest = strings.TrimPrefix(rest[end+1:], ":")
+			continue
+		}
+		i := strings.Index(rest, ":")
+		if i < 0 {
+			parts = append(parts, rest)
+			break
+		}
+		parts = append(parts, rest[:i])
+		rest = rest[i+1:]
+	}
+
+	bind := "localhost"
+	switch len(parts) {
+	case 3:
+	case 4:
+		bind, parts = parts[0], parts[1:]
+	default:
+		return "", "", fmt.Errorf("invalid forward %q, expected [bind_address:]port:host:hostport", spec)
+	}
+	for _, p := range []string{parts[0], parts[2]} {
+		if n, err := strconv.Atoi(p); err != nil || n < 0 || n > 65535 {
+			return "", "", fmt.Errorf("invalid port %q in forward %q", p, spec)
+		}
+	}
+	return net.JoinHostPort(bind, parts[0]), net.JoinHostPort(parts[1], parts[2]), nil
+}
+
+// DefaultPublicKey 返回第一个存在的默认公钥文件，顺序同默认私钥
+func DefaultPublicKey() (string, []byte, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, t := range defaultKeyTypes {
+		path := filepath.Join(home, ".ssh", defaultKeyFiles[t]+".pub")
+		if b, err := ioutil.ReadFile(path); err == nil {
+			return path, b, nil
+		}
+	}
+	return "", nil, fmt.Errorf("no public key found in %s", filepath.Join(home, ".ssh"))
+}
+
+// Probe 检查节点的第一跳（配置了跳板机时为跳板机）能否建立 TCP 连接，返回连接耗时
+func (n *Node) Probe(timeout time.Duration) (time.Duration, error) {
+	hop := n
+	if len(n.Jump) > 0 {
+		hop = n.Jump[0]
+	}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(hop.Host, strconv.Itoa(hop.port())), timeout)
+	if err != nil {
+		return 0, err
+	}
+	conn.Close()
+	return time.Since(start), nil
+}
@@ -0,0 +1,100 @@
+package sshw
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// CertConfig 连接时签发短期证书的设置，未设置的项从上级分组继承
+// 证书由 Vault SSH secrets engine 签发
+type CertConfig struct {
+	Role       string   `yaml:"role,omitempty" json:"role,omitempty"`
+	Mount      string   `yaml:"mount,omitempty" json:"mount,omitempty"`
+	Principals []string `yaml:"principals,omitempty" json:"principals,omitempty"`
+	TTL        string   `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+}
+
+// merge 用 parent 补全未设置的项
+func (c *CertConfig) merge(parent *CertConfig) {
+	if c.Role == "" {
+		c.Role = parent.Role
+	}
+	if c.Mount == "" {
+		c.Mount = parent.Mount
+	}
+	if len(c.Principals) == 0 {
+		c.Principals = parent.Principals
+	}
+	if c.TTL == "" {
+		c.TTL = parent.TTL
+	}
+}
+
+// certConfig 返回节点继承后的证书设置，没有设置时返回 nil
+func (n *Node) certConfig() *CertConfig {
+	var c *CertConfig
+	for p := n; p != nil; p = p.parent {
+		if p.SSHCert == nil {
+			continue
+		}
+		if c == nil {
+			c = new(CertConfig)
+		}
+		c.merge(p.SSHCert)
+	}
+	return c
+}
+
+// certSigner 为临时公钥签发用户证书
+type certSigner interface {
+	SignKey(pub ssh.PublicKey, c *CertConfig, principals []string) (*ssh.Certificate, error)
+}
+
+// 签发证书使用的 signer，测试时替换为本地 CA
+var defaultCertSigner certSigner = vaultCertSigner{}
+
+// vaultCertSigner 使用 Vault 签发证书
+type vaultCertSigner struct{}
+
+func (vaultCertSigner) SignKey(pub ssh.PublicKey, c *CertConfig, principals []string) (*ssh.Certificate, error) {
+	client, err := getVaultClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.SignSSHKey(c.Mount, c.Role, pub, principals, c.TTL)
+}
+
+// certAuth 返回使用临时证书的认证方式
+func (n *Node) certAuth(c *CertConfig) (ssh.AuthMethod, error) {
+	signer, err := n.issueCert(c)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.PublicKeys(signer), nil
+}
+
+// issueCert 为每次连接生成临时 ed25519 密钥并签发证书，私钥只保存在内存中
+func (n *Node) issueCert(c *CertConfig) (ssh.Signer, error) {
+	principals := c.Principals
+	if len(principals) == 0 {
+		principals = []string{n.user()}
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := defaultCertSigner.SignKey(key.PublicKey(), c, principals)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %v", err)
+	}
+	return ssh.NewCertSigner(cert, key)
+}
@@ -0,0 +1,323 @@
+package sshw
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zdev0x/sshw/vault"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// localSigner 使用本地 CA 签发证书，代替 Vault
+type localSigner struct {
+	ca ssh.Signer
+}
+
+func (s *localSigner) SignKey(pub ssh.PublicKey, c *CertConfig, principals []string) (*ssh.Certificate, error) {
+	ttl := 5 * time.Minute
+	if c.TTL != "" {
+		d, err := time.ParseDuration(c.TTL)
+		if err != nil {
+			return nil, err
+		}
+		ttl = d
+	}
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		KeyId:           "sshw-" + principals[0],
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(ttl).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, s.ca); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// useLocalCA 让证书签发使用本地 CA，返回 CA 的 signer
+func useLocalCA(t *testing.T) ssh.Signer {
+	t.Helper()
+	ca := newTestSigner(t)
+	defaultCertSigner = &localSigner{ca: ca}
+	return ca
+}
+
+// vaultSigner 模拟 Vault 的 /v1/ssh/sign/<role>，fail 为 true 时返回错误
+type vaultSigner struct {
+	ca ssh.Signer
+
+	mu    sync.Mutex
+	fail  bool
+	roles []string
+}
+
+func (v *vaultSigner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.fail || !strings.HasPrefix(r.URL.Path, "/v1/ssh/sign/") {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"errors":["internal error"]}`))
+		return
+	}
+	v.roles = append(v.roles, strings.TrimPrefix(r.URL.Path, "/v1/ssh/sign/"))
+
+	var body map[string]string
+	json.NewDecoder(r.Body).Decode(&body)
+	pub, _, _, _, _ := ssh.ParseAuthorizedKey([]byte(body["public_key"]))
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: strings.Split(body["valid_principals"], ","),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	cert.SignCert(rand.Reader, v.ca)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": map[string]string{"signed_key": string(ssh.MarshalAuthorizedKey(cert))},
+	})
+}
+
+// useVault 让证书签发使用模拟的 Vault
+func useVault(t *testing.T) *vaultSigner {
+	t.Helper()
+	v := &vaultSigner{ca: newTestSigner(t)}
+	srv := httptest.NewServer(v)
+	t.Cleanup(srv.Close)
+	vaultConfig, vaultClient = &vault.Config{Address: srv.URL, Token: "root"}, nil
+	return v
+}
+
+func certOf(t *testing.T, s ssh.Signer) *ssh.Certificate {
+	t.Helper()
+	cert, ok := s.PublicKey().(*ssh.Certificate)
+	if !ok {
+		t.Fatal("signer has no certificate")
+	}
+	return cert
+}
+
+func TestCertConfigInheritance(t *testing.T) {
+	leaf := &Node{Name: "web"}
+	override := &Node{Name: "db", SSHCert: &CertConfig{Principals: []string{"dba"}, TTL: "1m"}}
+	tree := []*Node{
+		{
+			Name:    "prod",
+			SSHCert: &CertConfig{Role: "ops", Mount: "ssh-client", Principals: []string{"deploy"}, TTL: "10m"},
+			Children: []*Node{
+				{Name: "app", Children: []*Node{leaf, override}},
+			},
+		},
+		{Name: "dev", Children: []*Node{{Name: "box"}}},
+	}
+	linkParents(tree, nil)
+
+	tests := []struct {
+		node *Node
+		want *CertConfig
+	}{
+		{leaf, &CertConfig{Role: "ops", Mount: "ssh-client", Principals: []string{"deploy"}, TTL: "10m"}},
+		{override, &CertConfig{Role: "ops", Mount: "ssh-client", Principals: []string{"dba"}, TTL: "1m"}},
+		{tree[1].Children[0], nil},
+	}
+	for _, tt := range tests {
+		if got := tt.node.certConfig(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.node.Path(), got, tt.want)
+		}
+	}
+	if tree[0].SSHCert.TTL != "10m" {
+		t.Fatal("merging changed the parent config")
+	}
+}
+
+func TestIssueCert(t *testing.T) {
+	saveState(t)
+	ca := useLocalCA(t)
+	n := &Node{Name: "web", User: "alice", SSHCert: &CertConfig{TTL: "10m"}}
+
+	s, err := n.issueCert(n.certConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := certOf(t, s)
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+		},
+	}
+	if err := checker.CheckCert("alice", cert); err != nil {
+		t.Fatalf("certificate rejected: %v", err)
+	}
+	if !reflect.DeepEqual(cert.ValidPrincipals, []string{"alice"}) {
+		t.Fatalf("principals: %v", cert.ValidPrincipals)
+	}
+
+	n.SSHCert.TTL = "soon"
+	if _, err := n.issueCert(n.certConfig()); err == nil || !strings.Contains(err.Error(), "failed to sign certificate") {
+		t.Fatalf("signing error: got %v", err)
+	}
+}
+
+func TestVaultCertSigner(t *testing.T) {
+	saveState(t)
+	v := useVault(t)
+	n := &Node{Name: "web", User: "alice"}
+	linkParents([]*Node{{Name: "prod", SSHCert: &CertConfig{Role: "ops", Principals: []string{"deploy"}}, Children: []*Node{n}}}, nil)
+
+	s, err := n.issueCert(n.certConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := certOf(t, s).ValidPrincipals; !reflect.DeepEqual(got, []string{"deploy"}) {
+		t.Fatalf("principals: %v", got)
+	}
+	if !reflect.DeepEqual(v.roles, []string{"ops"}) {
+		t.Fatalf("signed with roles %v", v.roles)
+	}
+}
+
+func TestCertPerLogin(t *testing.T) {
+	saveState(t)
+	useLocalCA(t)
+	n := &Node{Name: "web", User: "alice", SSHCert: &CertConfig{TTL: "5m"}}
+
+	// 每次连接使用新的临时密钥和证书
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		s, err := n.issueCert(n.certConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := string(certOf(t, s).Key.Marshal())
+		if seen[key] {
+			t.Fatal("ephemeral key reused")
+		}
+		seen[key] = true
+	}
+}
+
+// testServer 启动只接受 ca 签发的证书或指定密码的 ssh 服务器，返回地址和使用的认证方式
+func testServer(t *testing.T, ca ssh.PublicKey, password string) (string, <-chan string) {
+	t.Helper()
+	methods := make(chan string, 16)
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), ca.Marshal())
+		},
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			p, err := checker.Authenticate(c, key)
+			if err == nil {
+				methods <- "certificate"
+			}
+			return p, err
+		},
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) != password {
+				return nil, ssh.ErrNoAuth
+			}
+			methods <- "password"
+			return nil, nil
+		},
+	}
+	return serveSSH(t, config), methods
+}
+
+// serveSSH 在本地端口运行 ssh 服务器，只完成认证，拒绝所有 channel
+func serveSSH(t *testing.T, config *ssh.ServerConfig) string {
+	t.Helper()
+	config.AddHostKey(newTestSigner(t))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				defer sc.Close()
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					ch.Reject(ssh.Prohibited, "test server")
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// dialTest 使用给定的认证方式登录测试服务器
+func dialTest(addr, user string, auth ...ssh.AuthMethod) error {
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
+
+// loginMethod 使用节点的认证设置登录测试服务器，返回服务器接受的认证方式
+func loginMethod(t *testing.T, n *Node, addr string, methods <-chan string) string {
+	t.Helper()
+	if err := dialTest(addr, n.user(), genSSHConfig(n).clientConfig.Auth...); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	return <-methods
+}
+
+func TestCertAuthFallback(t *testing.T) {
+	saveState(t)
+	v := useVault(t)
+	addr, methods := testServer(t, v.ca.PublicKey(), "pw")
+	n := &Node{Name: "web", User: "alice", Password: "pw", SSHCert: &CertConfig{Role: "ops"}}
+
+	if got := loginMethod(t, n, addr, methods); got != "certificate" {
+		t.Fatalf("with vault: logged in with %s", got)
+	}
+
+	// 签发失败时继续使用私钥和密码
+	v.mu.Lock()
+	v.fail = true
+	v.mu.Unlock()
+	if got := loginMethod(t, n, addr, methods); got != "password" {
+		t.Fatalf("signing failed: logged in with %s", got)
+	}
+}
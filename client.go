@@ -1,14 +1,10 @@
 package sshw
 
 import (
-	"bufio"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"os"
-	"os/user"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -39,6 +35,9 @@ var (
 
 type Client interface {
 	Login()
+	Exec(cmd string) error
+	CopyID(publicKey []byte) error
+	Tunnel(spec string) error
 }
 
 type defaultClient struct {
@@ -55,71 +54,40 @@ type LoginMarker struct {
 }
 
 func genSSHConfig(node *Node) *defaultClient {
-	u, err := user.Current()
+	// 连接时才解析外部密钥引用，失败时只跳过对应的认证方式
+	secrets, err := node.resolveSecrets()
 	if err != nil {
 		l.Error(err)
-		return nil
 	}
 
 	var authMethods []ssh.AuthMethod
 
-	var pemBytes []byte
-	if node.KeyPath == "" {
-		pemBytes, err = ioutil.ReadFile(filepath.Join(u.HomeDir, ".ssh/id_rsa"))
-	} else {
-		pemBytes, err = ioutil.ReadFile(node.KeyPath)
-	}
-	if err != nil {
-		l.Error(err)
-	} else {
-		var signer ssh.Signer
-		if node.Passphrase != "" {
-			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(node.Passphrase))
-		} else {
-			signer, err = ssh.ParsePrivateKey(pemBytes)
-		}
+	// 配置了证书签发时，优先使用临时签发的证书
+	if c := node.certConfig(); c != nil {
+		auth, err := node.certAuth(c)
 		if err != nil {
-			l.Error(err)
+			l.Errorf("%s: %v", node.Path(), err)
 		} else {
-			authMethods = append(authMethods, ssh.PublicKeys(signer))
+			authMethods = append(authMethods, auth)
 		}
 	}
 
-	password := node.password()
+	if signers := secrets.signers(); len(signers) > 0 {
+		authMethods = append(authMethods, ssh.PublicKeys(signers...))
+	}
+
+	password := secrets.password()
 
 	if password != nil {
 		authMethods = append(authMethods, password)
 	}
 
-	authMethods = append(authMethods, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
-		answers := make([]string, 0, len(questions))
-		for i, q := range questions {
-			fmt.Print(q)
-			if echos[i] {
-				scan := bufio.NewScanner(os.Stdin)
-				if scan.Scan() {
-					answers = append(answers, scan.Text())
-				}
-				err := scan.Err()
-				if err != nil {
-					return nil, err
-				}
-			} else {
-				b, err := terminal.ReadPassword(int(syscall.Stdin))
-				if err != nil {
-					return nil, err
-				}
-				fmt.Println()
-				answers = append(answers, string(b))
-			}
-		}
-		return answers, nil
-	}))
+	authMethods = append(authMethods, secrets.keyboardInteractive())
 
 	config := &ssh.ClientConfig{
 		User:            node.user(),
 		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback(),
 		Timeout:         time.Second * 10,
 	}
 
@@ -192,68 +160,69 @@ func (c *defaultClient) setLoginMarker(client *ssh.Client) error {
 	return nil
 }
 
-func (c *defaultClient) Login() {
+// dial 连接服务器，配置了跳板机时经过跳板机连接
+func (c *defaultClient) dial() (*ssh.Client, error) {
 	host := c.node.Host
 	port := strconv.Itoa(c.node.port())
 	jNodes := c.node.Jump
 
-	var client *ssh.Client
-
 	if len(jNodes) > 0 {
 		jNode := jNodes[0]
 		jc := genSSHConfig(jNode)
 		proxyClient, err := ssh.Dial("tcp", net.JoinHostPort(jNode.Host, strconv.Itoa(jNode.port())), jc.clientConfig)
 		if err != nil {
-			l.Error(err)
-			return
+			return nil, err
 		}
 		conn, err := proxyClient.Dial("tcp", net.JoinHostPort(host, port))
 		if err != nil {
-			l.Error(err)
-			return
+			return nil, err
 		}
 		ncc, chans, reqs, err := ssh.NewClientConn(conn, net.JoinHostPort(host, port), c.clientConfig)
 		if err != nil {
-			l.Error(err)
-			return
+			return nil, err
 		}
-		client = ssh.NewClient(ncc, chans, reqs)
-	} else {
-		client1, err := ssh.Dial("tcp", net.JoinHostPort(host, port), c.clientConfig)
-		client = client1
-		if err != nil {
-			msg := err.Error()
-			// use terminal password retry
-			if strings.Contains(msg, "no supported methods remain") && !strings.Contains(msg, "password") {
-				fmt.Printf("%s@%s's password:", c.clientConfig.User, host)
-				var b []byte
-				b, err = terminal.ReadPassword(int(syscall.Stdin))
-				if err == nil {
-					p := string(b)
-					if p != "" {
-						c.clientConfig.Auth = append(c.clientConfig.Auth, ssh.Password(p))
-					}
-					fmt.Println()
-					client, err = ssh.Dial("tcp", net.JoinHostPort(host, port), c.clientConfig)
+		return ssh.NewClient(ncc, chans, reqs), nil
+	}
+
+	client, err := ssh.Dial("tcp", net.JoinHostPort(host, port), c.clientConfig)
+	if err != nil {
+		msg := err.Error()
+		// use terminal password retry
+		if strings.Contains(msg, "no supported methods remain") && !strings.Contains(msg, "password") {
+			fmt.Printf("%s@%s's password:", c.clientConfig.User, host)
+			var b []byte
+			b, err = terminal.ReadPassword(int(syscall.Stdin))
+			if err == nil {
+				p := string(b)
+				if p != "" {
+					c.clientConfig.Auth = append(c.clientConfig.Auth, ssh.Password(p))
 				}
+				fmt.Println()
+				client, err = ssh.Dial("tcp", net.JoinHostPort(host, port), c.clientConfig)
 			}
 		}
-		if err != nil {
-			l.Error(err)
-			return
-		}
 	}
-	defer client.Close()
-
-	l.Infof("connect server ssh -p %d %s@%s version: %s\n", c.node.port(), c.node.user(), host, string(client.ServerVersion()))
+	return client, err
+}
 
-	session, err := client.NewSession()
+func (c *defaultClient) Login() {
+	client, err := c.dial()
 	if err != nil {
 		l.Error(err)
 		return
 	}
-	defer session.Close()
+	defer client.Close()
+
+	// 登录成功后记录历史，退出时写入连接时长
+	start := time.Now()
+	defer recordLogin(c.node, start)
 
+	l.Infof("connect server ssh -p %d %s@%s version: %s\n", c.node.port(), c.node.user(), c.node.Host, string(client.ServerVersion()))
+	c.shell(client)
+}
+
+// shell 在已建立的连接上打开交互式终端，直到会话结束
+func (c *defaultClient) shell(client *ssh.Client) {
 	fd := int(os.Stdin.Fd())
 	state, err := terminal.MakeRaw(fd)
 	if err != nil {
@@ -271,37 +240,12 @@ func (c *defaultClient) Login() {
 		return
 	}
 
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          1,
-		ssh.TTY_OP_ISPEED: 14400,
-		ssh.TTY_OP_OSPEED: 14400,
-	}
-	err = session.RequestPty("xterm", h, w, modes)
-	if err != nil {
-		l.Error(err)
-		return
-	}
-
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
-	stdinPipe, err := session.StdinPipe()
+	session, stdinPipe, err := c.startShell(client, "xterm", w, h, os.Stdout, os.Stderr)
 	if err != nil {
 		l.Error(err)
 		return
 	}
-
-	err = session.Shell()
-	if err != nil {
-		l.Error(err)
-		return
-	}
-
-	// then callback
-	for i := range c.node.CallbackShells {
-		shell := c.node.CallbackShells[i]
-		time.Sleep(shell.Delay * time.Millisecond)
-		stdinPipe.Write([]byte(shell.Cmd + "\r"))
-	}
+	defer session.Close()
 
 	// change stdin to user
 	go func() {
@@ -335,13 +279,48 @@ func (c *defaultClient) Login() {
 		}
 	}()
 
-	// send keepalive
-	go func() {
-		for {
-			time.Sleep(time.Second * 10)
-			client.SendRequest("keepalive@openssh.com", false, nil)
-		}
-	}()
+	go keepAlive(client)
+
+	session.Wait()
+}
+
+// startShell 打开指定类型和大小的终端并启动 shell，执行回调命令和设置登录标记
+// 返回会话和会话的标准输入
+func (c *defaultClient) startShell(client *ssh.Client, termType string, w, h int, stdout, stderr io.Writer) (*ssh.Session, io.WriteCloser, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(termType, h, w, modes); err != nil {
+		session.Close()
+		return nil, nil, err
+	}
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+	stdinPipe, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, nil, err
+	}
+
+	if err := session.Shell(); err != nil {
+		session.Close()
+		return nil, nil, err
+	}
+
+	// then callback
+	for i := range c.node.CallbackShells {
+		shell := c.node.CallbackShells[i]
+		time.Sleep(shell.Delay * time.Millisecond)
+		stdinPipe.Write([]byte(shell.Cmd + "\r"))
+	}
 
 	// 在登录成功后设置登录标记
 	if c.node.EnableLoginMarker {
@@ -349,6 +328,15 @@ func (c *defaultClient) Login() {
 			GetLogger().Error("Failed to set login marker:", err)
 		}
 	}
+	return session, stdinPipe, nil
+}
 
-	session.Wait()
+// keepAlive 定时发送 keepalive，连接关闭后退出
+func keepAlive(client *ssh.Client) {
+	for {
+		time.Sleep(time.Second * 10)
+		if _, _, err := client.SendRequest("keepalive@openssh.com", false, nil); err != nil {
+			return
+		}
+	}
 }
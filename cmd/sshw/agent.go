@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/zdev0x/sshw/masterkey"
+)
+
+func agentCommand(args []string) {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 15*time.Minute, "wipe the master password after being idle for this long, 0 to keep it until locked")
+	daemon := fs.Bool("d", false, "run the agent in background")
+	fs.Parse(args)
+
+	socket := masterkey.AgentSocket()
+	if masterkey.AgentRunning() {
+		fmt.Println("Agent is already running on", socket)
+		return
+	}
+
+	if *daemon {
+		exe, err := os.Executable()
+		if err != nil {
+			log.Error("Failed to start agent:", err)
+			os.Exit(1)
+		}
+		cmd := exec.Command(exe, "agent", "-timeout", timeout.String())
+		if err := cmd.Start(); err != nil {
+			log.Error("Failed to start agent:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Agent started on %s (pid %d)\n", socket, cmd.Process.Pid)
+		return
+	}
+
+	// 主密码改由 agent 缓存，删除长期保存的缓存
+	store, err := masterkey.GetPasswordStore()
+	if err == nil {
+		err = store.DeleteSecret()
+	}
+	if err != nil {
+		log.Error("Failed to clear cached master password:", err)
+	}
+
+	// 终端关闭后继续运行，收到中断信号时退出并删除 socket
+	signal.Ignore(syscall.SIGHUP)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		os.Remove(socket)
+		os.Exit(0)
+	}()
+
+	agent := masterkey.NewAgent(*timeout)
+	if err := agent.ListenAndServe(socket); err != nil {
+		log.Error("Agent stopped:", err)
+		os.Exit(1)
+	}
+}
+
+func lockCommand(args []string) {
+	if err := masterkey.Lock(); err != nil {
+		log.Error("Failed to lock:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Master password locked")
+}
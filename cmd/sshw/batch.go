@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	"github.com/zdev0x/sshw"
+)
+
+// batchAction 对多个节点的操作
+type batchAction struct {
+	name string
+	run  func(nodes []*sshw.Node) error
+}
+
+// batchActions 返回可用的批量操作，tmux 相关的操作只在 tmux 中可用
+func batchActions() []batchAction {
+	actions := []batchAction{
+		{"run a command on all hosts in parallel", execAll},
+		{"broadcast keystrokes to all hosts in a split view", runCluster},
+	}
+	if inTmux() {
+		actions = append(actions,
+			batchAction{"open each host in a new tmux window", openWindows},
+			batchAction{"open all hosts in split tmux panes", func(nodes []*sshw.Node) error {
+				return openPanes("sshw", nodes, false)
+			}},
+			batchAction{"broadcast keystrokes to all hosts (synchronized tmux panes)", func(nodes []*sshw.Node) error {
+				return openPanes("sshw", nodes, true)
+			}},
+		)
+	}
+	return actions
+}
+
+// open 连接选择的节点，选择了多个节点时询问要执行的批量操作
+func open(nodes []*sshw.Node) {
+	switch len(nodes) {
+	case 0:
+		return
+	case 1:
+		login(nodes[0])
+		return
+	}
+
+	actions := batchActions()
+	names := make([]string, len(actions))
+	for i, a := range actions {
+		names[i] = a.name
+	}
+	prompt := promptui.Select{
+		Label: fmt.Sprintf("%d hosts selected", len(nodes)),
+		Items: names,
+		Templates: &promptui.SelectTemplates{
+			Label:    "✨ {{ . | label }}",
+			Active:   "➤ {{ . | primary }}",
+			Inactive: "  {{ . }}",
+			FuncMap:  funcMap(),
+		},
+		HideSelected: true,
+	}
+	index, _, err := prompt.Run()
+	if err != nil {
+		return
+	}
+	if err := actions[index].run(nodes); err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
+
+// execAll 在所有节点上并行执行输入的命令，有节点失败时以非零状态退出
+func execAll(nodes []*sshw.Node) error {
+	prompt := promptui.Prompt{Label: "command"}
+	cmd, err := prompt.Run()
+	if err != nil || strings.TrimSpace(cmd) == "" {
+		return nil
+	}
+
+	var failed []string
+	for _, r := range sshw.ExecAll(nodes, cmd, os.Stdout) {
+		if r.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.Node.Path(), r.Err))
+		}
+	}
+	if len(failed) == 0 {
+		fmt.Println(paint("ok", fmt.Sprintf("succeeded on %d hosts", len(nodes))))
+		return nil
+	}
+	for _, f := range failed {
+		fmt.Println(paint("error", f))
+	}
+	return fmt.Errorf("failed on %d of %d hosts", len(failed), len(nodes))
+}
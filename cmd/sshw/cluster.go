@@ -0,0 +1,614 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zdev0x/sshw"
+	"golang.org/x/term"
+)
+
+// 命令前缀键 Ctrl-]，之后的一个按键作为命令，不发送到会话
+const clusterPrefix = 0x1d
+
+const (
+	clusterHelp  = "ctrl-] then: 1-9 toggle  ←/→ select  space toggle  a all  o only selected  q quit  ctrl-] send ctrl-]"
+	clusterUsage = `usage:
+  sshw cssh <alias | path>...`
+)
+
+// 每个面板保留的输出行数
+const maxPaneLines = 1000
+
+// clusterPane 一个节点的会话及其输出
+type clusterPane struct {
+	term    *sshw.Terminal
+	screen  paneScreen
+	enabled bool
+	closed  bool
+	rect    paneRect
+}
+
+// paneRect 面板在终端中的位置，第一行为标题
+type paneRect struct {
+	x, y, w, h int
+}
+
+// cluster 分屏显示多个会话，键盘输入同时发送到所有启用的会话
+type cluster struct {
+	mu     sync.Mutex
+	panes  []*clusterPane
+	cursor int
+	prefix bool
+	dirty  bool
+	clear  bool
+	width  int
+	height int
+	quit   chan struct{}
+	once   sync.Once
+	out    *bufio.Writer
+}
+
+// clusterCommand 打开多个节点的会话并同时输入，分组展开为其中的所有节点
+func clusterCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println(clusterUsage)
+		os.Exit(1)
+	}
+	if _, err := unlockConfig(); err != nil {
+		log.Error("Failed to load config:", err)
+		os.Exit(1)
+	}
+	if err := applyTheme(); err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	var nodes []*sshw.Node
+	for _, arg := range args {
+		n, err := sshw.Resolve(sshw.GetConfig(), arg)
+		if err == sshw.ErrNodeNotFound {
+			err = fmt.Errorf("node not found: %s", arg)
+		}
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		for _, leaf := range sshw.Leaves([]*sshw.Node{n}) {
+			if !containsNode(nodes, leaf) {
+				nodes = append(nodes, leaf)
+			}
+		}
+	}
+	if err := runCluster(nodes); err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
+
+// runCluster 依次连接节点，然后分屏显示所有会话直到全部结束或按 Ctrl-] q 退出
+func runCluster(nodes []*sshw.Node) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("cluster mode requires a terminal")
+	}
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return err
+	}
+	rects, err := layout(len(nodes), w, h)
+	if err != nil {
+		return err
+	}
+
+	c := &cluster{
+		width:  w,
+		height: h,
+		quit:   make(chan struct{}),
+		out:    bufio.NewWriter(os.Stdout),
+	}
+	// 连接时可能需要输入密码，依次连接
+	for i, n := range nodes {
+		fmt.Printf("connecting to %s\n", n.Path())
+		p := &clusterPane{enabled: true}
+		t, err := sshw.OpenTerminal(n, rects[i].w, rects[i].h-1, &paneWriter{c: c, p: p})
+		if err != nil {
+			log.Errorf("%s: %v", n.Path(), err)
+			continue
+		}
+		p.term = t
+		c.panes = append(c.panes, p)
+	}
+	if len(c.panes) == 0 {
+		return fmt.Errorf("no session opened")
+	}
+	defer func() {
+		for _, p := range c.panes {
+			p.term.Close()
+		}
+	}()
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	c.mu.Lock()
+	c.relayout()
+	fmt.Fprint(c.out, "\x1b[?1049h")
+	c.render()
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		fmt.Fprint(c.out, "\x1b[?25h\x1b[?1049l")
+		c.out.Flush()
+		c.mu.Unlock()
+	}()
+
+	for _, p := range c.panes {
+		go c.wait(p)
+	}
+
+	// 退出时中断读取，不读走之后输入到 shell 的按键
+	in, err := openInput()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	defer c.stop()
+	input := make(chan []byte)
+	go func() {
+		for {
+			buf := make([]byte, 256)
+			n, err := in.Read(buf)
+			if err != nil {
+				c.stop()
+				return
+			}
+			select {
+			case input <- buf[:n]:
+			case <-c.quit:
+				return
+			}
+		}
+	}()
+
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-c.quit:
+			return nil
+		case b := <-input:
+			c.mu.Lock()
+			quit := c.handle(b)
+			c.render()
+			c.mu.Unlock()
+			if quit {
+				return nil
+			}
+		case <-tick.C:
+			c.mu.Lock()
+			if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil && (w != c.width || h != c.height) {
+				c.width, c.height = w, h
+				c.relayout()
+			}
+			if c.dirty {
+				c.render()
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// wait 会话结束后停止向该会话输入，所有会话结束时退出
+func (c *cluster) wait(p *clusterPane) {
+	<-p.term.Done()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p.closed = true
+	p.enabled = false
+	c.dirty = true
+	for _, p := range c.panes {
+		if !p.closed {
+			return
+		}
+	}
+	c.stop()
+}
+
+func (c *cluster) stop() {
+	c.once.Do(func() { close(c.quit) })
+}
+
+// handle 处理键盘输入，返回是否退出
+func (c *cluster) handle(b []byte) bool {
+	if c.prefix {
+		c.prefix = false
+		return c.command(b)
+	}
+	i := bytes.IndexByte(b, clusterPrefix)
+	if i < 0 {
+		c.broadcast(b)
+		return false
+	}
+	c.broadcast(b[:i])
+	if rest := b[i+1:]; len(rest) > 0 {
+		return c.command(rest)
+	}
+	c.prefix = true
+	return false
+}
+
+// command 执行 Ctrl-] 之后的命令，返回是否退出
+func (c *cluster) command(b []byte) bool {
+	switch k := string(b); {
+	case k == "q":
+		return true
+	case k == string(rune(clusterPrefix)):
+		c.broadcast(b)
+	case k == "\x1b[C" || k == "\x1bOC" || k == "l" || k == "\t":
+		c.cursor = (c.cursor + 1) % len(c.panes)
+	case k == "\x1b[D" || k == "\x1bOD" || k == "h":
+		c.cursor = (c.cursor + len(c.panes) - 1) % len(c.panes)
+	case k == " ":
+		c.toggle(c.cursor)
+	case k == "a":
+		for _, p := range c.panes {
+			p.enabled = !p.closed
+		}
+	case k == "o":
+		for i, p := range c.panes {
+			p.enabled = i == c.cursor && !p.closed
+		}
+	case len(k) == 1 && k[0] >= '1' && k[0] <= '9':
+		if i := int(k[0] - '1'); i < len(c.panes) {
+			c.cursor = i
+			c.toggle(i)
+		}
+	}
+	return false
+}
+
+func (c *cluster) toggle(i int) {
+	if p := c.panes[i]; !p.closed {
+		p.enabled = !p.enabled
+	}
+}
+
+// broadcast 把输入发送到所有启用的会话
+func (c *cluster) broadcast(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	for _, p := range c.panes {
+		if p.enabled {
+			p.term.Write(b)
+		}
+	}
+}
+
+// relayout 按终端大小重新排列面板，并同步修改会话的终端大小
+func (c *cluster) relayout() {
+	rects, err := layout(len(c.panes), c.width, c.height)
+	if err != nil {
+		// 终端过小时保持原来的布局
+		return
+	}
+	for i, p := range c.panes {
+		if p.rect != rects[i] && !p.closed {
+			p.term.Resize(rects[i].w, rects[i].h-1)
+		}
+		p.rect = rects[i]
+	}
+	c.dirty = true
+	c.clear = true
+}
+
+// layout 把终端分成 n 个面板，最后一行为状态栏，列之间用竖线分隔
+func layout(n, width, height int) ([]paneRect, error) {
+	cols := int(math.Ceil(math.Sqrt(float64(n))))
+	rows := (n + cols - 1) / cols
+	w := (width - (cols - 1)) / cols
+	h := (height - 1) / rows
+	if w < 10 || h < 2 {
+		return nil, fmt.Errorf("terminal is too small for %d sessions", n)
+	}
+
+	rects := make([]paneRect, n)
+	for i := range rects {
+		row, col := i/cols, i%cols
+		r := paneRect{x: col * (w + 1), y: row * h, w: w, h: h}
+		// 最后一列和最后一行占用剩余的空间
+		if col == cols-1 {
+			r.w = width - r.x
+		}
+		if row == rows-1 {
+			r.h = height - 1 - r.y
+		}
+		rects[i] = r
+	}
+	return rects, nil
+}
+
+// render 重新绘制所有面板和状态栏，光标显示在选中的面板中
+func (c *cluster) render() {
+	c.dirty = false
+	fmt.Fprint(c.out, "\x1b[?25l")
+	if c.clear {
+		fmt.Fprint(c.out, "\x1b[2J")
+		c.clear = false
+	}
+
+	cursor := ""
+	enabled := 0
+	for i, p := range c.panes {
+		r := p.rect
+		fmt.Fprintf(c.out, "\x1b[%d;%dH%s", r.y+1, r.x+1, c.header(i, p))
+		lines, cy, cx := p.screen.view(r.w, r.h-1)
+		for j := 0; j < r.h-1; j++ {
+			line := ""
+			if j < len(lines) {
+				line = lines[j]
+			}
+			fmt.Fprintf(c.out, "\x1b[%d;%dH%s", r.y+2+j, r.x+1, pad(line, r.w))
+		}
+		if r.x > 0 {
+			for j := 0; j < r.h; j++ {
+				fmt.Fprintf(c.out, "\x1b[%d;%dH%s", r.y+1+j, r.x, paint("muted", "│"))
+			}
+		}
+		if i == c.cursor && !p.closed {
+			cursor = fmt.Sprintf("\x1b[%d;%dH\x1b[?25h", r.y+2+cy, r.x+1+cx)
+		}
+		if p.enabled {
+			enabled++
+		}
+	}
+
+	status := fmt.Sprintf("sending to %d/%d sessions  ctrl-] for commands", enabled, len(c.panes))
+	if c.prefix {
+		status = clusterHelp
+	}
+	fmt.Fprintf(c.out, "\x1b[%d;1H%s\x1b[K%s", c.height, paint("muted", truncate(status, c.width-1)), cursor)
+	c.out.Flush()
+}
+
+// header 返回面板的标题，选中的面板反色显示
+func (c *cluster) header(i int, p *clusterPane) string {
+	mark := paint("ok", "●")
+	switch {
+	case p.closed:
+		mark = paint("error", "✕")
+	case !p.enabled:
+		mark = paint("muted", "○")
+	}
+	title := fmt.Sprintf("%d %s", i+1, p.term.Node.Name)
+	if p.closed {
+		title += " (closed)"
+	}
+	title = pad(title, p.rect.w-2)
+	if i == c.cursor {
+		title = "\x1b[7m" + title + "\x1b[0m"
+	}
+	return mark + " " + title
+}
+
+// paneWriter 把会话输出写入面板
+type paneWriter struct {
+	c *cluster
+	p *clusterPane
+}
+
+func (w *paneWriter) Write(b []byte) (int, error) {
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	w.p.screen.write(b)
+	w.c.dirty = true
+	return len(b), nil
+}
+
+// 控制序列解析状态
+const (
+	stateText = iota
+	stateEscape
+	stateCharset
+	stateCSI
+	stateOSC
+	stateOSCEscape
+)
+
+// paneScreen 按行保存会话的输出，处理换行、退格和常用的行编辑控制序列，忽略颜色和光标定位
+// 会话使用 dumb 终端，正常的程序不会输出光标定位
+type paneScreen struct {
+	lines   [][]rune
+	line    []rune
+	col     int
+	state   int
+	params  []byte
+	pending []byte
+}
+
+func (s *paneScreen) write(b []byte) {
+	b = append(s.pending, b...)
+	s.pending = nil
+	for len(b) > 0 {
+		if !utf8.FullRune(b) {
+			s.pending = append([]byte(nil), b...)
+			return
+		}
+		r, size := utf8.DecodeRune(b)
+		b = b[size:]
+		s.feed(r)
+	}
+}
+
+func (s *paneScreen) feed(r rune) {
+	switch s.state {
+	case stateEscape:
+		switch r {
+		case '[':
+			s.state, s.params = stateCSI, s.params[:0]
+		case ']':
+			s.state = stateOSC
+		case '(', ')', '*', '+', '#':
+			s.state = stateCharset
+		default:
+			s.state = stateText
+		}
+		return
+	case stateCharset:
+		s.state = stateText
+		return
+	case stateCSI:
+		if r >= 0x20 && r <= 0x3f {
+			s.params = append(s.params, byte(r))
+			return
+		}
+		s.state = stateText
+		s.csi(r)
+		return
+	case stateOSC:
+		switch r {
+		case '\a':
+			s.state = stateText
+		case 0x1b:
+			s.state = stateOSCEscape
+		}
+		return
+	case stateOSCEscape:
+		s.state = stateText
+		return
+	}
+
+	switch r {
+	case 0x1b:
+		s.state = stateEscape
+	case '\r':
+		s.col = 0
+	case '\n':
+		s.lines = append(s.lines, s.line)
+		if len(s.lines) > maxPaneLines {
+			s.lines = s.lines[len(s.lines)-maxPaneLines:]
+		}
+		s.line, s.col = nil, 0
+	case '\b':
+		if s.col > 0 {
+			s.col--
+		}
+	case '\t':
+		for s.put(' '); s.col%8 != 0; {
+			s.put(' ')
+		}
+	default:
+		if r >= 0x20 && r != 0x7f {
+			s.put(r)
+		}
+	}
+}
+
+// put 在光标处写入字符，光标后移
+func (s *paneScreen) put(r rune) {
+	for len(s.line) < s.col {
+		s.line = append(s.line, ' ')
+	}
+	if s.col < len(s.line) {
+		s.line[s.col] = r
+	} else {
+		s.line = append(s.line, r)
+	}
+	s.col++
+}
+
+// csi 处理控制序列，只处理影响当前行内容的序列和清屏
+func (s *paneScreen) csi(final rune) {
+	n, err := strconv.Atoi(strings.TrimLeft(string(s.params), "?"))
+	if err != nil || n < 1 {
+		n = 1
+	}
+	switch final {
+	case 'C':
+		s.col += n
+	case 'D':
+		if s.col -= n; s.col < 0 {
+			s.col = 0
+		}
+	case 'G':
+		s.col = n - 1
+	case 'K':
+		switch string(s.params) {
+		case "", "0":
+			if s.col < len(s.line) {
+				s.line = s.line[:s.col]
+			}
+		case "1":
+			for i := 0; i < s.col && i < len(s.line); i++ {
+				s.line[i] = ' '
+			}
+		case "2":
+			s.line = nil
+		}
+	case 'P':
+		if s.col < len(s.line) {
+			end := s.col + n
+			if end > len(s.line) {
+				end = len(s.line)
+			}
+			s.line = append(s.line[:s.col], s.line[end:]...)
+		}
+	case '@':
+		if s.col < len(s.line) {
+			blank := []rune(strings.Repeat(" ", n))
+			s.line = append(s.line[:s.col], append(blank, s.line[s.col:]...)...)
+		}
+	case 'X':
+		for i := s.col; i < s.col+n && i < len(s.line); i++ {
+			s.line[i] = ' '
+		}
+	case 'J':
+		if p := string(s.params); p == "2" || p == "3" {
+			s.lines, s.line, s.col = nil, nil, 0
+		}
+	}
+}
+
+// view 返回面板中显示的最后 h 行，长行按宽度 w 折行，以及光标在其中的位置
+func (s *paneScreen) view(w, h int) ([]string, int, int) {
+	wrap := func(line []rune) []string {
+		var rows []string
+		for len(line) > w {
+			rows = append(rows, string(line[:w]))
+			line = line[w:]
+		}
+		return append(rows, string(line))
+	}
+
+	current := wrap(s.line)
+	cy, cx := s.col/w, s.col%w
+	for len(current) <= cy {
+		current = append(current, "")
+	}
+	rows := current
+	for i := len(s.lines) - 1; i >= 0 && len(rows) < h; i-- {
+		rows = append(wrap(s.lines[i]), rows...)
+	}
+	cy += len(rows) - len(current)
+	if len(rows) > h {
+		cy -= len(rows) - h
+		rows = rows[len(rows)-h:]
+	}
+	// 光标所在行被滚出面板时显示在第一行
+	if cy < 0 {
+		cy = 0
+	}
+	return rows, cy, cx
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// screenOf 把输出分多次写入新的面板
+func screenOf(chunks ...string) *paneScreen {
+	s := new(paneScreen)
+	for _, c := range chunks {
+		s.write([]byte(c))
+	}
+	return s
+}
+
+func screenLines(s *paneScreen) []string {
+	var lines []string
+	for _, l := range s.lines {
+		lines = append(lines, string(l))
+	}
+	return append(lines, string(s.line))
+}
+
+func TestPaneScreen(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+		col    int
+	}{
+		{"lines", []string{"one\r\ntwo\r\nthree"}, []string{"one", "two", "three"}, 5},
+		{"carriage return overwrites", []string{"hello\rj"}, []string{"jello"}, 1},
+		{"backspace", []string{"abc\b\bX"}, []string{"aXc"}, 2},
+		{"backspace at line start", []string{"\b\bab"}, []string{"ab"}, 2},
+		{"tab", []string{"a\tb"}, []string{"a       b"}, 9},
+		{"colors ignored", []string{"\x1b[1;31mred\x1b[0m text"}, []string{"red text"}, 8},
+		{"title ignored", []string{"\x1b]0;user@host\aprompt$ ", "\x1b]2;x\x1b\\ok"}, []string{"prompt$ ok"}, 10},
+		{"charset ignored", []string{"\x1b(Bab"}, []string{"ab"}, 2},
+		{"split escape", []string{"a\x1b[", "3", "1mb"}, []string{"ab"}, 2},
+		{"split utf-8", []string{"中"[:1], "中"[1:] + "文"}, []string{"中文"}, 2},
+		{"erase to end", []string{"hello\x1b[3D\x1b[K"}, []string{"he"}, 2},
+		{"erase to start", []string{"hello\x1b[2D\x1b[1K"}, []string{"   lo"}, 3},
+		{"erase line", []string{"hello\x1b[2K"}, []string{""}, 5},
+		{"cursor forward pads", []string{"a\x1b[3Cb"}, []string{"a   b"}, 5},
+		{"cursor column", []string{"abcdef\x1b[3GX"}, []string{"abXdef"}, 3},
+		{"delete chars", []string{"abcdef\x1b[5D\x1b[2P"}, []string{"adef"}, 1},
+		{"insert chars", []string{"abc\x1b[2D\x1b[2@"}, []string{"a  bc"}, 1},
+		{"erase chars", []string{"abcdef\x1b[5D\x1b[3X"}, []string{"a   ef"}, 1},
+		{"clear screen", []string{"one\r\ntwo\x1b[H\x1b[2Jthree"}, []string{"three"}, 5},
+		{"private mode ignored", []string{"\x1b[?2004hab\x1b[?2004l"}, []string{"ab"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := screenOf(tt.chunks...)
+			if got := screenLines(s); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("lines: got %q, want %q", got, tt.want)
+			}
+			if s.col != tt.col {
+				t.Fatalf("col: got %d, want %d", s.col, tt.col)
+			}
+		})
+	}
+}
+
+func TestPaneScreenLimit(t *testing.T) {
+	s := screenOf(strings.Repeat("x\n", maxPaneLines+5))
+	if len(s.lines) != maxPaneLines {
+		t.Fatalf("kept %d lines", len(s.lines))
+	}
+}
+
+func TestPaneScreenView(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		w, h   int
+		rows   []string
+		cy, cx int
+	}{
+		{"fits", "a\r\nb\r\n$ ", 10, 5, []string{"a", "b", "$ "}, 2, 2},
+		{"scrolls", "1\r\n2\r\n3\r\n4\r\n$ ", 10, 3, []string{"3", "4", "$ "}, 2, 2},
+		{"wraps long lines", "abcdefgh\r\n$ ", 3, 5, []string{"abc", "def", "gh", "$ "}, 3, 2},
+		{"wraps current line", "1\r\nabcdefg", 3, 3, []string{"abc", "def", "g"}, 2, 1},
+		{"cursor at wrap", "abc", 3, 3, []string{"abc", ""}, 1, 0},
+		{"empty", "", 10, 3, []string{""}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, cy, cx := screenOf(tt.output).view(tt.w, tt.h)
+			if !reflect.DeepEqual(rows, tt.rows) || cy != tt.cy || cx != tt.cx {
+				t.Fatalf("got %q (%d, %d), want %q (%d, %d)", rows, cy, cx, tt.rows, tt.cy, tt.cx)
+			}
+		})
+	}
+}
+
+func TestLayout(t *testing.T) {
+	tests := []struct {
+		n, width, height int
+		want             []paneRect
+	}{
+		{1, 80, 24, []paneRect{{0, 0, 80, 23}}},
+		{2, 80, 24, []paneRect{{0, 0, 39, 23}, {40, 0, 40, 23}}},
+		{3, 80, 24, []paneRect{{0, 0, 39, 11}, {40, 0, 40, 11}, {0, 11, 39, 12}}},
+		{4, 81, 25, []paneRect{{0, 0, 40, 12}, {41, 0, 40, 12}, {0, 12, 40, 12}, {41, 12, 40, 12}}},
+		{5, 100, 31, []paneRect{{0, 0, 32, 15}, {33, 0, 32, 15}, {66, 0, 34, 15}, {0, 15, 32, 15}, {33, 15, 32, 15}}},
+	}
+	for _, tt := range tests {
+		got, err := layout(tt.n, tt.width, tt.height)
+		if err != nil {
+			t.Fatalf("layout(%d, %d, %d): %v", tt.n, tt.width, tt.height, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("layout(%d, %d, %d):\n got %v\nwant %v", tt.n, tt.width, tt.height, got, tt.want)
+		}
+	}
+
+	// 面板不重叠，列之间留出分隔线，并且不超出状态栏
+	for n := 1; n <= 16; n++ {
+		rects, err := layout(n, 200, 60)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cells := make(map[[2]int]bool)
+		for _, r := range rects {
+			if r.x+r.w > 200 || r.y+r.h > 59 {
+				t.Fatalf("%d panes: %v outside the screen", n, r)
+			}
+			for y := r.y; y < r.y+r.h; y++ {
+				for x := r.x - 1; x < r.x+r.w; x++ {
+					if x < 0 {
+						continue
+					}
+					if cells[[2]int{x, y}] {
+						t.Fatalf("%d panes: %v overlaps", n, r)
+					}
+					cells[[2]int{x, y}] = true
+				}
+			}
+		}
+	}
+
+	for _, size := range [][3]int{{2, 20, 24}, {1, 9, 24}, {4, 80, 4}, {1, 80, 2}} {
+		if _, err := layout(size[0], size[1], size[2]); err == nil {
+			t.Errorf("layout(%d, %d, %d) accepted a too small terminal", size[0], size[1], size[2])
+		}
+	}
+}
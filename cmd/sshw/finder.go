@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/zdev0x/sshw"
+	"golang.org/x/term"
+)
+
+// 搜索结果最多显示的行数
+const finderSize = 15
+
+// finder 全局模糊搜索，输入时实时按得分排序
+type finder struct {
+	nodes   []*sshw.Node
+	query   []rune
+	results []*sshw.SearchResult
+	cursor  int
+	offset  int
+	width   int
+	out     *bufio.Writer
+}
+
+// find 打开全局搜索，返回选中的节点，取消时返回 nil
+func find(nodes []*sshw.Node, query string) *sshw.Node {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	defer term.Restore(fd, state)
+
+	f := &finder{nodes: nodes, query: []rune(query), out: bufio.NewWriter(os.Stdout)}
+	f.search()
+
+	buf := make([]byte, 64)
+	for {
+		f.render()
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			f.clear()
+			return nil
+		}
+		if done, node := f.handle(buf[:n]); done {
+			f.clear()
+			return node
+		}
+	}
+}
+
+func (f *finder) search() {
+	f.results = sshw.Search(f.nodes, string(f.query))
+	f.cursor, f.offset = 0, 0
+}
+
+// handle 处理一次按键，返回是否结束及选中的节点
+func (f *finder) handle(b []byte) (bool, *sshw.Node) {
+	switch string(b) {
+	case "\r":
+		if len(f.results) == 0 {
+			return false, nil
+		}
+		return true, f.results[f.cursor].Node
+	case "\x1b", "\x03":
+		return true, nil
+	case "\x04":
+		if len(f.query) == 0 {
+			return true, nil
+		}
+	case "\x1b[A", "\x1bOA", "\x10", "\x0b":
+		f.move(-1)
+	case "\x1b[B", "\x1bOB", "\x0e", "\n", "\t":
+		f.move(1)
+	case "\x1b[5~":
+		f.move(-finderSize)
+	case "\x1b[6~":
+		f.move(finderSize)
+	case "\x7f", "\x08":
+		if len(f.query) > 0 {
+			f.query = f.query[:len(f.query)-1]
+			f.search()
+		}
+	case "\x15":
+		f.query = nil
+		f.search()
+	case "\x17":
+		q := strings.TrimRight(string(f.query), " ")
+		f.query = []rune(q[:strings.LastIndex(q, " ")+1])
+		f.search()
+	default:
+		if b[0] == 0x1b {
+			return false, nil
+		}
+		changed := false
+		for len(b) > 0 {
+			r, size := utf8.DecodeRune(b)
+			b = b[size:]
+			if unicode.IsPrint(r) {
+				f.query = append(f.query, r)
+				changed = true
+			}
+		}
+		if changed {
+			f.search()
+		}
+	}
+	return false, nil
+}
+
+func (f *finder) move(delta int) {
+	if len(f.results) == 0 {
+		return
+	}
+	f.cursor += delta
+	if f.cursor < 0 {
+		f.cursor = 0
+	}
+	if f.cursor >= len(f.results) {
+		f.cursor = len(f.results) - 1
+	}
+	if f.cursor < f.offset {
+		f.offset = f.cursor
+	}
+	if f.cursor >= f.offset+finderSize {
+		f.offset = f.cursor - finderSize + 1
+	}
+}
+
+// render 在当前位置重绘搜索框和结果，光标停在搜索框
+func (f *finder) render() {
+	f.width = 80
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+		f.width = w
+	}
+
+	fmt.Fprint(f.out, "\r\x1b[J")
+	fmt.Fprintf(f.out, "%s %s", paint("label", ">"), string(f.query))
+
+	lines := 0
+	end := f.offset + finderSize
+	if end > len(f.results) {
+		end = len(f.results)
+	}
+	for i := f.offset; i < end; i++ {
+		fmt.Fprint(f.out, "\r\n", f.line(f.results[i].Node, i == f.cursor))
+		lines++
+	}
+	fmt.Fprint(f.out, "\r\n", paint("muted", fmt.Sprintf("  %d/%d", len(f.results), len(sshw.Leaves(f.nodes)))))
+	lines++
+
+	fmt.Fprintf(f.out, "\x1b[%dA\r\x1b[%dC", lines, 2+textWidth(string(f.query)))
+	f.out.Flush()
+}
+
+// line 生成一行结果：分组路径、名称、别名和主机，超出终端宽度时截断
+func (f *finder) line(n *sshw.Node, active bool) string {
+	group := ""
+	if i := strings.LastIndex(n.Path(), "/"); i >= 0 {
+		group = n.Path()[:i+1]
+	}
+	alias := ""
+	if n.Alias != "" {
+		alias = "(" + n.Alias + ")"
+	}
+	host := ""
+	if n.ShowHost && n.Host != "" {
+		host = " " + n.GetMaskedHost()
+		if n.User != "" {
+			host = " " + n.User + "@" + n.GetMaskedHost()
+		}
+	}
+
+	// 按显示宽度依次截断主机、分组
+	avail := f.width - 3
+	parts := []*string{&host, &group, &alias}
+	for _, p := range parts {
+		over := textWidth(group+n.Name+alias+host) - avail
+		if over <= 0 {
+			break
+		}
+		*p = truncate(*p, textWidth(*p)-over)
+	}
+
+	name := n.Name
+	prefix := "  "
+	if active {
+		prefix = paint("primary", "➤") + " "
+		name = "\x1b[1m" + paint("primary", name) + "\x1b[0m"
+	}
+	return prefix + paint("muted", group) + name + paint("accent", alias) + paint("muted", host)
+}
+
+// clear 清除搜索界面
+func (f *finder) clear() {
+	fmt.Fprint(f.out, "\r\x1b[J")
+	f.out.Flush()
+}
+
+// textWidth 返回字符串在终端中的显示宽度，中日韩文字和表情占两列
+func textWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		w += runeWidth(r)
+	}
+	return w
+}
+
+func runeWidth(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0xa4cf,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1faff:
+		return 2
+	}
+	return 1
+}
+
+// truncate 截断到指定显示宽度
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	w := 0
+	for i, r := range s {
+		w += runeWidth(r)
+		if w > width {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// searchNode 按查询搜索节点，只有一个匹配时直接返回，否则打开搜索界面
+// 非终端环境下列出所有匹配项
+func searchNode(query string) *sshw.Node {
+	nodes := sshw.GetConfig()
+	if query != "" {
+		results := sshw.Search(nodes, query)
+		if len(results) == 1 {
+			return results[0].Node
+		}
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			if len(results) == 0 {
+				log.Error("no node matches", query)
+				os.Exit(1)
+			}
+			for _, r := range results {
+				fmt.Println(r.Node.Path())
+			}
+			os.Exit(1)
+		}
+	}
+	return find(nodes, query)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/zdev0x/sshw"
+)
+
+// 选择列表中显示的最近登录数
+const recentSize = 10
+
+const favUsage = `usage:
+  sshw fav list
+  sshw fav add <alias | path>
+  sshw fav rm <alias | path>`
+
+// topNodes 返回选择列表的第一层，收藏和最近登录作为虚拟分组显示在最前面
+func topNodes() []*sshw.Node {
+	nodes := sshw.GetConfig()
+	h, err := sshw.LoadHistory()
+	if err != nil {
+		log.Error(err)
+		return nodes
+	}
+
+	var top []*sshw.Node
+	if favs := h.FavouriteNodes(nodes); len(favs) > 0 {
+		top = append(top, &sshw.Node{Name: "★ Favourites", Children: favs})
+	}
+	if recent := h.Recent(nodes, recentSize); len(recent) > 0 {
+		top = append(top, &sshw.Node{Name: "↺ Recent", Children: recent})
+	}
+	return append(top, nodes...)
+}
+
+// lastNode 返回最后一次登录的节点
+func lastNode() *sshw.Node {
+	h, err := sshw.LoadHistory()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	node := h.Last(sshw.GetConfig())
+	if node == nil {
+		log.Error("no previous login found")
+		os.Exit(1)
+	}
+	return node
+}
+
+// favCommand 管理收藏的节点
+func favCommand(args []string) {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	if args[0] != "list" && len(args) < 2 {
+		fmt.Println(favUsage)
+		os.Exit(1)
+	}
+
+	if _, err := unlockConfig(); err != nil {
+		log.Error("Failed to load config:", err)
+		os.Exit(1)
+	}
+	nodes := sshw.GetConfig()
+
+	var err error
+	switch args[0] {
+	case "list":
+		h, err := sshw.LoadHistory()
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		for _, n := range h.FavouriteNodes(nodes) {
+			if n.Alias != "" {
+				fmt.Printf("%s (%s)\n", n.Path(), n.Alias)
+			} else {
+				fmt.Println(n.Path())
+			}
+		}
+		return
+	case "add":
+		node, rerr := sshw.Resolve(nodes, args[1])
+		switch {
+		case rerr == sshw.ErrNodeNotFound:
+			rerr = fmt.Errorf("node not found: %s", args[1])
+		case rerr == nil && len(node.Children) > 0:
+			rerr = fmt.Errorf("%s is a group", node.Path())
+		}
+		if rerr != nil {
+			log.Error(rerr)
+			os.Exit(1)
+		}
+		err = sshw.UpdateHistory(func(h *sshw.History) error {
+			if !h.AddFavourite(node) {
+				fmt.Printf("%s is already a favourite\n", args[1])
+			}
+			return nil
+		})
+	case "rm":
+		err = sshw.UpdateHistory(func(h *sshw.History) error {
+			if !h.RemoveFavourite(nodes, args[1]) {
+				return fmt.Errorf("not a favourite: %s", args[1])
+			}
+			return nil
+		})
+	default:
+		fmt.Println(favUsage)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		log.Error("Failed to save favourites:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// openInput 返回终端输入，这些平台上无法中断正在等待的 Read，退出后会读走下一个按键
+func openInput() (io.ReadCloser, error) {
+	return io.NopCloser(os.Stdin), nil
+}
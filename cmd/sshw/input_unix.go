@@ -0,0 +1,34 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// openInput 返回可以中断的终端输入，Close 时正在等待的 Read 立即返回，之后的按键不会被读走
+// 复制标准输入并设置为非阻塞，由 Go 的 poller 等待输入
+func openInput() (io.ReadCloser, error) {
+	fd, err := syscall.Dup(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &input{File: os.NewFile(uintptr(fd), "stdin")}, nil
+}
+
+type input struct {
+	*os.File
+}
+
+// Close 关闭复制的描述符，并把与之共享状态的标准输入恢复为阻塞模式
+func (in *input) Close() error {
+	err := in.File.Close()
+	syscall.SetNonblock(int(os.Stdin.Fd()), false)
+	return err
+}
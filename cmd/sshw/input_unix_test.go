@@ -0,0 +1,54 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOpenInputClose(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	old := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = old }()
+
+	in, err := openInput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("a"))
+	buf := make([]byte, 8)
+	if n, err := in.Read(buf); err != nil || string(buf[:n]) != "a" {
+		t.Fatalf("read %q, %v", buf[:n], err)
+	}
+
+	// 关闭后等待中的 Read 立即返回
+	done := make(chan error, 1)
+	go func() {
+		_, err := in.Read(buf)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	in.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("read after close succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read not interrupted by close")
+	}
+
+	// 之后的输入没有被读走
+	w.Write([]byte("b"))
+	if n, err := os.Stdin.Read(buf); err != nil || string(buf[:n]) != "b" {
+		t.Fatalf("stdin read %q, %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zdev0x/sshw"
+	"golang.org/x/crypto/ssh"
+)
+
+// keysCommand 列出配置中使用的私钥及证书的主体和有效期
+func keysCommand(args []string) {
+	if _, err := unlockConfig(); err != nil {
+		log.Error("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tCERTIFICATE\tPRINCIPALS\tVALID\tNODES")
+	for _, k := range sshw.ListKeys(sshw.GetConfig()) {
+		cert, principals, valid := "-", "-", "-"
+		if k.Cert != nil {
+			cert = k.CertPath
+			if len(k.Cert.ValidPrincipals) > 0 {
+				principals = strings.Join(k.Cert.ValidPrincipals, ",")
+			}
+			status, _ := sshw.CertStatus(k.Cert, time.Now())
+			valid = fmt.Sprintf("%s (%s)", certValidity(k.Cert), status)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k.KeyPath, cert, principals, valid, strings.Join(k.Nodes, ","))
+	}
+	w.Flush()
+}
+
+// certValidity 格式化证书有效期
+func certValidity(cert *ssh.Certificate) string {
+	const layout = "2006-01-02 15:04"
+	from, to := "always", "forever"
+	if cert.ValidAfter != 0 {
+		from = time.Unix(int64(cert.ValidAfter), 0).Format(layout)
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		to = time.Unix(int64(cert.ValidBefore), 0).Format(layout)
+	}
+	return from + " ~ " + to
+}
@@ -6,14 +6,14 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"syscall"
 
 	"github.com/manifoldco/promptui"
 	"github.com/zdev0x/sshw"
 	"github.com/zdev0x/sshw/masterkey"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
-const prev = "-parent-"
-
 var (
 	Build                 = "devel"
 	showVersion           = flag.Bool("version", false, "show version")
@@ -21,31 +21,38 @@ var (
 	useLocalSSHConfig     = flag.Bool("s", false, "use local ssh config '~/.ssh/config'")
 	encryptConfig         = flag.Bool("encrypt", false, "encrypt configuration file")
 	decryptConfig         = flag.Bool("decrypt", false, "decrypt configuration file")
+	encryptFile           = flag.Bool("encrypt-file", false, "encrypt the whole configuration file")
+	encryptFields         = flag.String("encrypt-fields", "", "comma separated fields to encrypt, e.g. host,user,password or node")
 	checkEncryptionStatus = flag.Bool("check", false, "check configuration file encryption status")
 	setMasterPassword     = flag.Bool("set-master-password", false, "set master password")
 	changeMasterPassword  = flag.Bool("change-master-password", false, "change master password")
 	removeMasterPassword  = flag.Bool("remove-master-password", false, "remove master password")
 	configFile            = flag.String("config", "", "specify configuration file path")
+	identityFile          = flag.String("identity", "", "identity file used to decrypt a config shared with recipients")
+	fuzzySearch           = flag.Bool("f", false, "fuzzy search all nodes, e.g. sshw -f prod db")
+	useTUI                = flag.Bool("tui", false, "use the full-screen interface")
+	tagFilter             = flag.String("tag", "", "only show nodes with all of the comma separated tags, e.g. prod,db")
+	tmuxMode              = flag.String("tmux", "", "open the selected node in a new tmux 'window' or split 'pane'")
 
 	log = sshw.GetLogger()
 
+	// 默认模板，颜色使用主题中的 label、primary、accent、muted
 	templates = &promptui.SelectTemplates{
-		Label:    "✨ {{ . | green}}",
-		Active:   "➤ {{ .Name | cyan  }}{{if .Alias}}({{.Alias | yellow}}){{end}}{{if .ShowHost}}{{if .Host}}{{if .User}}{{` ` | faint}}{{.User | faint}}{{`@` | faint}}{{end}}{{.GetMaskedHost | faint}}{{end}}{{end}}",
-		Inactive: "  {{.Name | faint}}{{if .Alias}}({{.Alias | faint}}){{end}}{{if .ShowHost}}{{if .Host}}{{if .User}}{{` ` | faint}}{{.User | faint}}{{`@` | faint}}{{end}}{{.GetMaskedHost | faint}}{{end}}{{end}}",
+		Label:    "✨ {{ . | label }}",
+		Active:   "➤ {{ .Name | primary }}{{if .Alias}}({{.Alias | accent}}){{end}}{{if .ShowHost}}{{if .Host}}{{if .User}}{{` ` | muted}}{{.User | muted}}{{`@` | muted}}{{end}}{{.GetMaskedHost | muted}}{{end}}{{end}}",
+		Inactive: "  {{.Name | muted}}{{if .Alias}}({{.Alias | muted}}){{end}}{{if .ShowHost}}{{if .Host}}{{if .User}}{{` ` | muted}}{{.User | muted}}{{`@` | muted}}{{end}}{{.GetMaskedHost | muted}}{{end}}{{end}}",
 	}
 )
 
-func findAlias(nodes []*sshw.Node, nodeAlias string) *sshw.Node {
-	for _, node := range nodes {
-		if node.Alias == nodeAlias {
-			return node
-		}
-		if len(node.Children) > 0 {
-			return findAlias(node.Children, nodeAlias)
-		}
-	}
-	return nil
+// commands 子命令
+var commands = map[string]func(args []string){
+	"recipients": recipientsCommand,
+	"agent":      agentCommand,
+	"lock":       lockCommand,
+	"keys":       keysCommand,
+	"fav":        favCommand,
+	"tmux":       tmuxCommand,
+	"cssh":       clusterCommand,
 }
 
 func main() {
@@ -67,6 +74,8 @@ func main() {
 		return
 	}
 
+	setPasswordProviders()
+
 	// 处理主密码管理命令
 	if *setMasterPassword {
 		if err := masterkey.SetMasterPassword(); err != nil {
@@ -77,7 +86,7 @@ func main() {
 	}
 
 	if *changeMasterPassword {
-		if err := masterkey.ChangeMasterPassword(); err != nil {
+		if err := masterkey.ChangeMasterPassword(rekeyConfig); err != nil {
 			log.Error("Failed to change master password:", err)
 			os.Exit(1)
 		}
@@ -92,12 +101,26 @@ func main() {
 		return
 	}
 
+	// 处理子命令，"--" 之后的参数总是作为登录目标
+	explicit := explicitTarget(os.Args[1:], flag.Args())
+	if flag.NArg() > 0 && !explicit {
+		if cmd, ok := commands[flag.Arg(0)]; ok {
+			cmd(flag.Args()[1:])
+			return
+		}
+	}
+
 	// 处理加密相关命令
-	if *encryptConfig || *decryptConfig || *checkEncryptionStatus {
+	if *encryptConfig || *encryptFile || *decryptConfig || *checkEncryptionStatus {
 		handleEncryptionCommands()
 		return
 	}
 
+	if err := checkTmuxMode(); err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
 	if *useLocalSSHConfig {
 		err := sshw.LoadSshConfig()
 		if err != nil {
@@ -105,64 +128,320 @@ func main() {
 			os.Exit(1)
 		}
 	} else {
-		// 检查配置是否加密
-		encrypted, err := sshw.IsConfigEncrypted(*configFile)
-		if err != nil {
-			log.Error("Failed to check config encryption status:", err)
+		// 加载配置，加密时解锁
+		if _, err := unlockConfig(); err != nil {
+			log.Error("load config error", err)
 			os.Exit(1)
 		}
+	}
+	if err := applyTheme(); err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	for _, msg := range reservedNames(sshw.GetConfig()) {
+		log.Error(msg)
+	}
 
-		var password []byte
-		if encrypted {
-			// 如果配置已加密，需要获取主密码
-			password, err = masterkey.GetMasterPassword()
-			if err != nil {
-				log.Error("Failed to get master password:", err)
-				os.Exit(1)
-			}
+	// 全局模糊搜索或按标签筛选，唯一匹配时直接连接
+	if *fuzzySearch || *tagFilter != "" {
+		query := strings.Join(flag.Args(), " ")
+		if *tagFilter != "" {
+			query = strings.TrimSpace("tag:" + *tagFilter + " " + query)
 		}
-
-		// 加载配置
-		err = sshw.LoadConfig(password, *configFile)
-		if err != nil {
-			log.Error("load config error", err)
-			os.Exit(1)
+		if node := searchNode(query); node != nil {
+			login(node)
 		}
+		return
+	}
+
+	// 重新连接上一次登录的节点
+	if flag.Arg(0) == "-" && !explicit {
+		login(lastNode())
+		return
 	}
 
-	// login by alias
-	if len(os.Args) > 1 {
-		var nodeAlias = os.Args[1]
-		var nodes = sshw.GetConfig()
-		var node = findAlias(nodes, nodeAlias)
-		if node != nil {
-			client := sshw.NewClient(node)
-			client.Login()
+	// 按别名或路径登录，匹配到分组时在该分组中选择，没有匹配时打开选择列表
+	// 在选择列表中标记了多个节点时执行批量操作
+	var node *sshw.Node
+	if flag.NArg() > 0 {
+		target, err := sshw.Resolve(sshw.GetConfig(), flag.Arg(0))
+		switch {
+		case err == sshw.ErrNodeNotFound:
+		case err != nil:
+			log.Error(err)
+			os.Exit(1)
+		case *useTUI && len(target.Children) > 0:
+			runTUI(target)
+			return
+		case len(target.Children) > 0:
+			open(choose(topNodes(), target))
 			return
+		default:
+			node = target
 		}
 	}
 
-	node := choose(nil, sshw.GetConfig())
+	if node == nil && *useTUI {
+		runTUI(nil)
+		return
+	}
 	if node == nil {
+		open(choose(topNodes(), nil))
 		return
 	}
 
-	client := sshw.NewClient(node)
-	client.Login()
+	login(node)
+}
+
+// explicitTarget 判断剩余参数 rest 之前是否有结束参数解析的 "--"
+// 此时第一个参数按别名或路径登录，即使与子命令同名或为 "-"
+func explicitTarget(args, rest []string) bool {
+	i := len(args) - len(rest) - 1
+	return len(rest) > 0 && i >= 0 && args[i] == "--"
+}
+
+// reservedNames 返回与子命令或 "-" 同名的别名和顶层节点的提示，这些节点需要使用 "sshw -- <别名>" 登录
+func reservedNames(nodes []*sshw.Node) []string {
+	reserved := func(s string) bool {
+		_, ok := commands[s]
+		return ok || s == "-"
+	}
+	var msgs []string
+	var walk func(nodes []*sshw.Node, top bool)
+	walk = func(nodes []*sshw.Node, top bool) {
+		for _, n := range nodes {
+			if n.Alias != "" && reserved(n.Alias) {
+				msgs = append(msgs, fmt.Sprintf("alias %q of %s conflicts with a sshw command, use 'sshw -- %s' to connect", n.Alias, n.Path(), n.Alias))
+			} else if top && reserved(n.Name) {
+				msgs = append(msgs, fmt.Sprintf("name %q conflicts with a sshw command, use 'sshw -- %s' to connect", n.Name, n.Name))
+			}
+			walk(n.Children, false)
+		}
+	}
+	walk(nodes, true)
+	return msgs
+}
+
+// unlockConfig 加载配置，加密时优先使用身份私钥解锁，否则使用主密码
+// 返回使用的主密码，未使用时为 nil
+func unlockConfig() ([]byte, error) {
+	err := sshw.LoadConfig(nil, *configFile)
+	if err != nil && err != sshw.ErrPasswordRequired {
+		return nil, err
+	}
+
+	encrypted, err := sshw.IsConfigEncrypted(*configFile)
+	if err != nil || !encrypted {
+		return nil, err
+	}
+
+	ids := sshw.LoadIdentities(identityFiles(), readPassphrase)
+	if len(ids) > 0 {
+		if err := sshw.LoadConfigWithIdentities(ids, *configFile); err == nil {
+			return nil, nil
+		}
+	}
+
+	password, err := masterkey.GetMasterPassword()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get master password: %v", err)
+	}
+	passwordUnlocked = true
+	return password, loadConfigWithPassword(password)
+}
+
+// loadConfigWithPassword 使用主密码加载配置
+// 旧版本误用密码哈希加密的配置，会在加载后改用主密码重新加密
+// 按字段派生密钥的旧格式配置会迁移到数据密钥格式
+func loadConfigWithPassword(password []byte) error {
+	err := sshw.LoadConfig(password, *configFile)
+	if err == nil {
+		migrateLegacyConfig(password)
+		return nil
+	}
+	legacy, lerr := masterkey.LegacyPassword()
+	if lerr != nil || sshw.LoadConfig(legacy, *configFile) != nil {
+		return err
+	}
+
+	// 重新加载成功后才删除旧版本的密码
+	if err := reencryptConfig(password); err != nil {
+		return err
+	}
+	log.Info("Configuration re-encrypted with the master password")
+	return masterkey.ClearLegacyPassword()
+}
+
+// migrateLegacyConfig 把按字段派生密钥的旧格式配置重新加密为数据密钥格式
+// 迁移失败时只提示，重新加载解密后的配置继续使用
+func migrateLegacyConfig(password []byte) {
+	if !sshw.IsLegacyEncrypted() {
+		return
+	}
+	if err := reencryptConfig(password); err != nil {
+		log.Error("Failed to migrate config to the data key format, run 'sshw -decrypt' and 'sshw -encrypt' to migrate it manually:", err)
+		if err := sshw.LoadConfig(password, *configFile); err != nil {
+			log.Error("Failed to reload config:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	log.Info("Configuration migrated to the data key format")
+}
+
+// reencryptConfig 用主密码重新加密已解锁的配置，保存到加载时使用的文件并重新加载验证
+func reencryptConfig(password []byte) error {
+	path, err := sshw.ConfigFile(*configFile)
+	if err != nil {
+		return err
+	}
+	if err := sshw.Rekey(password); err != nil {
+		return err
+	}
+	if err := sshw.SaveConfig(sshw.GetConfig(), path); err != nil {
+		return err
+	}
+	if err := sshw.LoadConfig(password, path); err != nil {
+		return fmt.Errorf("failed to verify re-encrypted config: %v", err)
+	}
+	return nil
+}
+
+// rekeyConfig 修改主密码时用新密码重新保护配置
+func rekeyConfig(old, new []byte) error {
+	encrypted, err := sshw.IsConfigEncrypted(*configFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !encrypted {
+		return nil
+	}
+
+	if err := loadConfigWithPassword(old); err != nil {
+		return err
+	}
+	// 只由公钥接收者保护的配置与主密码无关
+	if sshw.IsUnlocked() && !sshw.HasMasterPassword() {
+		return nil
+	}
+	if err := sshw.Rekey(new); err != nil {
+		return err
+	}
+	return sshw.SaveConfig(sshw.GetConfig(), *configFile)
+}
+
+// identityFiles 返回解锁配置时使用的身份文件
+func identityFiles() []string {
+	if *identityFile != "" {
+		return []string{*identityFile}
+	}
+	if f := os.Getenv("SSHW_IDENTITY"); f != "" {
+		return []string{f}
+	}
+	return sshw.DefaultIdentityFiles()
+}
+
+func readPassphrase(path string) ([]byte, error) {
+	fmt.Printf("Enter passphrase for %s: ", path)
+	b, err := terminal.ReadPassword(int(syscall.Stdin))
+	fmt.Println()
+	return b, err
 }
 
 func handleEncryptionCommands() {
-	// 加载配置以检查每个节点的状态
+	// 加载配置以检查每个节点的状态，整个文件加密时需要主密码才能查看
 	err := sshw.LoadConfig(nil, *configFile)
+	fileEncrypted := err == sshw.ErrPasswordRequired
+	if err != nil && !fileEncrypted {
+		log.Error("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	if fileEncrypted {
+		if *checkEncryptionStatus {
+			fmt.Println("Configuration file is encrypted as a whole")
+			return
+		}
+		if *encryptConfig || *encryptFile {
+			fmt.Println("Configuration file is already encrypted")
+			return
+		}
+	} else if checkFieldEncryption() {
+		return
+	}
+
+	// 解锁并重新加载配置
+	password, err := unlockConfig()
 	if err != nil {
 		log.Error("Failed to load config:", err)
 		os.Exit(1)
 	}
 
+	// 首次加密需要主密码包裹新的数据密钥
+	if password == nil && !sshw.IsUnlocked() && (*encryptConfig || *encryptFile) {
+		password, err = masterkey.GetMasterPassword()
+		if err != nil {
+			log.Error("Failed to get master password:", err)
+			os.Exit(1)
+		}
+	}
+
+	nodes := sshw.GetConfig()
+	if len(nodes) == 0 {
+		log.Error("No configuration found")
+		os.Exit(1)
+	}
+
+	if *encryptFile {
+		// 整个文件加密
+		if err := sshw.EncryptFile(nodes, password); err != nil {
+			log.Error("Failed to encrypt config:", err)
+			os.Exit(1)
+		}
+		if err := sshw.SaveConfig(nodes, *configFile); err != nil {
+			log.Error("Failed to save encrypted config:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration file encrypted successfully")
+	} else if *encryptConfig {
+		// 设置需要加密的字段
+		if *encryptFields != "" {
+			if err := sshw.SetEncryptedFields(strings.Split(*encryptFields, ",")); err != nil {
+				log.Error("Failed to set encrypted fields:", err)
+				os.Exit(1)
+			}
+		}
+		// 加密配置
+		if err := sshw.EncryptNodes(nodes, password); err != nil {
+			log.Error("Failed to encrypt config:", err)
+			os.Exit(1)
+		}
+		// 保存加密后的配置
+		if err := sshw.SaveConfig(nodes, *configFile); err != nil {
+			log.Error("Failed to save encrypted config:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration encrypted successfully")
+	} else if *decryptConfig {
+		// 加载时已使用主密码解密，直接保存解密后的配置
+		sshw.DisableFileEncryption()
+		if err := sshw.SaveConfig(nodes, *configFile); err != nil {
+			log.Error("Failed to save decrypted config:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration decrypted successfully")
+	}
+}
+
+// checkFieldEncryption 检查按字段加密的状态，返回是否已处理完毕
+func checkFieldEncryption() bool {
 	nodes := sshw.GetConfig()
 	if len(nodes) == 0 {
 		fmt.Println("No configuration found")
-		return
+		return true
 	}
 
 	if *checkEncryptionStatus {
@@ -184,13 +463,13 @@ func handleEncryptionCommands() {
 		} else {
 			fmt.Println("Configuration is partially encrypted")
 		}
-		return
+		return true
 	}
 
 	// 检查是否有需要加密的配置
 	hasUnencrypted := false
 	for _, node := range nodes {
-		if !node.IsEncrypted && (node.Password != "" || node.Passphrase != "") {
+		if !node.IsEncrypted {
 			hasUnencrypted = true
 			break
 		}
@@ -199,7 +478,7 @@ func handleEncryptionCommands() {
 	// 如果是加密命令，检查是否已经全部加密
 	if *encryptConfig && !hasUnencrypted {
 		fmt.Println("All configurations are already encrypted")
-		return
+		return true
 	}
 
 	// 如果是解密命令，检查是否已经全部解密
@@ -213,109 +492,8 @@ func handleEncryptionCommands() {
 		}
 		if allDecrypted {
 			fmt.Println("All configurations are already decrypted")
-			return
+			return true
 		}
 	}
-
-	// 获取主密码
-	password, err := masterkey.GetMasterPassword()
-	if err != nil {
-		log.Error("Failed to get master password:", err)
-		os.Exit(1)
-	}
-
-	// 重新加载配置（使用密码）
-	err = sshw.LoadConfig(password, *configFile)
-	if err != nil {
-		log.Error("Failed to load config:", err)
-		os.Exit(1)
-	}
-
-	nodes = sshw.GetConfig()
-	if len(nodes) == 0 {
-		log.Error("No configuration found")
-		os.Exit(1)
-	}
-
-	if *encryptConfig {
-		// 加密配置
-		for _, node := range nodes {
-			if err := node.EncryptFields(password); err != nil {
-				log.Error("Failed to encrypt config:", err)
-				os.Exit(1)
-			}
-		}
-		// 保存加密后的配置
-		if err := sshw.SaveConfig(nodes, *configFile); err != nil {
-			log.Error("Failed to save encrypted config:", err)
-			os.Exit(1)
-		}
-		fmt.Println("Configuration encrypted successfully")
-	} else if *decryptConfig {
-		// 解密配置
-		for _, node := range nodes {
-			if err := node.DecryptFields(password); err != nil {
-				log.Error("Failed to decrypt config:", err)
-				os.Exit(1)
-			}
-		}
-		// 保存解密后的配置
-		if err := sshw.SaveConfig(nodes, *configFile); err != nil {
-			log.Error("Failed to save decrypted config:", err)
-			os.Exit(1)
-		}
-		fmt.Println("Configuration decrypted successfully")
-	}
-}
-
-func choose(parent, trees []*sshw.Node) *sshw.Node {
-	prompt := promptui.Select{
-		Label:        "select host",
-		Items:        trees,
-		Templates:    templates,
-		Size:         20,
-		HideSelected: true,
-		Searcher: func(input string, index int) bool {
-			node := trees[index]
-			content := fmt.Sprintf("%s %s %s", node.Name, node.User, node.Host)
-			if strings.Contains(input, " ") {
-				for _, key := range strings.Split(input, " ") {
-					key = strings.TrimSpace(key)
-					if key != "" {
-						if !strings.Contains(content, key) {
-							return false
-						}
-					}
-				}
-				return true
-			}
-			if strings.Contains(content, input) {
-				return true
-			}
-			return false
-		},
-	}
-	index, _, err := prompt.Run()
-	if err != nil {
-		return nil
-	}
-
-	node := trees[index]
-	if len(node.Children) > 0 {
-		first := node.Children[0]
-		if first.Name != prev {
-			first = &sshw.Node{Name: prev}
-			node.Children = append(node.Children[:0], append([]*sshw.Node{first}, node.Children...)...)
-		}
-		return choose(trees, node.Children)
-	}
-
-	if node.Name == prev {
-		if parent == nil {
-			return choose(nil, sshw.GetConfig())
-		}
-		return choose(nil, parent)
-	}
-
-	return node
+	return false
 }
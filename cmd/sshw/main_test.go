@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zdev0x/sshw"
+	"github.com/zdev0x/sshw/crypto"
+)
+
+// setFlags 修改命令行参数，测试结束后还原
+func setFlags(t *testing.T, config, identity, file, command string, fd int, stdin bool) {
+	t.Helper()
+	old := []interface{}{*configFile, *identityFile, *passwordFile, *passwordCommand, *passwordFD, *passwordStdin, passwordUnlocked, *rememberPassword}
+	t.Cleanup(func() {
+		*configFile, *identityFile = old[0].(string), old[1].(string)
+		*passwordFile, *passwordCommand = old[2].(string), old[3].(string)
+		*passwordFD, *passwordStdin, passwordUnlocked = old[4].(int), old[5].(bool), old[6].(bool)
+		*rememberPassword = old[7].(bool)
+	})
+	*configFile, *identityFile, *passwordFile, *passwordCommand = config, identity, file, command
+	*passwordFD, *passwordStdin, passwordUnlocked, *rememberPassword = fd, stdin, false, false
+
+	for _, env := range []string{"SSHW_MASTER_PASSWORD", "SSHW_PASSWORD_FILE", "SSHW_PASSWORD_COMMAND", "SSHW_REMEMBER_PASSWORD"} {
+		t.Setenv(env, "")
+		os.Unsetenv(env)
+	}
+	// 没有运行的 agent
+	t.Setenv("SSHW_AGENT_SOCK", filepath.Join(t.TempDir(), "agent.sock"))
+}
+
+func TestMigrateLegacyConfig(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "sshw.yml")
+	ioutil.WriteFile(config, []byte("- name: web\n  host: h\n  password: s3cret\n"), 0600)
+	setFlags(t, config, "", "", "", -1, false)
+
+	// 旧格式每个字段用主密码单独派生密钥，没有配置头
+	if err := sshw.LoadConfig(nil, config); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range sshw.GetConfig() {
+		if err := n.EncryptFields(crypto.NewPasswordCipher([]byte("pw"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sshw.SaveConfig(sshw.GetConfig(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadConfigWithPassword([]byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+	if sshw.IsLegacyEncrypted() || sshw.GetConfig()[0].Password != "s3cret" {
+		t.Fatalf("config not migrated: %+v", sshw.GetConfig()[0])
+	}
+	b, _ := ioutil.ReadFile(config)
+	if !strings.Contains(string(b), "encryption:") || strings.Contains(string(b), "s3cret") {
+		t.Fatalf("saved config:\n%s", b)
+	}
+}
+
+func TestExplicitTarget(t *testing.T) {
+	tests := []struct {
+		args, rest []string
+		want       bool
+	}{
+		{[]string{"tmux"}, []string{"tmux"}, false},
+		{[]string{"-"}, []string{"-"}, false},
+		{[]string{"--", "tmux"}, []string{"tmux"}, true},
+		{[]string{"-tui", "--", "-"}, []string{"-"}, true},
+		{[]string{"-tui", "--", "prod", "--"}, []string{"prod", "--"}, true},
+		{[]string{"prod", "--", "x"}, []string{"prod", "--", "x"}, false},
+		{[]string{"--"}, nil, false},
+		{nil, nil, false},
+	}
+	for _, tt := range tests {
+		if got := explicitTarget(tt.args, tt.rest); got != tt.want {
+			t.Errorf("explicitTarget(%q, %q) = %v, want %v", tt.args, tt.rest, got, tt.want)
+		}
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "sshw.yml")
+	ioutil.WriteFile(config, []byte(`
+- name: tmux
+  host: h1
+- name: prod
+  children:
+    - { name: agent, host: h2 }
+    - { name: web, alias: "-", host: h3 }
+    - { name: db, alias: fav, host: h4 }
+    - { name: cache, alias: pcache, host: h5 }
+`), 0600)
+	if err := sshw.LoadConfig(nil, config); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := reservedNames(sshw.GetConfig())
+	want := []string{`name "tmux"`, `alias "-" of prod/web`, `alias "fav" of prod/db`}
+	if len(msgs) != len(want) {
+		t.Fatalf("got %q", msgs)
+	}
+	for i, w := range want {
+		if !strings.Contains(msgs[i], w) {
+			t.Errorf("message %d: got %q, want %q", i, msgs[i], w)
+		}
+	}
+}
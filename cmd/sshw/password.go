@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zdev0x/sshw"
+	"github.com/zdev0x/sshw/masterkey"
+)
+
+var (
+	passwordStdin    = flag.Bool("password-stdin", false, "read master password from stdin")
+	passwordFD       = flag.Int("password-fd", -1, "read master password from file descriptor")
+	passwordFile     = flag.String("password-file", "", "read master password from key file")
+	passwordCommand  = flag.String("password-command", "", "read master password from the output of a command")
+	rememberPassword = flag.Bool("remember-password", false, "cache the master password in the keyring or ~/.sshw-master.secret when no agent is running")
+
+	// 配置是否使用主密码解锁，在 tmux 中启动的 sshw 同样需要主密码
+	passwordUnlocked bool
+)
+
+// setPasswordProviders 设置非交互获取主密码的方式
+// 优先级：命令行参数 > 环境变量 > 配置中的 password_command > agent 或缓存 > 终端输入
+func setPasswordProviders() {
+	masterkey.SetProviders(passwordProviders()...)
+
+	// 长期缓存主密码需要显式开启，否则只缓存在 agent 中
+	masterkey.SetRememberPassword(*rememberPassword || os.Getenv("SSHW_REMEMBER_PASSWORD") != "")
+}
+
+// passwordProviders 按优先级返回命令行参数、环境变量和配置中指定的获取方式
+func passwordProviders() []masterkey.PasswordProvider {
+	var providers []masterkey.PasswordProvider
+	if *passwordStdin {
+		providers = append(providers, masterkey.NewFDProvider(0))
+	}
+	if *passwordFD >= 0 {
+		providers = append(providers, masterkey.NewFDProvider(uintptr(*passwordFD)))
+	}
+	if *passwordFile != "" {
+		providers = append(providers, masterkey.FileProvider(*passwordFile))
+	}
+	if *passwordCommand != "" {
+		providers = append(providers, masterkey.CommandProvider(*passwordCommand))
+	}
+
+	providers = append(providers, masterkey.EnvProvider("SSHW_MASTER_PASSWORD"))
+	if f := os.Getenv("SSHW_PASSWORD_FILE"); f != "" {
+		providers = append(providers, masterkey.FileProvider(f))
+	}
+	if c := os.Getenv("SSHW_PASSWORD_COMMAND"); c != "" {
+		providers = append(providers, masterkey.CommandProvider(c))
+	}
+	return append(providers, configPasswordCommand{})
+}
+
+// configPasswordCommand 使用配置头中的 password_command，配置加载后才可用
+type configPasswordCommand struct{}
+
+func (configPasswordCommand) Password() ([]byte, error) {
+	c := sshw.PasswordCommand()
+	if c == "" {
+		return nil, masterkey.ErrNotFound
+	}
+	return masterkey.CommandProvider(c).Password()
+}
+
+func (configPasswordCommand) String() string {
+	return "password_command in config"
+}
+
+// forwardPasswordArgs 返回在 tmux 中启动的 sshw 获取主密码的参数
+// 文件和命令可以直接传递；标准输入、文件描述符和环境变量中的密码无法传递，需要 agent 已保存主密码
+func forwardPasswordArgs() ([]string, error) {
+	file, command := *passwordFile, *passwordCommand
+	if file == "" {
+		file = os.Getenv("SSHW_PASSWORD_FILE")
+	}
+	if command == "" {
+		command = os.Getenv("SSHW_PASSWORD_COMMAND")
+	}
+
+	var args []string
+	if file != "" {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-password-file", abs)
+	}
+	if command != "" {
+		args = append(args, "-password-command", command)
+	}
+	if len(args) > 0 || !passwordUnlocked {
+		return args, nil
+	}
+
+	_, env := os.LookupEnv("SSHW_MASTER_PASSWORD")
+	if (*passwordStdin || *passwordFD >= 0 || env) && !masterkey.AgentUnlocked() {
+		return nil, fmt.Errorf("the master password from -password-stdin, -password-fd or SSHW_MASTER_PASSWORD can not be passed to tmux, " +
+			"use -password-file or -password-command, or unlock sshw agent first")
+	}
+	return args, nil
+}
@@ -0,0 +1,107 @@
+//go:build unix
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/zdev0x/sshw"
+	"github.com/zdev0x/sshw/masterkey"
+)
+
+// firstPassword 返回第一个提供了密码的方式给出的密码
+func firstPassword(providers []masterkey.PasswordProvider) (string, error) {
+	for _, p := range providers {
+		b, err := p.Password()
+		if err == masterkey.ErrNotFound {
+			continue
+		}
+		return string(b), err
+	}
+	return "", masterkey.ErrNotFound
+}
+
+// pipe 返回写入了 content 的管道读端，文件描述符由读取方关闭
+func pipe(t *testing.T, content string) *os.File {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(content))
+	w.Close()
+	return r
+}
+
+// pipeFD 返回 pipe 读端复制出的文件描述符
+func pipeFD(t *testing.T, content string) int {
+	t.Helper()
+	r := pipe(t, content)
+	defer r.Close()
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd
+}
+
+func TestPasswordProvidersPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	config := write("sshw.yml", "encryption:\n  password_command: echo from-config\nnodes:\n  - name: web\n")
+	setFlags(t, config, "", "", "", -1, false)
+
+	if _, err := firstPassword(passwordProviders()); err != masterkey.ErrNotFound {
+		t.Fatalf("config not loaded: got %v", err)
+	}
+	if err := sshw.LoadConfig(nil, config); err != nil {
+		t.Fatal(err)
+	}
+
+	// 依次加入优先级更高的方式，每次都应使用新加入的方式
+	stdin := os.Stdin
+	t.Cleanup(func() { os.Stdin = stdin })
+	steps := []struct {
+		name string
+		set  func()
+		want string
+	}{
+		{"config password_command", func() {}, "from-config"},
+		{"SSHW_PASSWORD_COMMAND", func() { t.Setenv("SSHW_PASSWORD_COMMAND", "echo from-env-command") }, "from-env-command"},
+		{"SSHW_PASSWORD_FILE", func() { t.Setenv("SSHW_PASSWORD_FILE", write("env-key", "from-env-file\n")) }, "from-env-file"},
+		{"SSHW_MASTER_PASSWORD", func() { t.Setenv("SSHW_MASTER_PASSWORD", "from-env") }, "from-env"},
+		{"-password-command", func() { *passwordCommand = "printf 'from-command\\r\\n'" }, "from-command"},
+		{"-password-file", func() { *passwordFile = write("key", "from-file\n") }, "from-file"},
+		{"-password-fd", func() { *passwordFD = pipeFD(t, "from-fd\n") }, "from-fd"},
+		{"-password-stdin", func() { *passwordStdin, os.Stdin = true, pipe(t, "from-stdin\n") }, "from-stdin"},
+	}
+	for _, step := range steps {
+		step.set()
+		got, err := firstPassword(passwordProviders())
+		if err != nil || got != step.want {
+			t.Fatalf("%s: got %q, %v, want %q", step.name, got, err, step.want)
+		}
+	}
+}
+
+func TestPasswordCommandFails(t *testing.T) {
+	setFlags(t, "", "", "", "echo leaked; exit 3", -1, false)
+	t.Setenv("SSHW_MASTER_PASSWORD", "from-env")
+
+	// 命令失败时报错，不改用优先级更低的方式
+	_, err := firstPassword(passwordProviders())
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	"github.com/zdev0x/sshw"
+)
+
+// 面包屑分隔符
+const breadcrumbSep = " › "
+
+// backNode 分组中返回上级的选项，只加在显示的列表中，不写入配置
+var backNode = &sshw.Node{Name: "-parent-"}
+
+// pickLevel 显示一层选择列表，测试中替换为预设的按键
+var pickLevel = pick
+
+// pickerLevel 导航栈中的一层，记录进入的分组及其在上一层列表中的位置
+type pickerLevel struct {
+	group  *sshw.Node
+	cursor int
+}
+
+// choose 从 roots 开始逐级选择节点，start 为分组时从该分组开始
+// 在分组中按 Esc 或退格返回上级，在第一层按 Esc 退出，不修改配置中的节点
+// 按空格标记多个节点，标记后按回车返回所有标记的节点，否则返回选中的节点
+func choose(roots []*sshw.Node, start *sshw.Node) []*sshw.Node {
+	tpl, err := pickerTemplates()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	marks := &selection{}
+	tpl.FuncMap["mark"] = marks.indicator
+	tpl.Active = "{{ mark . }}" + tpl.Active
+	tpl.Inactive = "{{ mark . }}" + tpl.Inactive
+
+	var stack []pickerLevel
+	for _, n := range pathTo(roots, start) {
+		if len(n.Children) > 0 {
+			stack = append(stack, pickerLevel{group: n})
+		}
+	}
+
+	cursor := 0
+	for {
+		items := roots
+		if len(stack) > 0 {
+			items = append([]*sshw.Node{backNode}, stack[len(stack)-1].group.Children...)
+		}
+
+		label := breadcrumb(stack)
+		if len(marks.nodes) > 0 {
+			label += fmt.Sprintf(" [%d selected]", len(marks.nodes))
+		}
+		index, key, err := pickLevel(tpl, label, items, cursor)
+		if err != nil {
+			return nil
+		}
+		if key == keySpace {
+			if items[index] != backNode {
+				marks.toggle(items[index])
+			}
+			cursor = index
+			continue
+		}
+		if key != 0 || items[index] == backNode {
+			if len(stack) == 0 {
+				if key == keyEscape {
+					return nil
+				}
+				cursor = index
+				continue
+			}
+			cursor = stack[len(stack)-1].cursor
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		node := items[index]
+		if len(node.Children) > 0 {
+			stack = append(stack, pickerLevel{group: node, cursor: index})
+			cursor = 0
+			continue
+		}
+		if len(marks.nodes) > 0 {
+			return marks.nodes
+		}
+		return []*sshw.Node{node}
+	}
+}
+
+// selection 选择列表中标记的节点，按标记的顺序保存
+type selection struct {
+	nodes []*sshw.Node
+}
+
+func (s *selection) has(n *sshw.Node) bool {
+	return containsNode(s.nodes, n)
+}
+
+// toggle 标记或取消标记节点，分组中的节点全部已标记时取消标记，否则全部标记
+func (s *selection) toggle(n *sshw.Node) {
+	leaves := sshw.Leaves([]*sshw.Node{n})
+	all := true
+	for _, l := range leaves {
+		all = all && s.has(l)
+	}
+	if all {
+		var kept []*sshw.Node
+		for _, m := range s.nodes {
+			if !containsNode(leaves, m) {
+				kept = append(kept, m)
+			}
+		}
+		s.nodes = kept
+		return
+	}
+	for _, l := range leaves {
+		if !s.has(l) {
+			s.nodes = append(s.nodes, l)
+		}
+	}
+}
+
+// indicator 返回节点前显示的标记，没有标记任何节点时不显示，分组中部分节点已标记时显示半圆
+func (s *selection) indicator(n *sshw.Node) string {
+	if len(s.nodes) == 0 {
+		return ""
+	}
+	leaves := sshw.Leaves([]*sshw.Node{n})
+	marked := 0
+	for _, l := range leaves {
+		if s.has(l) {
+			marked++
+		}
+	}
+	switch {
+	case marked == 0:
+		return "  "
+	case marked < len(leaves):
+		return paint("ok", "◐") + " "
+	default:
+		return paint("ok", "●") + " "
+	}
+}
+
+func containsNode(nodes []*sshw.Node, n *sshw.Node) bool {
+	for _, m := range nodes {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+// breadcrumb 返回选择列表的标题，进入分组后显示所在的路径，如 prod › eu › db
+func breadcrumb(stack []pickerLevel) string {
+	if len(stack) == 0 {
+		return "select host"
+	}
+	names := make([]string, len(stack))
+	for i, l := range stack {
+		names[i] = l.group.Name
+	}
+	return strings.Join(names, breadcrumbSep)
+}
+
+// pick 显示一层选择列表，返回选中的位置，按 Esc 或退格离开时同时返回该按键
+func pick(tpl *promptui.SelectTemplates, label string, items []*sshw.Node, cursor int) (int, byte, error) {
+	in := &backReader{r: os.Stdin}
+	size := pageSize()
+	// promptui 会填充未设置的模板，每次使用副本
+	t := *tpl
+	prompt := promptui.Select{
+		Label:        label,
+		Items:        items,
+		Templates:    &t,
+		Size:         size,
+		HideSelected: tpl.Selected == "",
+		Stdin:        in,
+		Searcher: func(input string, index int) bool {
+			node := items[index]
+			if node == backNode {
+				return false
+			}
+			tags, keys := sshw.SplitTags(input)
+			if len(tags) > 0 && !sshw.MatchTags(node, tags) {
+				return false
+			}
+			content := sshw.SearchText(node)
+			for _, key := range keys {
+				if !strings.Contains(content, key) {
+					return false
+				}
+			}
+			return true
+		},
+	}
+
+	scroll := 0
+	if cursor >= size {
+		scroll = cursor - size + 1
+	}
+	index, _, err := prompt.RunCursorAt(cursor, scroll)
+	return index, in.key, err
+}
+
+// 离开选择列表的按键
+const (
+	keyEscape    = 0x1b
+	keyBackspace = 0x7f
+	keySpace     = ' '
+)
+
+// backReader 包装标准输入，不在搜索状态时把 Esc、退格和空格转换为回车，并记录按下的键
+type backReader struct {
+	r         io.Reader
+	searching bool
+	key       byte
+}
+
+func (b *backReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if n != 1 || b.key != 0 {
+		return n, err
+	}
+	switch p[0] {
+	case '/':
+		b.searching = !b.searching
+	case keyEscape:
+		// 搜索时只退出搜索
+		if b.searching {
+			b.searching = false
+			p[0] = '/'
+			break
+		}
+		b.key = keyEscape
+		p[0] = '\r'
+	case keyBackspace, 0x08:
+		if !b.searching {
+			b.key = keyBackspace
+			p[0] = '\r'
+		}
+	case keySpace:
+		if !b.searching {
+			b.key = keySpace
+			p[0] = '\r'
+		}
+	}
+	return n, err
+}
+
+// Close 不关闭标准输入
+func (b *backReader) Close() error {
+	return nil
+}
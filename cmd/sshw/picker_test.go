@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/manifoldco/promptui"
+	"github.com/zdev0x/sshw"
+)
+
+// scriptedPick 一次选择：选中名为 name 的项，并按下 key，key 为 0 表示回车
+type scriptedPick struct {
+	name string
+	key  byte
+}
+
+// pickCall 选择列表显示时的标题、各项名称和光标位置
+type pickCall struct {
+	label  string
+	items  []string
+	cursor int
+}
+
+// scriptPicks 按顺序使用预设的选择代替交互，返回每次显示的列表
+func scriptPicks(t *testing.T, picks ...scriptedPick) *[]pickCall {
+	t.Helper()
+	old := pickLevel
+	t.Cleanup(func() { pickLevel = old })
+	calls := new([]pickCall)
+	pickLevel = func(tpl *promptui.SelectTemplates, label string, items []*sshw.Node, cursor int) (int, byte, error) {
+		var names []string
+		for _, n := range items {
+			names = append(names, n.Name)
+		}
+		*calls = append(*calls, pickCall{label, names, cursor})
+		if len(*calls) > len(picks) {
+			return 0, 0, errors.New("no more picks")
+		}
+		p := picks[len(*calls)-1]
+		for i, name := range names {
+			if name == p.name {
+				return i, p.key, nil
+			}
+		}
+		t.Fatalf("pick %d: %q not in %q", len(*calls), p.name, names)
+		return 0, 0, nil
+	}
+	return calls
+}
+
+func nodeNames(nodes []*sshw.Node) []string {
+	var names []string
+	for _, n := range nodes {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+func TestChooseNavigation(t *testing.T) {
+	calls := scriptPicks(t,
+		scriptedPick{"prod", 0},
+		scriptedPick{"eu", 0},
+		// Esc 返回上级，光标回到进入的分组
+		scriptedPick{"db", keyEscape},
+		scriptedPick{"-parent-", 0},
+		// 第一层的退格不退出
+		scriptedPick{"bastion", keyBackspace},
+		scriptedPick{"bastion", 0},
+	)
+	got := choose(treeNodes(), nil)
+	if !reflect.DeepEqual(nodeNames(got), []string{"bastion"}) {
+		t.Fatalf("chose %q", nodeNames(got))
+	}
+	want := []pickCall{
+		{"select host", []string{"prod", "bastion"}, 0},
+		{"prod", []string{"-parent-", "web", "eu"}, 0},
+		{"prod › eu", []string{"-parent-", "db"}, 0},
+		{"prod", []string{"-parent-", "web", "eu"}, 2},
+		{"select host", []string{"prod", "bastion"}, 0},
+		{"select host", []string{"prod", "bastion"}, 1},
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Fatalf("calls:\n got %+v\nwant %+v", *calls, want)
+	}
+}
+
+func TestChooseStart(t *testing.T) {
+	nodes := treeNodes()
+	calls := scriptPicks(t,
+		scriptedPick{"db", keyBackspace},
+		scriptedPick{"web", keyEscape},
+		scriptedPick{"prod", keyEscape},
+	)
+	// 从分组开始时栈中包含上级分组，在第一层按 Esc 退出
+	if got := choose(nodes, nodes[0].Children[1]); got != nil {
+		t.Fatalf("chose %q", nodeNames(got))
+	}
+	var labels []string
+	for _, c := range *calls {
+		labels = append(labels, c.label)
+	}
+	if !reflect.DeepEqual(labels, []string{"prod › eu", "prod", "select host"}) {
+		t.Fatalf("labels: %q", labels)
+	}
+}
+
+func TestChooseMarks(t *testing.T) {
+	calls := scriptPicks(t,
+		scriptedPick{"prod", 0},
+		scriptedPick{"web", keySpace},
+		// 返回上级选项不能标记
+		scriptedPick{"-parent-", keySpace},
+		scriptedPick{"eu", keySpace},
+		scriptedPick{"eu", keySpace},
+		scriptedPick{"eu", keySpace},
+		scriptedPick{"web", 0},
+	)
+	got := choose(treeNodes(), nil)
+	if !reflect.DeepEqual(nodeNames(got), []string{"web", "db"}) {
+		t.Fatalf("chose %q", nodeNames(got))
+	}
+	var labels []string
+	for _, c := range (*calls)[1:] {
+		labels = append(labels, c.label)
+	}
+	want := []string{"prod", "prod [1 selected]", "prod [1 selected]", "prod [2 selected]", "prod [1 selected]", "prod [2 selected]"}
+	if !reflect.DeepEqual(labels, want) {
+		t.Fatalf("labels: %q", labels)
+	}
+	if (*calls)[4].cursor != 2 {
+		t.Fatalf("cursor after marking: %d", (*calls)[4].cursor)
+	}
+}
+
+func TestChooseCancelled(t *testing.T) {
+	scriptPicks(t)
+	if got := choose(treeNodes(), nil); got != nil {
+		t.Fatalf("chose %q", nodeNames(got))
+	}
+}
+
+// byteReader 每次读取一个按键
+type byteReader struct {
+	keys []string
+}
+
+func (r *byteReader) Read(p []byte) (int, error) {
+	if len(r.keys) == 0 {
+		return 0, errors.New("EOF")
+	}
+	n := copy(p, r.keys[0])
+	r.keys = r.keys[1:]
+	return n, nil
+}
+
+func TestBackReader(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		want []string
+		key  byte
+	}{
+		{"enter", []string{"j", "\r"}, []string{"j", "\r"}, 0},
+		{"escape", []string{"j", "\x1b", "x"}, []string{"j", "\r", "x"}, keyEscape},
+		{"backspace", []string{"\x7f"}, []string{"\r"}, keyBackspace},
+		{"ctrl-h", []string{"\x08"}, []string{"\r"}, keyBackspace},
+		{"space", []string{" "}, []string{"\r"}, keySpace},
+		{"arrow keys", []string{"\x1b[A", "\x1b[B"}, []string{"\x1b[A", "\x1b[B"}, 0},
+		// 搜索时 Esc 只结束搜索，退格和空格用于编辑搜索内容
+		{"search", []string{"/", "a", " ", "\x7f", "\x1b", "\x1b"}, []string{"/", "a", " ", "\x7f", "/", "\r"}, keyEscape},
+		{"search toggled off", []string{"/", "a", "/", " "}, []string{"/", "a", "/", "\r"}, keySpace},
+		// 记录第一个离开的按键后不再转换
+		{"after leaving", []string{" ", "\x1b", "\x7f"}, []string{"\r", "\x1b", "\x7f"}, keySpace},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &backReader{r: &byteReader{keys: tt.keys}}
+			var got []string
+			buf := make([]byte, 8)
+			for range tt.keys {
+				n, err := b.Read(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, string(buf[:n]))
+			}
+			if !reflect.DeepEqual(got, tt.want) || b.key != tt.key {
+				t.Fatalf("got %q key %#x, want %q key %#x", got, b.key, tt.want, tt.key)
+			}
+		})
+	}
+}
+
+func TestBreadcrumb(t *testing.T) {
+	nodes := treeNodes()
+	stack := []pickerLevel{{group: nodes[0]}, {group: nodes[0].Children[1]}}
+	if got := breadcrumb(stack); got != strings.Join([]string{"prod", "eu"}, breadcrumbSep) {
+		t.Fatalf("breadcrumb: %q", got)
+	}
+	if got := breadcrumb(nil); got != "select host" {
+		t.Fatalf("breadcrumb at top: %q", got)
+	}
+}
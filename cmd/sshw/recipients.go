@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zdev0x/sshw"
+	"github.com/zdev0x/sshw/crypto"
+	"github.com/zdev0x/sshw/masterkey"
+)
+
+const recipientsUsage = `usage:
+  sshw recipients list
+  sshw recipients add <public key | public key file> [name]
+  sshw recipients rm <name | public key | master-password>
+  sshw recipients keygen [identity file]`
+
+func recipientsCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println(recipientsUsage)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		listRecipients()
+	case "add":
+		if len(args) < 2 {
+			fmt.Println(recipientsUsage)
+			os.Exit(1)
+		}
+		addRecipient(args[1:])
+	case "rm":
+		if len(args) < 2 {
+			fmt.Println(recipientsUsage)
+			os.Exit(1)
+		}
+		removeRecipient(args[1])
+	case "keygen":
+		generateIdentity(args[1:])
+	default:
+		fmt.Println(recipientsUsage)
+		os.Exit(1)
+	}
+}
+
+func listRecipients() {
+	err := sshw.LoadConfig(nil, *configFile)
+	if err != nil && err != sshw.ErrPasswordRequired {
+		log.Error("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	if sshw.HasMasterPassword() {
+		fmt.Println(sshw.MasterPasswordRecipient)
+	}
+	for _, r := range sshw.GetRecipients() {
+		name := r.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Printf("%s\t%s\n", name, r.PublicKey)
+	}
+}
+
+func addRecipient(args []string) {
+	publicKey := args[0]
+	// 参数是文件时读取公钥文件
+	if b, err := ioutil.ReadFile(publicKey); err == nil {
+		publicKey = strings.TrimSpace(string(b))
+	}
+	if _, err := crypto.ParseRecipient(publicKey); err != nil {
+		log.Error("Invalid public key:", err)
+		os.Exit(1)
+	}
+
+	name := ""
+	if len(args) > 1 {
+		name = args[1]
+	}
+
+	if _, err := unlockConfig(); err != nil {
+		log.Error("Failed to load config:", err)
+		os.Exit(1)
+	}
+	if err := sshw.AddRecipient(publicKey, name); err != nil {
+		log.Error("Failed to add recipient:", err)
+		os.Exit(1)
+	}
+	if err := sshw.SaveConfig(sshw.GetConfig(), *configFile); err != nil {
+		log.Error("Failed to save config:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Recipient added successfully")
+}
+
+func removeRecipient(match string) {
+	password, err := unlockConfig()
+	if err != nil {
+		log.Error("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	// 轮换数据密钥时需要用主密码重新包裹
+	if password == nil && sshw.HasMasterPassword() && match != sshw.MasterPasswordRecipient {
+		password, err = masterkey.GetMasterPassword()
+		if err != nil {
+			log.Error("Failed to get master password:", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := sshw.RemoveRecipient(match, password); err != nil {
+		log.Error("Failed to remove recipient:", err)
+		os.Exit(1)
+	}
+	if err := sshw.SaveConfig(sshw.GetConfig(), *configFile); err != nil {
+		log.Error("Failed to save config:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Recipient removed and data key rotated successfully")
+}
+
+func generateIdentity(args []string) {
+	var path string
+	if len(args) > 0 {
+		path = args[0]
+	} else {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		path = filepath.Join(home, ".sshw-identity")
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		log.Error("Identity file already exists:", path)
+		os.Exit(1)
+	}
+
+	id, err := crypto.GenerateIdentity()
+	if err != nil {
+		log.Error("Failed to generate identity:", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(path, id.Marshal(), 0600); err != nil {
+		log.Error("Failed to save identity:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Identity saved to", path)
+	fmt.Println("Public key:", id.Recipient())
+}
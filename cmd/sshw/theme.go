@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/manifoldco/promptui"
+	"github.com/zdev0x/sshw"
+)
+
+// 选择列表默认每页显示的行数
+const defaultPageSize = 20
+
+// theme 界面中各类文字的样式，值为 ANSI SGR 参数，空字符串表示不使用样式
+// primary 选中项，accent 别名等强调内容，label 标题和提示符，muted 次要信息，ok 和 error 为状态
+type theme map[string]string
+
+var themes = map[string]theme{
+	"default":  {"primary": "36", "accent": "33", "label": "32", "muted": "2", "ok": "32", "error": "31"},
+	"ocean":    {"primary": "34", "accent": "36", "label": "34", "muted": "2", "ok": "36", "error": "35"},
+	"warm":     {"primary": "33", "accent": "35", "label": "31", "muted": "2", "ok": "33", "error": "31"},
+	"no-color": {"primary": "1"},
+}
+
+// 当前主题，加载配置后由 applyTheme 设置
+var (
+	activeTheme     = themes["default"]
+	activeThemeName = "default"
+)
+
+// applyTheme 按 ui.theme 和 NO_COLOR 环境变量选择主题
+func applyTheme() error {
+	name := sshw.UI().Theme
+	if os.Getenv("NO_COLOR") != "" {
+		name = "no-color"
+	}
+	if name == "" {
+		name = "default"
+	}
+	t, ok := themes[name]
+	if !ok {
+		var names []string
+		for n := range themes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown ui.theme %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	activeTheme, activeThemeName = t, name
+	return nil
+}
+
+// colorless 当前主题是否不使用颜色
+func colorless() bool {
+	return activeThemeName == "no-color"
+}
+
+// paint 按当前主题给文字加上样式
+func paint(role, s string) string {
+	code := activeTheme[role]
+	if code == "" || s == "" {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// funcMap 模板中可用的样式函数：promptui 的颜色函数和主题中的样式
+// 不使用颜色时 promptui 的颜色函数不做任何处理
+func funcMap() template.FuncMap {
+	m := template.FuncMap{}
+	for name, fn := range promptui.FuncMap {
+		m[name] = fn
+		if colorless() && name != "bold" && name != "faint" && name != "italic" && name != "underline" {
+			m[name] = fmt.Sprint
+		}
+	}
+	for _, role := range []string{"primary", "accent", "label", "muted", "ok", "error"} {
+		role := role
+		m[role] = func(v interface{}) string {
+			return paint(role, fmt.Sprint(v))
+		}
+	}
+	return m
+}
+
+// pickerTemplates 返回选择列表的模板，ui.templates 中设置的模板覆盖默认模板
+func pickerTemplates() (*promptui.SelectTemplates, error) {
+	t := *templates
+	t.FuncMap = funcMap()
+	if c := sshw.UI().Templates; c != nil {
+		for _, o := range []struct {
+			value string
+			dst   *string
+		}{
+			{c.Label, &t.Label},
+			{c.Active, &t.Active},
+			{c.Inactive, &t.Inactive},
+			{c.Selected, &t.Selected},
+			{c.Details, &t.Details},
+		} {
+			if o.value != "" {
+				*o.dst = o.value
+			}
+		}
+	}
+	for _, s := range []string{t.Label, t.Active, t.Inactive, t.Selected, t.Details} {
+		if _, err := template.New("").Funcs(t.FuncMap).Parse(s); err != nil {
+			return nil, fmt.Errorf("invalid ui template: %v", err)
+		}
+	}
+	return &t, nil
+}
+
+// pageSize 返回选择列表每页显示的行数
+func pageSize() int {
+	if n := sshw.UI().PageSize; n > 0 {
+		return n
+	}
+	return defaultPageSize
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/zdev0x/sshw"
+)
+
+// useUI 加载带有 ui 设置的配置，测试结束后恢复默认主题
+func useUI(t *testing.T, ui string) {
+	t.Helper()
+	config := filepath.Join(t.TempDir(), "sshw.yml")
+	ioutil.WriteFile(config, []byte("ui:\n"+ui+"\nnodes:\n  - name: web\n    host: h\n"), 0600)
+	setFlags(t, config, "", "", "", -1, false)
+	t.Cleanup(func() {
+		activeTheme, activeThemeName = themes["default"], "default"
+	})
+	t.Setenv("NO_COLOR", "")
+	os.Unsetenv("NO_COLOR")
+	if err := sshw.LoadConfig(nil, config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// render 使用选择列表的模板显示节点
+func render(t *testing.T, text string, funcs template.FuncMap, n *sshw.Node) string {
+	t.Helper()
+	tpl, err := template.New("").Funcs(funcs).Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := tpl.Execute(&b, n); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestApplyTheme(t *testing.T) {
+	useUI(t, "  theme: ocean")
+	if err := applyTheme(); err != nil {
+		t.Fatal(err)
+	}
+	if activeThemeName != "ocean" || paint("primary", "x") != "\x1b[34mx\x1b[0m" {
+		t.Fatalf("theme %s: %q", activeThemeName, paint("primary", "x"))
+	}
+	// 主题中没有的角色不加样式
+	if got := paint("unknown", "x"); got != "x" {
+		t.Fatalf("unknown role: %q", got)
+	}
+
+	useUI(t, "  theme: neon")
+	err := applyTheme()
+	if err == nil || !strings.Contains(err.Error(), `unknown ui.theme "neon", expected one of default, no-color, ocean, warm`) {
+		t.Fatalf("unknown theme: got %v", err)
+	}
+	// 失败时保持原来的主题
+	if activeThemeName != "ocean" {
+		t.Fatalf("theme changed to %s", activeThemeName)
+	}
+
+	// NO_COLOR 优先于配置中的主题
+	useUI(t, "  theme: neon")
+	t.Setenv("NO_COLOR", "1")
+	if err := applyTheme(); err != nil || !colorless() {
+		t.Fatalf("NO_COLOR: %s, %v", activeThemeName, err)
+	}
+}
+
+func TestPickerTemplates(t *testing.T) {
+	useUI(t, `  templates:
+    active: "> {{ .Name | accent }}"
+    details: "{{ .Host | cyan }}"`)
+	if err := applyTheme(); err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := pickerTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &sshw.Node{Name: "web", Host: "h"}
+	if got := render(t, tpl.Active, tpl.FuncMap, n); got != "> \x1b[33mweb\x1b[0m" {
+		t.Fatalf("active: %q", got)
+	}
+	if tpl.Inactive != templates.Inactive || tpl.Label != templates.Label {
+		t.Fatal("templates not set in ui.templates changed")
+	}
+	if got := render(t, tpl.Details, tpl.FuncMap, n); !strings.Contains(got, "\x1b[") {
+		t.Fatalf("details not coloured: %q", got)
+	}
+
+	// 模板中使用主题以外的样式或语法错误时报错
+	for _, bad := range []string{`"{{ .Name | purple }}"`, `"{{ .Name "`} {
+		useUI(t, "  templates:\n    active: "+bad)
+		if _, err := pickerTemplates(); err == nil || !strings.Contains(err.Error(), "invalid ui template") {
+			t.Fatalf("%s: got %v", bad, err)
+		}
+	}
+}
+
+func TestPickerTemplatesNoColor(t *testing.T) {
+	useUI(t, `  theme: warm
+  templates:
+    details: "{{ .Name | cyan | bgRed }} {{ .Host | error }}"`)
+	t.Setenv("NO_COLOR", "1")
+	if err := applyTheme(); err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := pickerTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 只保留选中项的粗体，不使用任何颜色
+	color := regexp.MustCompile("\x1b\\[[0-9;]*m")
+	n := &sshw.Node{Name: "web", Alias: "w", User: "root", Host: "10.0.0.1", ShowHost: true}
+	for name, text := range map[string]string{"label": tpl.Label, "active": tpl.Active, "inactive": tpl.Inactive, "details": tpl.Details} {
+		got := render(t, text, tpl.FuncMap, n)
+		for _, code := range color.FindAllString(got, -1) {
+			if code != "\x1b[1m" && code != "\x1b[0m" {
+				t.Errorf("%s: colour %q in %q", name, code, got)
+			}
+		}
+	}
+	if got := render(t, tpl.Details, tpl.FuncMap, n); got != "web 10.0.0.1" {
+		t.Fatalf("details: %q", got)
+	}
+}
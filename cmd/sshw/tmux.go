@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zdev0x/sshw"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// inTmux 是否运行在 tmux 中
+func inTmux() bool {
+	return os.Getenv("TMUX") != ""
+}
+
+// tmux 执行 tmux 命令，返回去掉末尾换行的输出
+func tmux(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("tmux", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("tmux %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("tmux %s: %v", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// loginCommand 返回在新的 tmux 窗口或面板中连接节点的命令
+// 新的窗口可能有不同的工作目录和环境变量，使用绝对路径指定配置文件，并传递获取主密码的参数
+// 节点没有别名时使用以 / 开头的完整路径，避免与其他节点的别名混淆
+// 目标放在 "--" 之后，与子命令同名的别名也按节点登录
+func loginCommand(n *sshw.Node) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	args := []string{exe}
+	if path, err := sshw.ConfigFile(*configFile); err == nil {
+		if path, err = filepath.Abs(path); err != nil {
+			return "", err
+		}
+		args = append(args, "-config", path)
+	}
+	if *identityFile != "" {
+		path, err := filepath.Abs(*identityFile)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "-identity", path)
+	}
+	password, err := forwardPasswordArgs()
+	if err != nil {
+		return "", err
+	}
+	args = append(args, password...)
+	if *rememberPassword || os.Getenv("SSHW_REMEMBER_PASSWORD") != "" {
+		args = append(args, "-remember-password")
+	}
+	if *useLocalSSHConfig {
+		args = append(args, "-s")
+	}
+	target := n.Alias
+	if target == "" {
+		target = "/" + n.Path()
+	}
+	args = append(args, "--", target)
+
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " "), nil
+}
+
+// shellQuote 使用单引号转义 shell 参数
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// openWindows 为每个节点打开一个以节点名命名的 tmux 窗口
+func openWindows(nodes []*sshw.Node) error {
+	for _, n := range nodes {
+		cmd, err := loginCommand(n)
+		if err != nil {
+			return err
+		}
+		if _, err := tmux("new-window", "-n", n.Name, cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openPane 拆分当前的 tmux 面板连接节点，面板标题为节点名
+func openPane(n *sshw.Node) error {
+	cmd, err := loginCommand(n)
+	if err != nil {
+		return err
+	}
+	pane, err := tmux("split-window", "-P", "-F", "#{pane_id}", cmd)
+	if err != nil {
+		return err
+	}
+	_, err = tmux("select-pane", "-t", pane, "-T", n.Name)
+	return err
+}
+
+// openPanes 在新的 tmux 窗口中为每个节点打开一个面板并平铺，sync 为 true 时同步所有面板的输入
+// 不在 tmux 中时新建会话并连接到该会话
+func openPanes(name string, nodes []*sshw.Node, sync bool) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	// 先生成所有命令，避免打开部分面板后失败
+	cmds := make([]string, len(nodes))
+	for i, n := range nodes {
+		cmd, err := loginCommand(n)
+		if err != nil {
+			return err
+		}
+		cmds[i] = cmd
+	}
+
+	args := []string{"new-window", "-d"}
+	if !inTmux() {
+		args = []string{"new-session", "-d"}
+		if w, h, err := terminal.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 && h > 0 {
+			args = append(args, "-x", strconv.Itoa(w), "-y", strconv.Itoa(h))
+		}
+	}
+	args = append(args, "-P", "-F", "#{session_id} #{window_id} #{pane_id}", "-n", name, cmds[0])
+	ids, err := tmux(args...)
+	if err != nil {
+		return err
+	}
+	f := strings.Fields(ids)
+	if len(f) != 3 {
+		return fmt.Errorf("tmux %s: unexpected output %q", args[0], ids)
+	}
+	session, window, pane := f[0], f[1], f[2]
+	if _, err := tmux("select-pane", "-t", pane, "-T", nodes[0].Name); err != nil {
+		return err
+	}
+
+	for i, n := range nodes[1:] {
+		// 拆分最后一个面板，保持面板顺序与节点顺序一致
+		pane, err = tmux("split-window", "-d", "-P", "-F", "#{pane_id}", "-t", pane, cmds[i+1])
+		if err != nil {
+			return err
+		}
+		if _, err := tmux("select-pane", "-t", pane, "-T", n.Name); err != nil {
+			return err
+		}
+		// 每次拆分后重新平铺，保证后续面板有足够的空间
+		if _, err := tmux("select-layout", "-t", window, "tiled"); err != nil {
+			return err
+		}
+	}
+	if sync {
+		if _, err := tmux("set-window-option", "-t", window, "synchronize-panes", "on"); err != nil {
+			return err
+		}
+	}
+	if inTmux() {
+		_, err = tmux("select-window", "-t", window)
+		return err
+	}
+	cmd := exec.Command("tmux", "attach-session", "-t", session)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+const tmuxUsage = `usage:
+  sshw tmux [-sync] <alias | path>`
+
+// tmuxCommand 在新的 tmux 窗口中为分组中的每个节点打开一个面板
+func tmuxCommand(args []string) {
+	fs := flag.NewFlagSet("tmux", flag.ExitOnError)
+	sync := fs.Bool("sync", false, "send keyboard input to all panes (synchronize-panes)")
+	fs.Usage = func() {
+		fmt.Println(tmuxUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if _, err := unlockConfig(); err != nil {
+		log.Error("Failed to load config:", err)
+		os.Exit(1)
+	}
+	group, err := sshw.Resolve(sshw.GetConfig(), fs.Arg(0))
+	switch {
+	case err == sshw.ErrNodeNotFound:
+		err = fmt.Errorf("node not found: %s", fs.Arg(0))
+	case err == nil && len(group.Children) == 0:
+		err = fmt.Errorf("%s is not a group", group.Path())
+	}
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	nodes := sshw.Leaves(group.Children)
+	if len(nodes) == 0 {
+		log.Error("no hosts in", group.Path())
+		os.Exit(1)
+	}
+	if err := openPanes(group.Name, nodes, *sync); err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
+
+// checkTmuxMode 检查 -tmux 参数
+func checkTmuxMode() error {
+	switch *tmuxMode {
+	case "":
+		return nil
+	case "window", "pane":
+		if !inTmux() {
+			return fmt.Errorf("-tmux %s requires running inside tmux", *tmuxMode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid -tmux %q, expected window or pane", *tmuxMode)
+	}
+}
+
+// login 连接节点，设置了 -tmux 时在新的 tmux 窗口或面板中连接
+func login(n *sshw.Node) {
+	var err error
+	switch *tmuxMode {
+	case "window":
+		err = openWindows([]*sshw.Node{n})
+	case "pane":
+		err = openPane(n)
+	default:
+		sshw.NewClient(n).Login()
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
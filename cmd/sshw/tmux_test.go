@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zdev0x/sshw"
+)
+
+func TestLoginCommand(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "it's.yml")
+	os.WriteFile(config, []byte("- name: web\n  host: h\n"), 0600)
+	setFlags(t, config, "id", "key", "pass show sshw", -1, false)
+	wd, _ := os.Getwd()
+
+	cmd, err := loginCommand(&sshw.Node{Name: "web", Alias: "w"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe, _ := os.Executable()
+	want := []string{
+		shellQuote(exe),
+		"'-config'", shellQuote(config),
+		"'-identity'", shellQuote(filepath.Join(wd, "id")),
+		"'-password-file'", shellQuote(filepath.Join(wd, "key")),
+		"'-password-command'", "'pass show sshw'",
+		"'--'", "'w'",
+	}
+	if cmd != strings.Join(want, " ") {
+		t.Fatalf("command:\n got %s\nwant %s", cmd, strings.Join(want, " "))
+	}
+
+	// 与子命令同名的别名和没有别名的节点路径都放在 -- 之后
+	*rememberPassword = true
+	tests := []struct {
+		node *sshw.Node
+		tail string
+	}{
+		{&sshw.Node{Name: "web", Alias: "tmux"}, "'-remember-password' '--' 'tmux'"},
+		{&sshw.Node{Name: "web", Alias: "-"}, "'-remember-password' '--' '-'"},
+		{&sshw.Node{Name: "web"}, "'-remember-password' '--' '/web'"},
+	}
+	for _, tt := range tests {
+		cmd, err := loginCommand(tt.node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(cmd, " "+tt.tail) {
+			t.Errorf("command for %+v: got %s, want suffix %s", tt.node, cmd, tt.tail)
+		}
+	}
+}
+
+func TestForwardPasswordArgs(t *testing.T) {
+	setFlags(t, "", "", "", "", -1, false)
+
+	// 没有使用主密码时不需要传递
+	*passwordStdin = true
+	if args, err := forwardPasswordArgs(); err != nil || args != nil {
+		t.Fatalf("not unlocked with password: %v, %v", args, err)
+	}
+
+	// 标准输入和文件描述符中的密码无法传递，需要 agent
+	passwordUnlocked = true
+	if _, err := forwardPasswordArgs(); err == nil || !strings.Contains(err.Error(), "sshw agent") {
+		t.Fatalf("stdin without agent: got %v", err)
+	}
+	*passwordStdin, *passwordFD = false, 3
+	if _, err := forwardPasswordArgs(); err == nil {
+		t.Fatal("fd without agent accepted")
+	}
+	*passwordFD = -1
+	t.Setenv("SSHW_MASTER_PASSWORD", "secret")
+	if _, err := forwardPasswordArgs(); err == nil {
+		t.Fatal("environment password without agent accepted")
+	}
+
+	// 环境变量中的文件和命令转换为参数
+	t.Setenv("SSHW_PASSWORD_COMMAND", "pass show sshw")
+	args, err := forwardPasswordArgs()
+	if err != nil || strings.Join(args, " ") != "-password-command pass show sshw" {
+		t.Fatalf("password command from env: %v, %v", args, err)
+	}
+
+	// 交互输入的密码由新的 sshw 重新询问或从 agent 获取
+	os.Unsetenv("SSHW_MASTER_PASSWORD")
+	os.Unsetenv("SSHW_PASSWORD_COMMAND")
+	if args, err := forwardPasswordArgs(); err != nil || args != nil {
+		t.Fatalf("interactive password: %v, %v", args, err)
+	}
+}
@@ -0,0 +1,656 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/zdev0x/sshw"
+	"golang.org/x/term"
+)
+
+// 检查连通性的超时时间
+const probeTimeout = 3 * time.Second
+
+const tuiHelp = "enter/c connect  x exec  i copy-id  e edit  t tunnel  r refresh  q quit"
+
+// tuiRow 节点树中显示的一行
+type tuiRow struct {
+	node  *sshw.Node
+	depth int
+}
+
+// probeResult 节点连通性检查结果
+type probeResult struct {
+	done    bool
+	latency time.Duration
+	err     error
+}
+
+// tuiAction 在界面中选择的操作
+type tuiAction struct {
+	kind string
+	node *sshw.Node
+	arg  string
+}
+
+// tuiInput 底部输入框，用于输入要执行的命令和端口转发
+type tuiInput struct {
+	label string
+	kind  string
+	buf   []rune
+}
+
+// tui 全屏界面，左侧为节点树，右侧为选中节点的详情
+type tui struct {
+	mu       sync.Mutex
+	roots    []*sshw.Node
+	expanded map[*sshw.Node]bool
+	rows     []tuiRow
+	cursor   int
+	offset   int
+	history  *sshw.History
+	probes   map[*sshw.Node]*probeResult
+	input    *tuiInput
+	status   string
+	active   bool
+	out      *bufio.Writer
+}
+
+func newTUI() *tui {
+	t := &tui{
+		expanded: make(map[*sshw.Node]bool),
+		probes:   make(map[*sshw.Node]*probeResult),
+		out:      bufio.NewWriter(os.Stdout),
+	}
+	t.reload()
+	return t
+}
+
+// reload 重新读取节点和登录历史
+func (t *tui) reload() {
+	t.roots = topNodes()
+	h, err := sshw.LoadHistory()
+	if err != nil {
+		h = new(sshw.History)
+	}
+	t.history = h
+	t.probes = make(map[*sshw.Node]*probeResult)
+	t.flatten()
+}
+
+// focus 展开到目标节点并选中
+func (t *tui) focus(target *sshw.Node) {
+	path := pathTo(t.roots, target)
+	for _, n := range path {
+		if len(n.Children) > 0 {
+			t.expanded[n] = true
+		}
+	}
+	t.flatten()
+	for i, r := range t.rows {
+		if r.node == target {
+			t.cursor = i
+		}
+	}
+}
+
+// pathTo 返回从第一层到目标节点经过的节点
+func pathTo(nodes []*sshw.Node, target *sshw.Node) []*sshw.Node {
+	for _, n := range nodes {
+		if n == target {
+			return []*sshw.Node{n}
+		}
+		if p := pathTo(n.Children, target); p != nil {
+			return append([]*sshw.Node{n}, p...)
+		}
+	}
+	return nil
+}
+
+// flatten 按展开状态生成显示的行
+func (t *tui) flatten() {
+	var current *sshw.Node
+	if t.cursor < len(t.rows) {
+		current = t.rows[t.cursor].node
+	}
+	t.rows = t.rows[:0]
+	var walk func(nodes []*sshw.Node, depth int)
+	walk = func(nodes []*sshw.Node, depth int) {
+		for _, n := range nodes {
+			t.rows = append(t.rows, tuiRow{node: n, depth: depth})
+			if t.expanded[n] {
+				walk(n.Children, depth+1)
+			}
+		}
+	}
+	walk(t.roots, 0)
+
+	t.cursor = 0
+	for i, r := range t.rows {
+		if r.node == current {
+			t.cursor = i
+			break
+		}
+	}
+}
+
+// run 显示界面直到选择了操作或退出，退出时返回 nil
+func (t *tui) run() *tuiAction {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		log.Error("the full-screen interface requires a terminal")
+		return nil
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+
+	t.mu.Lock()
+	t.active = true
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?25l")
+	t.render()
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		t.active = false
+		fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+		t.out.Flush()
+		t.mu.Unlock()
+		term.Restore(fd, state)
+	}()
+
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return nil
+		}
+		t.mu.Lock()
+		done, action := t.handle(buf[:n])
+		if !done {
+			t.render()
+		}
+		t.mu.Unlock()
+		if done {
+			return action
+		}
+	}
+}
+
+// handle 处理一次按键，返回是否退出界面及选择的操作
+func (t *tui) handle(b []byte) (bool, *tuiAction) {
+	t.status = ""
+	if t.input != nil {
+		return t.handleInput(b)
+	}
+
+	var node *sshw.Node
+	if t.cursor < len(t.rows) {
+		node = t.rows[t.cursor].node
+	}
+	leaf := node != nil && len(node.Children) == 0 && node.Host != ""
+
+	switch string(b) {
+	case "q", "\x1b", "\x03":
+		return true, nil
+	case "\x1b[A", "\x1bOA", "k", "\x10":
+		t.move(-1)
+	case "\x1b[B", "\x1bOB", "j", "\x0e":
+		t.move(1)
+	case "\x1b[5~":
+		t.move(-t.bodyHeight())
+	case "\x1b[6~":
+		t.move(t.bodyHeight())
+	case "g", "\x1b[H", "\x1bOH":
+		t.move(-len(t.rows))
+	case "G", "\x1b[F", "\x1bOF":
+		t.move(len(t.rows))
+	case "\x1b[C", "\x1bOC", "l":
+		if node != nil && len(node.Children) > 0 {
+			t.expanded[node] = true
+			t.flatten()
+		}
+	case "\x1b[D", "\x1bOD", "h":
+		t.collapse()
+	case "\r", "c":
+		if node != nil && len(node.Children) > 0 && string(b) == "\r" {
+			t.expanded[node] = !t.expanded[node]
+			t.flatten()
+			break
+		}
+		if leaf {
+			return true, &tuiAction{kind: "connect", node: node}
+		}
+	case "x":
+		if leaf {
+			t.input = &tuiInput{label: "exec on " + node.Name + ": ", kind: "exec"}
+		}
+	case "t":
+		if leaf {
+			t.input = &tuiInput{label: "forward [bind:]port:host:hostport: ", kind: "tunnel"}
+		}
+	case "i":
+		if leaf {
+			return true, &tuiAction{kind: "copy-id", node: node}
+		}
+	case "e":
+		if *useLocalSSHConfig {
+			t.status = "editing is only supported for the sshw config"
+			break
+		}
+		if sshw.IsFileEncrypted() {
+			t.status = "the config file is encrypted, run sshw -decrypt to edit it"
+			break
+		}
+		return true, &tuiAction{kind: "edit", node: node}
+	case "r":
+		if node != nil {
+			delete(t.probes, node)
+		}
+	}
+	return false, nil
+}
+
+// handleInput 处理底部输入框的按键
+func (t *tui) handleInput(b []byte) (bool, *tuiAction) {
+	in := t.input
+	switch string(b) {
+	case "\r":
+		t.input = nil
+		arg := strings.TrimSpace(string(in.buf))
+		if arg == "" {
+			return false, nil
+		}
+		return true, &tuiAction{kind: in.kind, node: t.rows[t.cursor].node, arg: arg}
+	case "\x1b", "\x03":
+		t.input = nil
+	case "\x7f", "\x08":
+		if len(in.buf) > 0 {
+			in.buf = in.buf[:len(in.buf)-1]
+		}
+	case "\x15":
+		in.buf = nil
+	default:
+		if b[0] == 0x1b {
+			break
+		}
+		for len(b) > 0 {
+			r, size := utf8.DecodeRune(b)
+			b = b[size:]
+			if unicode.IsPrint(r) {
+				in.buf = append(in.buf, r)
+			}
+		}
+	}
+	return false, nil
+}
+
+func (t *tui) move(delta int) {
+	t.cursor += delta
+	if t.cursor >= len(t.rows) {
+		t.cursor = len(t.rows) - 1
+	}
+	if t.cursor < 0 {
+		t.cursor = 0
+	}
+}
+
+// collapse 折叠当前分组，当前是子节点时回到上级分组
+func (t *tui) collapse() {
+	if t.cursor >= len(t.rows) {
+		return
+	}
+	row := t.rows[t.cursor]
+	if t.expanded[row.node] {
+		t.expanded[row.node] = false
+		t.flatten()
+		return
+	}
+	for i := t.cursor - 1; i >= 0; i-- {
+		if t.rows[i].depth < row.depth {
+			t.cursor = i
+			return
+		}
+	}
+}
+
+func (t *tui) size() (int, int) {
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || w <= 0 || h <= 0 {
+		return 80, 24
+	}
+	return w, h
+}
+
+// bodyHeight 节点树可以显示的行数，去掉标题和底部提示
+func (t *tui) bodyHeight() int {
+	_, h := t.size()
+	if h < 3 {
+		return 1
+	}
+	return h - 2
+}
+
+// render 重绘整个界面，调用时需要持有锁
+func (t *tui) render() {
+	if !t.active {
+		return
+	}
+	w, _ := t.size()
+	body := t.bodyHeight()
+	if t.cursor < t.offset {
+		t.offset = t.cursor
+	}
+	if t.cursor >= t.offset+body {
+		t.offset = t.cursor - body + 1
+	}
+
+	left := w * 2 / 5
+	if left < 20 {
+		left = 20
+	}
+	if left > 50 {
+		left = 50
+	}
+	right := w - left - 3
+	if right < 0 {
+		right = 0
+	}
+
+	var details []string
+	if t.cursor < len(t.rows) {
+		details = t.details(t.rows[t.cursor].node, right)
+	}
+
+	fmt.Fprint(t.out, "\x1b[H")
+	fmt.Fprintf(t.out, "\x1b[1m%s\x1b[0m\x1b[K\r\n", pad(" sshw", w))
+	for i := 0; i < body; i++ {
+		cell := pad("", left)
+		if r := t.offset + i; r < len(t.rows) {
+			cell = t.rowText(t.rows[r], r == t.cursor, left)
+		}
+		detail := ""
+		if i < len(details) {
+			detail = details[i]
+		}
+		fmt.Fprintf(t.out, "%s %s %s\x1b[K\r\n", cell, paint("muted", "│"), detail)
+	}
+
+	switch {
+	case t.input != nil:
+		fmt.Fprintf(t.out, "%s%s\x1b[K", paint("label", t.input.label), string(t.input.buf))
+		fmt.Fprint(t.out, "\x1b[?25h")
+	case t.status != "":
+		fmt.Fprintf(t.out, "%s\x1b[K", paint("accent", truncate(t.status, w-1)))
+		fmt.Fprint(t.out, "\x1b[?25l")
+	default:
+		fmt.Fprintf(t.out, "%s\x1b[K", paint("muted", truncate(tuiHelp, w-1)))
+		fmt.Fprint(t.out, "\x1b[?25l")
+	}
+	t.out.Flush()
+}
+
+// rowText 生成节点树中一行的显示内容
+func (t *tui) rowText(r tuiRow, active bool, width int) string {
+	marker := "  "
+	if len(r.node.Children) > 0 {
+		marker = "▸ "
+		if t.expanded[r.node] {
+			marker = "▾ "
+		}
+	}
+	text := strings.Repeat("  ", r.depth) + marker + r.node.Name
+	if r.node.Alias != "" {
+		text += " (" + r.node.Alias + ")"
+	}
+	text = pad(" "+text, width)
+
+	switch {
+	case active:
+		return "\x1b[7m" + text + "\x1b[0m"
+	case len(r.node.Children) > 0:
+		return "\x1b[1m" + text + "\x1b[0m"
+	}
+	return text
+}
+
+// details 生成右侧的节点详情
+func (t *tui) details(n *sshw.Node, width int) []string {
+	var lines []string
+	addStyled := func(label, value, role string) {
+		if value == "" {
+			return
+		}
+		lines = append(lines, paint("muted", fmt.Sprintf("%-10s", label))+" "+paint(role, truncate(value, width-11)))
+	}
+	add := func(label, value string) {
+		addStyled(label, value, "")
+	}
+
+	add("name", n.Name)
+	add("path", n.Path())
+	add("alias", n.Alias)
+	add("tags", strings.Join(n.AllTags(), ", "))
+	if len(n.Children) > 0 {
+		add("nodes", fmt.Sprint(len(sshw.Leaves(n.Children))))
+		return lines
+	}
+	if n.Host == "" {
+		return lines
+	}
+
+	add("host", displayHost(n))
+	add("user", n.GetUser())
+	if len(n.Jump) > 0 {
+		j := n.Jump[0]
+		name := j.Name
+		if name == "" {
+			name = displayHost(j)
+		}
+		add("jump", fmt.Sprintf("%s@%s → %s", j.GetUser(), name, n.Name))
+	}
+	if e := t.history.LastLogin(n); e != nil {
+		add("last", fmt.Sprintf("%s (%s, %s)", e.Time.Format("2006-01-02 15:04"), humanizeAge(time.Since(e.Time)), e.Duration))
+	} else {
+		add("last", "never")
+	}
+	reachable, role := t.probe(n)
+	addStyled("reachable", reachable, role)
+	return lines
+}
+
+// probe 返回节点连通性及显示样式，第一次选中时在后台检查，完成后重绘
+func (t *tui) probe(n *sshw.Node) (string, string) {
+	p, ok := t.probes[n]
+	if !ok {
+		p = new(probeResult)
+		t.probes[n] = p
+		go func() {
+			latency, err := n.Probe(probeTimeout)
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			p.done, p.latency, p.err = true, latency, err
+			t.render()
+		}()
+	}
+
+	via := ""
+	if len(n.Jump) > 0 {
+		via = " via jump host"
+	}
+	switch {
+	case !p.done:
+		return "checking...", "muted"
+	case p.err != nil:
+		// 只显示原因，不显示可能被隐藏的地址
+		err := p.err
+		if opErr, ok := err.(*net.OpError); ok {
+			err = opErr.Err
+		}
+		return "no" + via + ": " + err.Error(), "error"
+	}
+	return fmt.Sprintf("yes%s (%s)", via, p.latency.Round(time.Millisecond)), "ok"
+}
+
+// displayHost 按 show_host 和 mask_host 显示主机
+func displayHost(n *sshw.Node) string {
+	if host := n.GetMaskedHost(); host != "" {
+		return fmt.Sprintf("%s:%d", host, n.GetPort())
+	}
+	return "(hidden)"
+}
+
+// humanizeAge 把时间间隔格式化为 5m ago 的形式
+func humanizeAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+}
+
+// pad 截断或用空格补齐到指定显示宽度
+func pad(s string, width int) string {
+	s = truncate(s, width)
+	return s + strings.Repeat(" ", width-textWidth(s))
+}
+
+// runTUI 运行全屏界面，执行命令、端口转发等操作后回到界面，连接服务器后退出
+func runTUI(focus *sshw.Node) {
+	t := newTUI()
+	if focus != nil {
+		t.focus(focus)
+	}
+	for {
+		action := t.run()
+		if action == nil {
+			return
+		}
+		if !t.perform(action) {
+			return
+		}
+		t.mu.Lock()
+		t.reload()
+		t.mu.Unlock()
+	}
+}
+
+// perform 执行界面中选择的操作，返回是否回到界面
+func (t *tui) perform(a *tuiAction) bool {
+	switch a.kind {
+	case "connect":
+		// 设置了 -tmux 时在新的窗口或面板中连接，之后回到界面
+		login(a.node)
+		return *tmuxMode != ""
+	case "exec":
+		fmt.Printf("%s$ %s\n", a.node.Name, a.arg)
+		if err := sshw.NewClient(a.node).Exec(a.arg); err != nil {
+			log.Error(err)
+		}
+	case "copy-id":
+		path, key, err := sshw.DefaultPublicKey()
+		if err != nil {
+			log.Error(err)
+			break
+		}
+		fmt.Printf("copying %s to %s\n", path, a.node.Path())
+		if err := sshw.NewClient(a.node).CopyID(key); err != nil {
+			log.Error(err)
+		}
+	case "tunnel":
+		if err := sshw.NewClient(a.node).Tunnel(a.arg); err != nil {
+			log.Error(err)
+		}
+	case "edit":
+		if err := editConfig(a.node); err != nil {
+			log.Error(err)
+			waitEnter()
+			return true
+		}
+		if _, err := unlockConfig(); err != nil {
+			log.Error("load config error", err)
+			return false
+		}
+		return true
+	}
+	waitEnter()
+	return true
+}
+
+// waitEnter 等待按下回车后回到界面
+func waitEnter() {
+	fmt.Print("\npress Enter to return")
+	b := make([]byte, 1)
+	for {
+		if n, err := os.Stdin.Read(b); err != nil || (n == 1 && b[0] == '\n') {
+			return
+		}
+	}
+}
+
+// 支持 +行号 参数的编辑器
+var lineEditors = map[string]bool{"vi": true, "vim": true, "nvim": true, "nano": true, "emacs": true, "micro": true}
+
+// editConfig 使用 $VISUAL 或 $EDITOR 打开配置文件，并尽量定位到节点所在的行
+func editConfig(n *sshw.Node) error {
+	path, err := sshw.ConfigFile(*configFile)
+	if err != nil {
+		return err
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := strings.Fields(editor)
+	if n != nil && lineEditors[filepath.Base(args[0])] {
+		if line := nodeLine(path, n); line > 0 {
+			args = append(args, fmt.Sprintf("+%d", line))
+		}
+	}
+	args = append(args, path)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// nodeLine 返回配置文件中节点名称所在的行号，找不到时返回 0
+func nodeLine(path string, n *sshw.Node) int {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	re, err := regexp.Compile(`^\s*-?\s*"?name"?\s*:\s*["']?` + regexp.QuoteMeta(n.Name) + `["']?\s*,?\s*$`)
+	if err != nil {
+		return 0
+	}
+	for i, line := range strings.Split(string(b), "\n") {
+		if re.MatchString(line) {
+			return i + 1
+		}
+	}
+	return 0
+}
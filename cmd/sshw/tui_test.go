@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/zdev0x/sshw"
+)
+
+// testTUI 返回显示 roots 的界面，不读取配置和登录历史
+func testTUI(roots []*sshw.Node) *tui {
+	t := &tui{
+		roots:    roots,
+		expanded: make(map[*sshw.Node]bool),
+		probes:   make(map[*sshw.Node]*probeResult),
+		history:  new(sshw.History),
+		out:      bufio.NewWriter(io.Discard),
+	}
+	t.flatten()
+	return t
+}
+
+func treeNodes() []*sshw.Node {
+	return []*sshw.Node{
+		{Name: "prod", Children: []*sshw.Node{
+			{Name: "web", Host: "h1"},
+			{Name: "eu", Children: []*sshw.Node{{Name: "db", Host: "h2"}}},
+		}},
+		{Name: "bastion", Host: "h3"},
+	}
+}
+
+// visible 返回显示的行，子节点按层级缩进
+func visible(t *tui) []string {
+	var rows []string
+	for _, r := range t.rows {
+		name := r.node.Name
+		for i := 0; i < r.depth; i++ {
+			name = "  " + name
+		}
+		rows = append(rows, name)
+	}
+	return rows
+}
+
+func TestTUINavigation(t *testing.T) {
+	ui := testTUI(treeNodes())
+	steps := []struct {
+		key    string
+		rows   []string
+		cursor string
+	}{
+		{"", []string{"prod", "bastion"}, "prod"},
+		{"\x1b[C", []string{"prod", "  web", "  eu", "bastion"}, "prod"},
+		{"j", []string{"prod", "  web", "  eu", "bastion"}, "web"},
+		{"j", []string{"prod", "  web", "  eu", "bastion"}, "eu"},
+		{"\r", []string{"prod", "  web", "  eu", "    db", "bastion"}, "eu"},
+		{"\x1b[B", []string{"prod", "  web", "  eu", "    db", "bastion"}, "db"},
+		// 在子节点上向左回到上级分组，再次向左折叠分组
+		{"\x1b[D", []string{"prod", "  web", "  eu", "    db", "bastion"}, "eu"},
+		{"h", []string{"prod", "  web", "  eu", "bastion"}, "eu"},
+		{"h", []string{"prod", "  web", "  eu", "bastion"}, "prod"},
+		{"h", []string{"prod", "bastion"}, "prod"},
+		{"G", []string{"prod", "bastion"}, "bastion"},
+		{"j", []string{"prod", "bastion"}, "bastion"},
+		{"g", []string{"prod", "bastion"}, "prod"},
+		{"k", []string{"prod", "bastion"}, "prod"},
+	}
+	for i, s := range steps {
+		if s.key != "" {
+			if done, _ := ui.handle([]byte(s.key)); done {
+				t.Fatalf("step %d: %q closed the interface", i, s.key)
+			}
+		}
+		if got := visible(ui); !reflect.DeepEqual(got, s.rows) {
+			t.Fatalf("step %d %q: rows %q, want %q", i, s.key, got, s.rows)
+		}
+		if got := ui.rows[ui.cursor].node.Name; got != s.cursor {
+			t.Fatalf("step %d %q: cursor on %s, want %s", i, s.key, got, s.cursor)
+		}
+	}
+
+	// 分组上的 c 不连接，节点上回车连接
+	if done, _ := ui.handle([]byte("c")); done {
+		t.Fatal("connected to a group")
+	}
+	ui.handle([]byte("G"))
+	done, action := ui.handle([]byte("\r"))
+	if !done || action == nil || action.kind != "connect" || action.node.Name != "bastion" {
+		t.Fatalf("enter on a host: %v, %+v", done, action)
+	}
+	if done, action := ui.handle([]byte("q")); !done || action != nil {
+		t.Fatalf("quit: %v, %+v", done, action)
+	}
+}
+
+func TestTUIFocus(t *testing.T) {
+	nodes := treeNodes()
+	ui := testTUI(nodes)
+	db := nodes[0].Children[1].Children[0]
+	ui.focus(db)
+	if got := visible(ui); !reflect.DeepEqual(got, []string{"prod", "  web", "  eu", "    db", "bastion"}) {
+		t.Fatalf("rows: %q", got)
+	}
+	if ui.rows[ui.cursor].node != db {
+		t.Fatalf("cursor on %s", ui.rows[ui.cursor].node.Name)
+	}
+}
+
+func TestTUIInput(t *testing.T) {
+	ui := testTUI(treeNodes())
+	ui.handle([]byte("G"))
+
+	// 输入要执行的命令，退格删除，Esc 取消
+	ui.handle([]byte("x"))
+	for _, key := range []string{"u", "p", "x", "\x7f", "时", "\x1b[A", "\r"} {
+		done, action := ui.handle([]byte(key))
+		if key != "\r" {
+			if done {
+				t.Fatalf("%q closed the interface", key)
+			}
+			continue
+		}
+		if !done || action == nil || action.kind != "exec" || action.arg != "up时" || action.node.Name != "bastion" {
+			t.Fatalf("exec action: %v, %+v", done, action)
+		}
+	}
+	if ui.input != nil {
+		t.Fatal("input not closed")
+	}
+
+	ui.handle([]byte("t"))
+	ui.handle([]byte("8080:localhost:80"))
+	ui.handle([]byte("\x1b"))
+	if ui.input != nil {
+		t.Fatal("Esc did not cancel the input")
+	}
+	// 空命令不执行
+	ui.handle([]byte("x"))
+	if done, _ := ui.handle([]byte("\r")); done {
+		t.Fatal("empty command executed")
+	}
+
+	// 分组上不能执行命令
+	ui.handle([]byte("g"))
+	ui.handle([]byte("x"))
+	if ui.input != nil {
+		t.Fatal("exec input opened on a group")
+	}
+}
+
+func TestNodeLine(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "sshw.yml")
+	ioutil.WriteFile(p, []byte(`- name: prod
+  children:
+    - name: "web"
+      host: h1
+    - { name: db, host: h2 }
+- name: 'web-2'
+  host: h3
+`), 0600)
+	for name, want := range map[string]int{"prod": 1, "web": 3, "web-2": 6, "db": 0, "missing": 0} {
+		if got := nodeLine(p, &sshw.Node{Name: name}); got != want {
+			t.Errorf("%s: got line %d, want %d", name, got, want)
+		}
+	}
+}
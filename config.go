@@ -2,12 +2,14 @@ package sshw
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
 	"os/user"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -15,26 +17,40 @@ import (
 	"github.com/atrox/homedir"
 	"github.com/kevinburke/ssh_config"
 	"github.com/zdev0x/sshw/crypto"
+	"github.com/zdev0x/sshw/vault"
 	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v2"
 )
 
 type Node struct {
-	Name              string           `yaml:"name" json:"name"`
-	Alias             string           `yaml:"alias,omitempty" json:"alias,omitempty"`
-	Host              string           `yaml:"host" json:"host"`
-	User              string           `yaml:"user,omitempty" json:"user,omitempty"`
-	Port              int              `yaml:"port,omitempty" json:"port,omitempty"`
-	KeyPath           string           `yaml:"keypath,omitempty" json:"keypath,omitempty"`
-	Passphrase        string           `yaml:"passphrase,omitempty" json:"passphrase,omitempty"`
-	Password          string           `yaml:"password,omitempty" json:"password,omitempty"`
-	IsEncrypted       bool             `yaml:"is_encrypted,omitempty" json:"is_encrypted,omitempty"`
-	CallbackShells    []*CallbackShell `yaml:"callback-shells,omitempty" json:"callback-shells,omitempty"`
-	Children          []*Node          `yaml:"children,omitempty" json:"children,omitempty"`
-	Jump              []*Node          `yaml:"jump,omitempty" json:"jump,omitempty"`
-	MaskHost          bool             `yaml:"mask_host,omitempty" json:"mask_host,omitempty"`
-	ShowHost          bool             `yaml:"show_host,omitempty" json:"show_host,omitempty"`
-	EnableLoginMarker bool             `yaml:"enable_login_marker,omitempty" json:"enable_login_marker,omitempty"`
+	Name                string           `yaml:"name" json:"name"`
+	Alias               string           `yaml:"alias,omitempty" json:"alias,omitempty"`
+	Tags                []string         `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Host                string           `yaml:"host" json:"host"`
+	User                string           `yaml:"user,omitempty" json:"user,omitempty"`
+	Port                int              `yaml:"port,omitempty" json:"port,omitempty"`
+	KeyPath             string           `yaml:"keypath,omitempty" json:"keypath,omitempty"`
+	CertPath            string           `yaml:"certpath,omitempty" json:"certpath,omitempty"`
+	KeyPaths            []*KeyFile       `yaml:"keypaths,omitempty" json:"keypaths,omitempty"`
+	KeyTypes            []string         `yaml:"key_types,omitempty" json:"key_types,omitempty"`
+	MaxKeys             int              `yaml:"max_keys,omitempty" json:"max_keys,omitempty"`
+	Passphrase          string           `yaml:"passphrase,omitempty" json:"passphrase,omitempty"`
+	Password            string           `yaml:"password,omitempty" json:"password,omitempty"`
+	TOTPSecret          string           `yaml:"totp_secret,omitempty" json:"totp_secret,omitempty"`
+	TOTPPrompt          string           `yaml:"totp_prompt,omitempty" json:"totp_prompt,omitempty"`
+	KeyboardInteractive []*PromptAnswer  `yaml:"keyboard_interactive,omitempty" json:"keyboard_interactive,omitempty"`
+	IsEncrypted         bool             `yaml:"is_encrypted,omitempty" json:"is_encrypted,omitempty"`
+	Sealed              string           `yaml:"sealed,omitempty" json:"sealed,omitempty"`
+	CallbackShells      []*CallbackShell `yaml:"callback-shells,omitempty" json:"callback-shells,omitempty"`
+	Children            []*Node          `yaml:"children,omitempty" json:"children,omitempty"`
+	Jump                []*Node          `yaml:"jump,omitempty" json:"jump,omitempty"`
+	MaskHost            bool             `yaml:"mask_host,omitempty" json:"mask_host,omitempty"`
+	ShowHost            bool             `yaml:"show_host,omitempty" json:"show_host,omitempty"`
+	EnableLoginMarker   bool             `yaml:"enable_login_marker,omitempty" json:"enable_login_marker,omitempty"`
+	SSHCert             *CertConfig      `yaml:"ssh_cert,omitempty" json:"ssh_cert,omitempty"`
+
+	// 所属的分组或使用该跳板机的节点，加载时设置
+	parent *Node
 }
 
 type CallbackShell struct {
@@ -60,6 +76,16 @@ func (n *Node) port() int {
 	return n.Port
 }
 
+// GetUser 返回登录用户，未设置时为 root
+func (n *Node) GetUser() string {
+	return n.user()
+}
+
+// GetPort 返回端口，未设置时为 22
+func (n *Node) GetPort() int {
+	return n.port()
+}
+
 func (n *Node) password() ssh.AuthMethod {
 	if n.Password == "" {
 		return nil
@@ -71,8 +97,51 @@ func (n *Node) alias() string {
 	return n.Alias
 }
 
+// Path 返回节点在配置树中的路径，如 prod/web
+func (n *Node) Path() string {
+	if n.parent == nil {
+		return n.Name
+	}
+	return n.parent.Path() + "/" + n.Name
+}
+
+// linkParents 设置节点的 parent
+func linkParents(nodes []*Node, parent *Node) {
+	for _, node := range nodes {
+		node.parent = parent
+		linkParents(node.Children, node)
+		linkParents(node.Jump, node)
+	}
+}
+
+// Document 配置文件结构，节点列表之外可以携带配置头
+// 没有配置头的旧格式（顶层即节点列表）仍然可以直接加载
+type Document struct {
+	Encryption *crypto.Header `yaml:"encryption,omitempty" json:"encryption,omitempty"`
+	Vault      *vault.Config  `yaml:"vault,omitempty" json:"vault,omitempty"`
+	// 信任的主机 CA 及吊销列表
+	HostCAKeys      []string  `yaml:"host_ca_keys,omitempty" json:"host_ca_keys,omitempty"`
+	RevokedHostKeys string    `yaml:"revoked_host_keys,omitempty" json:"revoked_host_keys,omitempty"`
+	UI              *UIConfig `yaml:"ui,omitempty" json:"ui,omitempty"`
+	Nodes           []*Node   `yaml:"nodes" json:"nodes"`
+}
+
+// hasSettings 是否有节点列表之外的设置，没有时保存为节点列表格式
+func (d *Document) hasSettings() bool {
+	return d.Encryption != nil || d.Vault != nil || len(d.HostCAKeys) > 0 || d.RevokedHostKeys != "" || d.UI != nil
+}
+
 var (
 	config []*Node
+	// 当前配置的配置头及解出的数据密钥
+	header  *crypto.Header
+	dataKey []byte
+	// 是否整个文件加密
+	fileMode bool
+	// 是否使用旧格式按字段派生密钥解密
+	legacyKey bool
+
+	ErrPasswordRequired = errors.New("config file is encrypted, master password required")
 )
 
 func GetConfig() []*Node {
@@ -80,48 +149,163 @@ func GetConfig() []*Node {
 }
 
 func LoadConfig(password []byte, configPath string) error {
-	var b []byte
-	var err error
+	return loadConfig(password, nil, configPath)
+}
 
-	// 如果指定了配置文件路径，直接加载
-	if configPath != "" {
-		b, err = ioutil.ReadFile(configPath)
+// LoadConfigWithIdentities 使用接收者的身份私钥解锁并加载配置
+// 没有匹配的接收者时返回错误，调用方可以改用主密码
+func LoadConfigWithIdentities(ids []*crypto.Identity, configPath string) error {
+	return loadConfig(nil, ids, configPath)
+}
+
+func loadConfig(password []byte, ids []*crypto.Identity, configPath string) error {
+	b, err := readConfigBytes(configPath)
+	if err != nil {
+		return err
+	}
+
+	header, dataKey, fileMode, legacyKey = nil, nil, false, false
+	decrypt := password != nil || len(ids) > 0
+
+	// 整个文件加密时先解出配置内容
+	if crypto.IsEnvelope(b) {
+		e, err := crypto.ParseEnvelope(b)
 		if err != nil {
 			return err
 		}
-	} else {
-		// 否则按默认顺序加载
-		b, err = LoadConfigBytes(".sshw", ".sshw.yml", ".sshw.yaml", ".sshw.json")
+		// 未解锁时也保留配置头，便于查看接收者
+		header, fileMode = e.Encryption, true
+		if !decrypt {
+			return ErrPasswordRequired
+		}
+		b, err = openEnvelope(e, password, ids)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to decrypt config: %v", err)
 		}
 	}
 
-	var c []*Node
-	// 尝试解析为 YAML
-	err = yaml.Unmarshal(b, &c)
+	doc, err := parseConfig(b)
 	if err != nil {
-		// 如果 YAML 解析失败，尝试解析为 JSON
-		err = json.Unmarshal(b, &c)
-		if err != nil {
-			return fmt.Errorf("failed to parse config file: %v", err)
+		return err
+	}
+	if doc.UI != nil {
+		if err := doc.UI.validate(); err != nil {
+			return err
 		}
 	}
+	if fileMode {
+		// 字段设置保存在内层文档中
+		if doc.Encryption != nil {
+			header.Fields = doc.Encryption.Fields
+		}
+	} else {
+		header = doc.Encryption
+	}
 
-	// 如果提供了密码，尝试解密
-	if password != nil {
+	// 如果提供了密码或身份私钥，尝试解密
+	if decrypt && hasEncrypted(doc.Nodes) {
+		c, err := unlock(password, ids)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt config: %v", err)
+		}
 		// 解密所有节点
-		for _, node := range c {
-			if err := node.DecryptFields(password); err != nil {
+		for _, node := range doc.Nodes {
+			if err := node.DecryptFields(c); err != nil {
 				return fmt.Errorf("failed to decrypt config: %v", err)
 			}
 		}
 	}
 
-	config = c
+	linkParents(doc.Nodes, nil)
+	config = doc.Nodes
+	vaultConfig, vaultClient = doc.Vault, nil
+	hostCAKeys, revokedHostKeys = doc.HostCAKeys, doc.RevokedHostKeys
+	uiConfig = doc.UI
 	return nil
 }
 
+// openEnvelope 解密整个文件加密的容器，返回内层配置内容
+func openEnvelope(e *crypto.Envelope, password []byte, ids []*crypto.Identity) ([]byte, error) {
+	key, err := unwrapKey(e.Encryption, password, ids)
+	if err != nil {
+		return nil, err
+	}
+	c, err := crypto.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := c.Decrypt(e.Payload)
+	if err != nil {
+		return nil, err
+	}
+	dataKey = key
+	return plain, nil
+}
+
+// readConfigBytes 读取指定的配置文件，未指定时按默认顺序查找
+func readConfigBytes(configPath string) ([]byte, error) {
+	if configPath != "" {
+		return ioutil.ReadFile(configPath)
+	}
+	return LoadConfigBytes(".sshw", ".sshw.yml", ".sshw.yaml", ".sshw.json")
+}
+
+// parseConfig 解析配置内容，依次尝试节点列表和带配置头的文档格式
+func parseConfig(b []byte) (*Document, error) {
+	var c []*Node
+	if err := yaml.Unmarshal(b, &c); err == nil {
+		return &Document{Nodes: c}, nil
+	}
+	doc := new(Document)
+	if err := yaml.Unmarshal(b, doc); err == nil {
+		return doc, nil
+	}
+
+	// 如果 YAML 解析失败，尝试解析为 JSON
+	if err := json.Unmarshal(b, &c); err == nil {
+		return &Document{Nodes: c}, nil
+	}
+	doc = new(Document)
+	if err := json.Unmarshal(b, doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	return doc, nil
+}
+
+// unlock 解出数据密钥，没有配置头的旧配置按字段派生密钥
+func unlock(password []byte, ids []*crypto.Identity) (crypto.Cipher, error) {
+	if dataKey != nil {
+		return crypto.NewCipher(dataKey)
+	}
+	if header == nil || (header.Key == "" && len(header.Recipients) == 0) {
+		if password == nil {
+			return nil, ErrPasswordRequired
+		}
+		legacyKey = true
+		return crypto.NewPasswordCipher(password), nil
+	}
+	key, err := unwrapKey(header, password, ids)
+	if err != nil {
+		return nil, err
+	}
+	dataKey = key
+	return crypto.NewCipher(key)
+}
+
+// unwrapKey 优先使用身份私钥解出数据密钥，失败时使用主密码
+func unwrapKey(h *crypto.Header, password []byte, ids []*crypto.Identity) ([]byte, error) {
+	if len(ids) > 0 && len(h.Recipients) > 0 {
+		key, err := h.UnwrapIdentity(ids)
+		if err == nil || password == nil {
+			return key, err
+		}
+	}
+	if password == nil {
+		return nil, ErrPasswordRequired
+	}
+	return h.Unwrap(password)
+}
+
 func LoadSshConfig() error {
 	u, err := user.Current()
 	if err != nil {
@@ -158,6 +342,29 @@ func LoadSshConfig() error {
 	return nil
 }
 
+// ConfigFile 返回使用的配置文件路径，查找顺序与加载时相同
+func ConfigFile(configPath string) (string, error) {
+	if configPath != "" {
+		return configPath, nil
+	}
+	names := []string{".sshw", ".sshw.yml", ".sshw.yaml", ".sshw.json"}
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if _, err := os.Stat(path.Join(u.HomeDir, name)); err == nil {
+			return path.Join(u.HomeDir, name), nil
+		}
+	}
+	for _, name := range names {
+		if _, err := os.Stat(name); err == nil {
+			return filepath.Abs(name)
+		}
+	}
+	return "", fmt.Errorf("config file not found")
+}
+
 func LoadConfigBytes(names ...string) ([]byte, error) {
 	u, err := user.Current()
 	if err != nil {
@@ -180,153 +387,117 @@ func LoadConfigBytes(names ...string) ([]byte, error) {
 	return nil, err
 }
 
-// DecryptFields 解密加密的字段
-func (n *Node) DecryptFields(key []byte) error {
-	if !n.IsEncrypted {
-		return nil
-	}
-
-	if n.Password != "" {
-		decrypted, err := crypto.Decrypt(n.Password, key)
-		if err != nil {
-			return fmt.Errorf("failed to decrypt password: %v", err)
-		}
-		n.Password = string(decrypted)
-	}
-
-	if n.Passphrase != "" {
-		decrypted, err := crypto.Decrypt(n.Passphrase, key)
+// SaveConfig 保存配置到文件
+// 未指定路径时保存到加载时使用的配置文件，没有配置文件时保存到 ~/.sshw.yml
+func SaveConfig(nodes []*Node, configPath string) error {
+	if configPath == "" {
+		p, err := ConfigFile("")
 		if err != nil {
-			return fmt.Errorf("failed to decrypt passphrase: %v", err)
+			u, uerr := user.Current()
+			if uerr != nil {
+				return uerr
+			}
+			p = path.Join(u.HomeDir, ".sshw.yml")
 		}
-		n.Passphrase = string(decrypted)
-	}
-
-	n.IsEncrypted = false
-
-	// 递归处理子节点
-	for _, child := range n.Children {
-		if err := child.DecryptFields(key); err != nil {
-			return err
+		configPath = p
+	}
+
+	// 没有其他设置时保持节点列表格式，未加密时只保留字段设置
+	doc := &Document{
+		Vault:           vaultConfig,
+		HostCAKeys:      hostCAKeys,
+		RevokedHostKeys: revokedHostKeys,
+		UI:              uiConfig,
+		Nodes:           nodes,
+	}
+	if header != nil {
+		doc.Encryption = header
+		if fileMode || !hasEncrypted(nodes) {
+			doc.Encryption = nil
+			if len(header.Fields) > 0 || (!fileMode && header.PasswordCommand != "") {
+				doc.Encryption = &crypto.Header{Fields: header.Fields}
+				if !fileMode {
+					doc.Encryption.PasswordCommand = header.PasswordCommand
+				}
+			}
 		}
 	}
-
-	// 递归处理跳转节点
-	for _, jump := range n.Jump {
-		if err := jump.DecryptFields(key); err != nil {
-			return err
-		}
+	var v interface{} = doc
+	if !doc.hasSettings() {
+		v = nodes
 	}
 
-	return nil
-}
-
-// EncryptFields 加密敏感字段
-func (n *Node) EncryptFields(key []byte) error {
-	if n.IsEncrypted {
-		return nil
+	var (
+		data []byte
+		err  error
+	)
+	// 根据文件扩展名决定保存格式，整个文件加密时内层统一使用 YAML
+	if strings.HasSuffix(configPath, ".json") && !fileMode {
+		data, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		data, err = yaml.Marshal(v)
 	}
-
-	if n.Password != "" {
-		encrypted, err := crypto.Encrypt([]byte(n.Password), key)
-		if err != nil {
-			return fmt.Errorf("failed to encrypt password: %v", err)
-		}
-		n.Password = encrypted
+	if err != nil {
+		return err
 	}
 
-	if n.Passphrase != "" {
-		encrypted, err := crypto.Encrypt([]byte(n.Passphrase), key)
+	if fileMode {
+		data, err = sealEnvelope(data)
 		if err != nil {
-			return fmt.Errorf("failed to encrypt passphrase: %v", err)
-		}
-		n.Passphrase = encrypted
-	}
-
-	n.IsEncrypted = true
-
-	// 递归处理子节点
-	for _, child := range n.Children {
-		if err := child.EncryptFields(key); err != nil {
-			return err
-		}
-	}
-
-	// 递归处理跳转节点
-	for _, jump := range n.Jump {
-		if err := jump.EncryptFields(key); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return ioutil.WriteFile(configPath, data, 0600)
 }
 
-// SaveConfig 保存配置到文件
-func SaveConfig(nodes []*Node, configPath string) error {
-	u, err := user.Current()
+// sealEnvelope 用数据密钥加密整个配置内容并放入容器
+func sealEnvelope(data []byte) ([]byte, error) {
+	c, err := crypto.NewCipher(dataKey)
 	if err != nil {
-		return err
-	}
-
-	var data []byte
-	// 根据文件扩展名决定保存格式
-	if strings.HasSuffix(configPath, ".json") {
-		data, err = json.MarshalIndent(nodes, "", "  ")
-	} else {
-		data, err = yaml.Marshal(nodes)
+		return nil, err
 	}
+	payload, err := c.Encrypt(data)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to encrypt config: %v", err)
 	}
+	h := *header
+	h.Fields = nil
+	e := &crypto.Envelope{Encryption: &h, Payload: payload}
+	return e.Marshal()
+}
 
-	// 如果指定了配置文件路径，保存到指定路径
-	if configPath != "" {
-		return ioutil.WriteFile(configPath, data, 0600)
-	}
+// IsLegacyEncrypted 当前配置是否是按字段派生密钥的旧格式，加载时每个字段都要派生一次密钥
+// 使用 Rekey 重新加密后保存即可迁移到数据密钥格式
+func IsLegacyEncrypted() bool {
+	return legacyKey
+}
 
-	// 否则保存到默认路径
-	defaultPath := path.Join(u.HomeDir, ".sshw.yml")
-	return ioutil.WriteFile(defaultPath, data, 0600)
+// PasswordCommand 返回配置头中获取主密码的外部命令
+func PasswordCommand() string {
+	if header == nil {
+		return ""
+	}
+	return header.PasswordCommand
 }
 
 // IsConfigEncrypted 检查配置是否加密
+// 整个文件加密时通过首行标识判断，否则检查节点树中是否有加密的节点
 func IsConfigEncrypted(configPath string) (bool, error) {
-	var b []byte
-	var err error
-
-	// 如果指定了配置文件路径，直接加载
-	if configPath != "" {
-		b, err = ioutil.ReadFile(configPath)
-		if err != nil {
-			return false, err
-		}
-	} else {
-		// 否则按默认顺序加载
-		b, err = LoadConfigBytes(".sshw", ".sshw.yml", ".sshw.yaml", ".sshw.json")
-		if err != nil {
-			return false, err
-		}
+	b, err := readConfigBytes(configPath)
+	if err != nil {
+		return false, err
 	}
 
-	var c []*Node
-	// 尝试解析为 YAML
-	err = yaml.Unmarshal(b, &c)
-	if err != nil {
-		// 如果 YAML 解析失败，尝试解析为 JSON
-		err = json.Unmarshal(b, &c)
-		if err != nil {
-			return false, fmt.Errorf("failed to parse config file: %v", err)
-		}
+	if crypto.IsEnvelope(b) {
+		return true, nil
 	}
 
-	// 检查是否有加密的配置
-	for _, node := range c {
-		if node.IsEncrypted {
-			return true, nil
-		}
+	doc, err := parseConfig(b)
+	if err != nil {
+		return false, err
 	}
-	return false, nil
+	return hasEncrypted(doc.Nodes), nil
 }
 
 // GetMaskedHost 获取脱敏后的host
@@ -0,0 +1,120 @@
+package sshw
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zdev0x/sshw/crypto"
+)
+
+const fileConfig = `
+host_ca_keys:
+  - "@cert-authority *.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+nodes:
+  - name: prod
+    children:
+      - name: web
+        alias: w
+        host: web.example.com
+        password: s3cret
+`
+
+func TestFileEncryptionRoundTrip(t *testing.T) {
+	saveState(t)
+	p := writeConfig(t, fileConfig)
+	if err := LoadConfig(nil, p); err != nil {
+		t.Fatal(err)
+	}
+	want := GetConfig()
+
+	if err := EncryptFile(GetConfig(), []byte("master")); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(GetConfig(), p); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(p)
+	if !crypto.IsEnvelope(b) {
+		t.Fatalf("saved config is not an envelope:\n%s", b)
+	}
+	assertNoPlaintext(t, p, "prod", "web.example.com", "s3cret", "cert-authority")
+
+	// 未解锁时无法读取任何节点
+	if err := LoadConfig(nil, p); err != ErrPasswordRequired {
+		t.Fatalf("load without password: got %v, want %v", err, ErrPasswordRequired)
+	}
+	if err := LoadConfig([]byte("wrong"), p); err == nil {
+		t.Fatal("wrong password unlocked the config")
+	}
+
+	if err := LoadConfig([]byte("master"), p); err != nil {
+		t.Fatal(err)
+	}
+	if !IsFileEncrypted() || !reflect.DeepEqual(GetConfig(), want) || len(hostCAKeys) != 1 {
+		t.Fatalf("round trip: got %+v", GetConfig())
+	}
+	if n, err := Resolve(GetConfig(), "w"); err != nil || n.Path() != "prod/web" {
+		t.Fatalf("parents not linked after load: %v", err)
+	}
+
+	// 修改后再次保存仍然整个文件加密，JSON 文件名也保存为加密容器
+	GetConfig()[0].Children[0].Password = "changed"
+	jp := filepath.Join(t.TempDir(), "sshw.json")
+	if err := SaveConfig(GetConfig(), jp); err != nil {
+		t.Fatal(err)
+	}
+	assertNoPlaintext(t, jp, "changed")
+	if err := LoadConfig([]byte("master"), jp); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetConfig()[0].Children[0].Password; got != "changed" {
+		t.Fatalf("password after second save: %q", got)
+	}
+
+	// 关闭整个文件加密后保存为明文
+	DisableFileEncryption()
+	if err := SaveConfig(GetConfig(), p); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfig(nil, p); err != nil || IsFileEncrypted() {
+		t.Fatalf("decrypted config: %v", err)
+	}
+}
+
+func TestIsConfigEncrypted(t *testing.T) {
+	envelope, err := (&crypto.Envelope{Encryption: &crypto.Header{Key: "k"}, Payload: "cGF5bG9hZA=="}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		config string
+		want   bool
+	}{
+		{"plain", "- name: web\n  host: h\n  password: p\n", false},
+		{"encrypted node", "- name: web\n  password: eA==\n  is_encrypted: true\n", true},
+		{"encrypted child", "- name: prod\n  children:\n  - name: web\n    password: eA==\n    is_encrypted: true\n", true},
+		{"encrypted jump", "- name: web\n  jump:\n  - name: bastion\n    password: eA==\n    is_encrypted: true\n", true},
+		{"fields only", "encryption:\n  fields: [host]\nnodes:\n  - name: web\n", false},
+		{"envelope", string(envelope), true},
+		{"envelope with CRLF", strings.ReplaceAll(string(envelope), "\n", "\r\n"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := IsConfigEncrypted(writeConfig(t, tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("IsConfigEncrypted = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := IsConfigEncrypted(filepath.Join(t.TempDir(), "missing.yml")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
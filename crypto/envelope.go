@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// 整个文件加密时容器的首行标识
+const envelopeMagic = "# sshw-encrypted-config v1"
+
+// Envelope 整个配置文件加密后的容器
+// 只有配置头是明文，节点结构全部在 Payload 中
+type Envelope struct {
+	Encryption *Header `yaml:"encryption"`
+	Payload    string  `yaml:"payload"`
+}
+
+// IsEnvelope 通过首行标识检查是否是整个文件加密的容器
+func IsEnvelope(b []byte) bool {
+	_, ok := envelopeBody(b)
+	return ok
+}
+
+// envelopeBody 返回首行标识之后的内容
+// 允许文件开头的 BOM 和 CRLF 换行，避免被编辑器保存后当作普通配置解析
+func envelopeBody(b []byte) ([]byte, bool) {
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	line, rest := b, []byte(nil)
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		line, rest = b[:i], b[i+1:]
+	}
+	if string(bytes.TrimRight(line, " \t\r")) != envelopeMagic {
+		return nil, false
+	}
+	return rest, true
+}
+
+// ParseEnvelope 解析加密容器
+func ParseEnvelope(b []byte) (*Envelope, error) {
+	body, ok := envelopeBody(b)
+	if !ok {
+		return nil, errors.New("not an encrypted config file")
+	}
+	e := new(Envelope)
+	if err := yaml.Unmarshal(body, e); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted config file: %v", err)
+	}
+	if e.Encryption == nil || e.Payload == "" {
+		return nil, errors.New("encrypted config file is incomplete")
+	}
+	return e, nil
+}
+
+// Marshal 生成带首行标识的容器内容
+func (e *Envelope) Marshal() ([]byte, error) {
+	b, err := yaml.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(envelopeMagic+"\n"), b...), nil
+}
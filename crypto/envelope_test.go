@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	h, _, err := NewHeader([]byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Envelope{Encryption: h, Payload: "cGF5bG9hZA=="}
+	b, err := e.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEnvelope(b) {
+		t.Fatal("marshalled envelope is not detected")
+	}
+
+	got, err := ParseEnvelope(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Payload != e.Payload || got.Encryption.Key != h.Key || got.Encryption.Salt != h.Salt {
+		t.Fatalf("round trip: got %+v", got)
+	}
+
+	// 编辑器保存后可能加上 BOM 或改为 CRLF 换行
+	crlf := "\xef\xbb\xbf" + strings.ReplaceAll(string(b), "\n", "\r\n")
+	if got, err := ParseEnvelope([]byte(crlf)); err != nil || got.Payload != e.Payload {
+		t.Fatalf("envelope with BOM and CRLF: %v", err)
+	}
+}
+
+func TestIsEnvelope(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"magic line", envelopeMagic + "\npayload: x\n", true},
+		{"magic only", envelopeMagic, true},
+		{"trailing spaces", envelopeMagic + "  \r\npayload: x\n", true},
+		{"node list", "- name: web\n", false},
+		{"other comment", "# sshw-encrypted-config v2\npayload: x\n", false},
+		{"magic not first", "- name: web\n" + envelopeMagic + "\n", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsEnvelope([]byte(tt.data)); got != tt.want {
+				t.Fatalf("IsEnvelope(%q) = %v, want %v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvelopeErrors(t *testing.T) {
+	for _, data := range []string{
+		"- name: web\n",
+		envelopeMagic + "\npayload: x\n",
+		envelopeMagic + "\nencryption: {key: k}\n",
+		envelopeMagic + "\n: [\n",
+	} {
+		if _, err := ParseEnvelope([]byte(data)); err == nil {
+			t.Errorf("ParseEnvelope(%q) succeeded", data)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// 配置文件头版本
+	headerVersion = 2
+	kdfPBKDF2     = "pbkdf2-sha256"
+)
+
+var (
+	ErrNoKey      = errors.New("config header has no wrapped key")
+	ErrInvalidKey = errors.New("invalid master password or corrupted key")
+)
+
+// Cipher 字段加解密接口
+type Cipher interface {
+	Encrypt(plaintext []byte) (string, error)
+	Decrypt(encrypted string) ([]byte, error)
+}
+
+// keyCipher 直接使用数据密钥的 AES-256-GCM 加解密器
+type keyCipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher 使用数据密钥创建加解密器，不做密钥派生
+func NewCipher(key []byte) (Cipher, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid data key size: %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &keyCipher{aead: gcm}, nil
+}
+
+func (c *keyCipher) Encrypt(plaintext []byte) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	ciphertext := c.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func (c *keyCipher) Decrypt(encrypted string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < nonceSize+tagSize {
+		return nil, errors.New("encrypted data too short")
+	}
+	return c.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+}
+
+// passwordCipher 兼容旧版本：每个字段单独用 PBKDF2 派生密钥
+type passwordCipher struct {
+	password []byte
+}
+
+// NewPasswordCipher 创建旧格式的加解密器，仅用于读取没有配置头的旧配置
+func NewPasswordCipher(password []byte) Cipher {
+	return &passwordCipher{password: password}
+}
+
+func (c *passwordCipher) Encrypt(plaintext []byte) (string, error) {
+	return Encrypt(plaintext, c.password)
+}
+
+func (c *passwordCipher) Decrypt(encrypted string) ([]byte, error) {
+	return Decrypt(encrypted, c.password)
+}
+
+// Header 保存在配置文件头中的密钥信息
+// 随机生成的数据密钥由主密码派生的密钥包裹，每次运行只需派生一次
+type Header struct {
+	Version    int    `yaml:"version,omitempty" json:"version,omitempty"`
+	KDF        string `yaml:"kdf,omitempty" json:"kdf,omitempty"`
+	Iterations int    `yaml:"iterations,omitempty" json:"iterations,omitempty"`
+	Salt       string `yaml:"salt,omitempty" json:"salt,omitempty"`
+	Key        string `yaml:"key,omitempty" json:"key,omitempty"`
+	// 公钥接收者，各自包裹同一个数据密钥
+	Recipients []*Recipient `yaml:"recipients,omitempty" json:"recipients,omitempty"`
+	// 需要加密的字段，由调用方解释
+	Fields []string `yaml:"fields,omitempty" json:"fields,omitempty"`
+	// 获取主密码的外部命令，整个文件加密时也保持明文
+	PasswordCommand string `yaml:"password_command,omitempty" json:"password_command,omitempty"`
+}
+
+// NewDataKey 生成随机数据密钥
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewHeader 生成新的数据密钥并用主密码包裹
+func NewHeader(password []byte) (*Header, []byte, error) {
+	key, err := NewDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	h := &Header{Version: headerVersion}
+	if err := h.Wrap(key, password); err != nil {
+		return nil, nil, err
+	}
+	return h, key, nil
+}
+
+// Wrap 用主密码（重新）包裹数据密钥
+func (h *Header) Wrap(key, password []byte) error {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	c, err := NewCipher(pbkdf2.Key(password, salt, iterations, keySize, sha256.New))
+	if err != nil {
+		return err
+	}
+	wrapped, err := c.Encrypt(key)
+	if err != nil {
+		return err
+	}
+
+	h.Version = headerVersion
+	h.KDF = kdfPBKDF2
+	h.Iterations = iterations
+	h.Salt = base64.StdEncoding.EncodeToString(salt)
+	h.Key = wrapped
+	return nil
+}
+
+// Unwrap 使用主密码解出数据密钥
+func (h *Header) Unwrap(password []byte) ([]byte, error) {
+	if h.Key == "" {
+		return nil, ErrNoKey
+	}
+	if h.KDF != kdfPBKDF2 {
+		return nil, fmt.Errorf("unsupported kdf: %s", h.KDF)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(h.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt: %v", err)
+	}
+
+	iter := h.Iterations
+	if iter <= 0 {
+		iter = iterations
+	}
+	c, err := NewCipher(pbkdf2.Key(password, salt, iter, keySize, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	key, err := c.Decrypt(h.Key)
+	if err != nil || len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
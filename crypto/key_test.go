@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHeaderWrapUnwrap(t *testing.T) {
+	h, key, err := NewHeader([]byte("master"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != headerVersion || h.KDF != kdfPBKDF2 || h.Salt == "" || h.Key == "" {
+		t.Fatalf("incomplete header: %+v", h)
+	}
+
+	got, err := h.Unwrap([]byte("master"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatal("unwrapped key differs from the generated key")
+	}
+
+	if _, err := h.Unwrap([]byte("wrong")); err != ErrInvalidKey {
+		t.Fatalf("wrong password: got %v, want %v", err, ErrInvalidKey)
+	}
+
+	// 重新包裹后只有新密码可以解出同一个数据密钥
+	if err := h.Wrap(key, []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Unwrap([]byte("master")); err != ErrInvalidKey {
+		t.Fatalf("old password after rewrap: got %v, want %v", err, ErrInvalidKey)
+	}
+	if got, err := h.Unwrap([]byte("changed")); err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("new password after rewrap: %v", err)
+	}
+}
+
+func TestHeaderUnwrapErrors(t *testing.T) {
+	if _, err := new(Header).Unwrap([]byte("pw")); err != ErrNoKey {
+		t.Fatalf("empty header: got %v, want %v", err, ErrNoKey)
+	}
+
+	h, _, err := NewHeader([]byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.KDF = "scrypt"
+	if _, err := h.Unwrap([]byte("pw")); err == nil {
+		t.Fatal("expected an error for an unsupported kdf")
+	}
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	key, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, plain := range []string{"", "s3cret", "密码 with unicode", string(make([]byte, 4096))} {
+		enc, err := c.Encrypt([]byte(plain))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, err := c.Decrypt(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(dec) != plain {
+			t.Fatalf("round trip: got %q, want %q", dec, plain)
+		}
+	}
+
+	// 每次加密使用随机 nonce
+	a, _ := c.Encrypt([]byte("same"))
+	b, _ := c.Encrypt([]byte("same"))
+	if a == b {
+		t.Fatal("encrypting twice produced the same ciphertext")
+	}
+
+	other, _ := NewDataKey()
+	oc, _ := NewCipher(other)
+	if _, err := oc.Decrypt(a); err == nil {
+		t.Fatal("decrypting with another key should fail")
+	}
+	if _, err := c.Decrypt("c2hvcnQ="); err == nil {
+		t.Fatal("decrypting short data should fail")
+	}
+}
+
+func TestNewCipherKeySize(t *testing.T) {
+	if _, err := NewCipher(make([]byte, 16)); err == nil {
+		t.Fatal("expected an error for a 16 byte key")
+	}
+}
+
+func TestPasswordCipherRoundTrip(t *testing.T) {
+	c := NewPasswordCipher([]byte("legacy"))
+	enc, err := c.Encrypt([]byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := c.Decrypt(enc)
+	if err != nil || string(dec) != "s3cret" {
+		t.Fatalf("round trip: %q, %v", dec, err)
+	}
+	if _, err := NewPasswordCipher([]byte("wrong")).Decrypt(enc); err == nil {
+		t.Fatal("decrypting with the wrong password should fail")
+	}
+}
@@ -0,0 +1,328 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// X25519 公钥类型，格式与 authorized_keys 相同：类型 公钥 [注释]
+	x25519KeyType = "sshw-x25519"
+	// X25519 私钥在身份文件中的前缀
+	x25519SecretPrefix = "SSHW-X25519-SECRET-KEY"
+	recipientInfo      = "sshw-recipient-v1"
+)
+
+var (
+	ErrNoIdentity = errors.New("no identity matches any recipient")
+
+	// curve25519 的素数 2^255 - 19
+	curve25519P, _ = new(big.Int).SetString("57896044618658097711785492504343953926634992332820282019728792003956564819949", 10)
+)
+
+// Recipient 可以解出数据密钥的接收者，数据密钥用接收者的公钥单独包裹
+type Recipient struct {
+	Name      string `yaml:"name,omitempty" json:"name,omitempty"`
+	PublicKey string `yaml:"public_key" json:"public_key"`
+	Ephemeral string `yaml:"ephemeral" json:"ephemeral"`
+	Key       string `yaml:"key" json:"key"`
+}
+
+// Identity 接收者的私钥，可以是 sshw 生成的 X25519 密钥或 ssh ed25519 私钥
+type Identity struct {
+	secret []byte
+	public []byte
+}
+
+// GenerateIdentity 生成新的 X25519 身份密钥
+func GenerateIdentity() (*Identity, error) {
+	secret := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return newIdentity(secret)
+}
+
+func newIdentity(secret []byte) (*Identity, error) {
+	public, err := curve25519.X25519(secret, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{secret: secret, public: public}, nil
+}
+
+// ParseIdentity 解析身份文件，ssh 私钥有密码时需要提供 passphrase
+// 缺少密码时返回 *ssh.PassphraseMissingError
+func ParseIdentity(b []byte, passphrase []byte) (*Identity, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, x25519SecretPrefix) {
+			continue
+		}
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(line, x25519SecretPrefix)))
+		if err != nil || len(secret) != curve25519.ScalarSize {
+			return nil, errors.New("invalid x25519 identity")
+		}
+		return newIdentity(secret)
+	}
+
+	var key interface{}
+	var err error
+	if passphrase != nil {
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(b, passphrase)
+	} else {
+		key, err = ssh.ParseRawPrivateKey(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var priv ed25519.PrivateKey
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		priv = k
+	case *ed25519.PrivateKey:
+		priv = *k
+	default:
+		return nil, fmt.Errorf("unsupported identity key type %T, only ed25519 is supported", key)
+	}
+
+	// ed25519 私钥对应的 X25519 标量
+	h := sha512.Sum512(priv.Seed())
+	return newIdentity(h[:curve25519.ScalarSize])
+}
+
+// Recipient 返回身份对应的 X25519 公钥
+func (i *Identity) Recipient() string {
+	return x25519KeyType + " " + base64.StdEncoding.EncodeToString(i.public)
+}
+
+// Marshal 生成身份文件内容
+func (i *Identity) Marshal() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# public key: %s\n", i.Recipient())
+	fmt.Fprintf(&b, "%s %s\n", x25519SecretPrefix, base64.StdEncoding.EncodeToString(i.secret))
+	return b.Bytes()
+}
+
+// Matches 检查身份是否对应给定的公钥
+func (i *Identity) Matches(publicKey string) bool {
+	public, err := ParseRecipient(publicKey)
+	return err == nil && bytes.Equal(public, i.public)
+}
+
+// ParseRecipient 解析接收者公钥，返回对应的 X25519 公钥
+// 支持 sshw-x25519 和 ssh-ed25519（authorized_keys 格式）
+func ParseRecipient(publicKey string) ([]byte, error) {
+	fields := strings.Fields(publicKey)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid public key %q", publicKey)
+	}
+
+	if fields[0] == x25519KeyType {
+		public, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(public) != curve25519.PointSize {
+			return nil, fmt.Errorf("invalid %s public key", x25519KeyType)
+		}
+		return public, nil
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, err
+	}
+	if key.Type() != ssh.KeyAlgoED25519 {
+		return nil, fmt.Errorf("unsupported public key type %s, only ed25519 is supported", key.Type())
+	}
+	return ed25519ToX25519(key.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey))
+}
+
+// ed25519ToX25519 把 ed25519 公钥转换为 X25519 公钥：u = (1 + y) / (1 - y) mod p
+func ed25519ToX25519(pk ed25519.PublicKey) ([]byte, error) {
+	if len(pk) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid ed25519 public key")
+	}
+
+	// y 以小端序存储，最高位是 x 的符号位
+	be := make([]byte, len(pk))
+	for i := range pk {
+		be[len(pk)-1-i] = pk[i]
+	}
+	be[0] &= 0x7f
+	y := new(big.Int).SetBytes(be)
+
+	one := big.NewInt(1)
+	num := new(big.Int).Add(one, y)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, curve25519P)
+	if den.ModInverse(den, curve25519P) == nil {
+		return nil, errors.New("invalid ed25519 public key")
+	}
+	u := num.Mul(num, den)
+	u.Mod(u, curve25519P)
+
+	out := make([]byte, curve25519.PointSize)
+	ub := u.Bytes()
+	for i := range ub {
+		out[i] = ub[len(ub)-1-i]
+	}
+	return out, nil
+}
+
+// recipientCipher 由共享密钥派生包裹密钥
+func recipientCipher(shared, ephemeral, public []byte) (Cipher, error) {
+	salt := append(append([]byte{}, ephemeral...), public...)
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(recipientInfo)), key); err != nil {
+		return nil, err
+	}
+	return NewCipher(key)
+}
+
+// wrap 用临时密钥和接收者公钥协商出包裹密钥，加密数据密钥
+func (r *Recipient) wrap(key []byte) error {
+	public, err := ParseRecipient(r.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	secret := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	ephemeral, err := curve25519.X25519(secret, curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	shared, err := curve25519.X25519(secret, public)
+	if err != nil {
+		return err
+	}
+
+	c, err := recipientCipher(shared, ephemeral, public)
+	if err != nil {
+		return err
+	}
+	wrapped, err := c.Encrypt(key)
+	if err != nil {
+		return err
+	}
+	r.Ephemeral = base64.StdEncoding.EncodeToString(ephemeral)
+	r.Key = wrapped
+	return nil
+}
+
+// unwrap 使用身份私钥解出数据密钥
+func (r *Recipient) unwrap(id *Identity) ([]byte, error) {
+	ephemeral, err := base64.StdEncoding.DecodeString(r.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(id.secret, ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	c, err := recipientCipher(shared, ephemeral, id.public)
+	if err != nil {
+		return nil, err
+	}
+	key, err := c.Decrypt(r.Key)
+	if err != nil || len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// AddRecipient 为新的接收者包裹数据密钥
+func (h *Header) AddRecipient(key []byte, publicKey, name string) error {
+	public, err := ParseRecipient(publicKey)
+	if err != nil {
+		return err
+	}
+	for _, r := range h.Recipients {
+		if existing, err := ParseRecipient(r.PublicKey); err == nil && bytes.Equal(existing, public) {
+			return fmt.Errorf("recipient already exists: %s", r.Name)
+		}
+	}
+
+	// 默认使用公钥注释作为名称
+	if fields := strings.Fields(publicKey); name == "" && len(fields) > 2 {
+		name = fields[2]
+	}
+	r := &Recipient{Name: name, PublicKey: strings.TrimSpace(publicKey)}
+	if err := r.wrap(key); err != nil {
+		return err
+	}
+	h.Version = headerVersion
+	h.Recipients = append(h.Recipients, r)
+	return nil
+}
+
+// RemoveRecipient 按名称或公钥删除接收者，数据密钥需要调用方轮换
+func (h *Header) RemoveRecipient(match string) bool {
+	match = strings.TrimSpace(match)
+	for i, r := range h.Recipients {
+		fields := strings.Fields(r.PublicKey)
+		if r.Name == match || r.PublicKey == match || (len(fields) >= 2 && fields[0]+" "+fields[1] == match) {
+			h.Recipients = append(h.Recipients[:i], h.Recipients[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// WrapRecipients 为所有接收者重新包裹数据密钥（轮换密钥时使用）
+func (h *Header) WrapRecipients(key []byte) error {
+	for _, r := range h.Recipients {
+		if err := r.wrap(key); err != nil {
+			return fmt.Errorf("failed to wrap key for %s: %v", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// UnwrapIdentity 使用身份私钥解出数据密钥
+func (h *Header) UnwrapIdentity(ids []*Identity) ([]byte, error) {
+	for _, r := range h.Recipients {
+		for _, id := range ids {
+			if !id.Matches(r.PublicKey) {
+				continue
+			}
+			return r.unwrap(id)
+		}
+	}
+	return nil, ErrNoIdentity
+}
+
+// HasRecipient 检查是否有接收者使用给定的公钥
+func (h *Header) HasRecipient(public ssh.PublicKey) bool {
+	publicKey := string(ssh.MarshalAuthorizedKey(public))
+	for _, r := range h.Recipients {
+		if a, err := ParseRecipient(r.PublicKey); err == nil {
+			if b, err := ParseRecipient(publicKey); err == nil && bytes.Equal(a, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RemovePassword 删除主密码包裹的数据密钥，只保留接收者
+func (h *Header) RemovePassword() {
+	h.KDF, h.Iterations, h.Salt, h.Key = "", 0, "", ""
+}
@@ -0,0 +1,128 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRecipientRoundTrip(t *testing.T) {
+	key, _ := NewDataKey()
+	alice, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, _ := GenerateIdentity()
+
+	h := new(Header)
+	if err := h.AddRecipient(key, alice.Recipient(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.AddRecipient(key, alice.Recipient(), "again"); err == nil {
+		t.Fatal("adding the same recipient twice should fail")
+	}
+
+	got, err := h.UnwrapIdentity([]*Identity{bob, alice})
+	if err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("unwrap with alice: %v", err)
+	}
+	if _, err := h.UnwrapIdentity([]*Identity{bob}); err != ErrNoIdentity {
+		t.Fatalf("unwrap with bob: got %v, want %v", err, ErrNoIdentity)
+	}
+
+	// 身份文件往返
+	parsed, err := ParseIdentity(alice.Marshal(), nil)
+	if err != nil || parsed.Recipient() != alice.Recipient() {
+		t.Fatalf("parse identity: %v", err)
+	}
+
+	// 轮换密钥后旧的包裹失效
+	newKey, _ := NewDataKey()
+	if err := h.WrapRecipients(newKey); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := h.UnwrapIdentity([]*Identity{alice}); !bytes.Equal(got, newKey) {
+		t.Fatal("rotated key not unwrapped")
+	}
+
+	if !h.RemoveRecipient("alice") || len(h.Recipients) != 0 {
+		t.Fatal("recipient not removed by name")
+	}
+	if h.RemoveRecipient("alice") {
+		t.Fatal("removing a missing recipient should report false")
+	}
+}
+
+func TestSSHRecipient(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, _ := ssh.NewPublicKey(pub)
+	authorized := string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(sshPub))) + " carol@laptop"
+
+	key, _ := NewDataKey()
+	h := new(Header)
+	if err := h.AddRecipient(key, authorized, ""); err != nil {
+		t.Fatal(err)
+	}
+	if h.Recipients[0].Name != "carol@laptop" {
+		t.Fatalf("name from comment: got %q", h.Recipients[0].Name)
+	}
+	if !h.HasRecipient(sshPub) {
+		t.Fatal("HasRecipient does not match the ssh key")
+	}
+
+	id, err := ParseIdentity(pem.EncodeToMemory(block), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := h.UnwrapIdentity([]*Identity{id})
+	if err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("unwrap with ssh key: %v", err)
+	}
+}
+
+func TestParseRecipientErrors(t *testing.T) {
+	for _, key := range []string{
+		"",
+		"sshw-x25519",
+		"sshw-x25519 not-base64!",
+		"sshw-x25519 c2hvcnQ=",
+		"ssh-ed25519 AAAA",
+		"ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBEmKSENjQEezOmxkZMy7opKgwFB9nkt5YRrYMjNuG5N87uRgg6CLrbo5wAdT/y6v0mKV0U2w0WZ2YB/++Tpockg=",
+	} {
+		if _, err := ParseRecipient(key); err == nil {
+			t.Errorf("ParseRecipient(%q) succeeded", key)
+		}
+	}
+}
+
+func TestParseIdentityPassphrase(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := pem.EncodeToMemory(block)
+
+	if _, err := ParseIdentity(b, nil); err == nil {
+		t.Fatal("encrypted key parsed without a passphrase")
+	} else if _, ok := err.(*ssh.PassphraseMissingError); !ok {
+		t.Fatalf("missing passphrase: got %T %v", err, err)
+	}
+	if _, err := ParseIdentity(b, []byte("wrong")); err == nil {
+		t.Fatal("encrypted key parsed with the wrong passphrase")
+	}
+	if _, err := ParseIdentity(b, []byte("pass")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,344 @@
+package sshw
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zdev0x/sshw/crypto"
+	"gopkg.in/yaml.v2"
+)
+
+// WholeNode 表示加密整个节点（子节点和跳板机分别加密）
+const WholeNode = "node"
+
+var (
+	// 默认只加密密码、密钥密码、TOTP 密钥和 keyboard-interactive 的预设回答
+	defaultEncryptedFields = []string{"password", "passphrase", "totp_secret", "keyboard_interactive"}
+
+	// encryptableFields 可加密的字段，返回节点中对应字段的指针
+	encryptableFields = map[string]func(n *Node) []*string{
+		"name":  func(n *Node) []*string { return []*string{&n.Name} },
+		"alias": func(n *Node) []*string { return []*string{&n.Alias} },
+		"host":  func(n *Node) []*string { return []*string{&n.Host} },
+		"user":  func(n *Node) []*string { return []*string{&n.User} },
+		"keypath": func(n *Node) []*string {
+			fields := []*string{&n.KeyPath}
+			for _, k := range n.KeyPaths {
+				fields = append(fields, &k.Path)
+			}
+			return fields
+		},
+		"certpath": func(n *Node) []*string {
+			fields := []*string{&n.CertPath}
+			for _, k := range n.KeyPaths {
+				fields = append(fields, &k.CertPath)
+			}
+			return fields
+		},
+		"password": func(n *Node) []*string { return []*string{&n.Password} },
+		"passphrase": func(n *Node) []*string {
+			fields := []*string{&n.Passphrase}
+			for _, k := range n.KeyPaths {
+				fields = append(fields, &k.Passphrase)
+			}
+			return fields
+		},
+		"totp_secret": func(n *Node) []*string { return []*string{&n.TOTPSecret} },
+		"keyboard_interactive": func(n *Node) []*string {
+			var fields []*string
+			for _, a := range n.KeyboardInteractive {
+				fields = append(fields, &a.Answer)
+			}
+			return fields
+		},
+		"callback-shells": func(n *Node) []*string {
+			var fields []*string
+			for _, shell := range n.CallbackShells {
+				fields = append(fields, &shell.Cmd)
+			}
+			return fields
+		},
+	}
+)
+
+// EncryptableFields 返回所有可加密的字段名
+func EncryptableFields() []string {
+	names := make([]string, 0, len(encryptableFields)+1)
+	for name := range encryptableFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append(names, WholeNode)
+}
+
+// SetEncryptedFields 设置加密时使用的字段，已有加密节点时不能修改
+func SetEncryptedFields(fields []string) error {
+	for _, field := range fields {
+		if _, ok := encryptableFields[field]; !ok && field != WholeNode {
+			return fmt.Errorf("unknown field %q, available: %s", field, strings.Join(EncryptableFields(), ", "))
+		}
+	}
+	if hasEncrypted(config) {
+		return fmt.Errorf("config is encrypted, decrypt it before changing encrypted fields")
+	}
+	if header == nil {
+		header = new(crypto.Header)
+	}
+	header.Fields = fields
+	return nil
+}
+
+// encryptedFields 返回当前配置需要加密的字段
+func encryptedFields() []string {
+	if header == nil || len(header.Fields) == 0 {
+		return defaultEncryptedFields
+	}
+	return header.Fields
+}
+
+// isWholeNode 检查是否加密整个节点
+func isWholeNode(fields []string) bool {
+	for _, field := range fields {
+		if field == WholeNode {
+			return true
+		}
+	}
+	return false
+}
+
+// DecryptFields 解密加密的字段
+func (n *Node) DecryptFields(c crypto.Cipher) error {
+	if !n.IsEncrypted {
+		return nil
+	}
+
+	if n.Sealed != "" {
+		if err := n.unseal(c); err != nil {
+			return err
+		}
+	} else {
+		for _, name := range encryptedFields() {
+			get, ok := encryptableFields[name]
+			if !ok {
+				continue
+			}
+			for _, field := range get(n) {
+				if *field == "" {
+					continue
+				}
+				decrypted, err := c.Decrypt(*field)
+				if err != nil {
+					return fmt.Errorf("failed to decrypt %s: %v", name, err)
+				}
+				*field = string(decrypted)
+			}
+		}
+	}
+
+	n.IsEncrypted = false
+
+	// 递归处理子节点
+	for _, child := range n.Children {
+		if err := child.DecryptFields(c); err != nil {
+			return err
+		}
+	}
+
+	// 递归处理跳转节点
+	for _, jump := range n.Jump {
+		if err := jump.DecryptFields(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EncryptFields 加密敏感字段
+func (n *Node) EncryptFields(c crypto.Cipher) error {
+	if n.IsEncrypted {
+		return nil
+	}
+
+	fields := encryptedFields()
+	if isWholeNode(fields) {
+		if err := n.seal(c); err != nil {
+			return err
+		}
+	} else {
+		for _, name := range fields {
+			get, ok := encryptableFields[name]
+			if !ok {
+				continue
+			}
+			for _, field := range get(n) {
+				if *field == "" {
+					continue
+				}
+				encrypted, err := c.Encrypt([]byte(*field))
+				if err != nil {
+					return fmt.Errorf("failed to encrypt %s: %v", name, err)
+				}
+				*field = encrypted
+			}
+		}
+	}
+
+	n.IsEncrypted = true
+
+	// 递归处理子节点
+	for _, child := range n.Children {
+		if err := child.EncryptFields(c); err != nil {
+			return err
+		}
+	}
+
+	// 递归处理跳转节点
+	for _, jump := range n.Jump {
+		if err := jump.EncryptFields(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// seal 把节点自身的字段整体加密到 Sealed 中
+func (n *Node) seal(c crypto.Cipher) error {
+	plain := *n
+	plain.Children, plain.Jump = nil, nil
+	b, err := yaml.Marshal(&plain)
+	if err != nil {
+		return err
+	}
+	sealed, err := c.Encrypt(b)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt node: %v", err)
+	}
+	*n = Node{Sealed: sealed, Children: n.Children, Jump: n.Jump}
+	return nil
+}
+
+// unseal 从 Sealed 中还原节点自身的字段
+func (n *Node) unseal(c crypto.Cipher) error {
+	b, err := c.Decrypt(n.Sealed)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt node: %v", err)
+	}
+	var plain Node
+	if err := yaml.Unmarshal(b, &plain); err != nil {
+		return fmt.Errorf("failed to decode node: %v", err)
+	}
+	plain.Children, plain.Jump = n.Children, n.Jump
+	*n = plain
+	return nil
+}
+
+// EncryptNodes 使用数据密钥加密节点的敏感字段
+func EncryptNodes(nodes []*Node, password []byte) error {
+	if err := ensureDataKey(password); err != nil {
+		return err
+	}
+
+	c, err := crypto.NewCipher(dataKey)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := node.EncryptFields(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptConfig 使用当前数据密钥加密已解锁的配置，保存时配置头才会和密文一起写入
+// 整个文件加密时保存会使用当前密钥加密整个文件
+func encryptConfig() error {
+	if fileMode {
+		return nil
+	}
+	c, err := crypto.NewCipher(dataKey)
+	if err != nil {
+		return err
+	}
+	for _, node := range config {
+		if err := node.EncryptFields(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncryptFile 切换为整个文件加密，保存时节点结构也不会以明文写入
+// 节点需要已经解密
+func EncryptFile(nodes []*Node, password []byte) error {
+	if hasEncrypted(nodes) {
+		return fmt.Errorf("config has encrypted fields, decrypt it first")
+	}
+	if err := ensureDataKey(password); err != nil {
+		return err
+	}
+	fileMode = true
+	return nil
+}
+
+// DisableFileEncryption 关闭整个文件加密，之后保存为明文
+func DisableFileEncryption() {
+	fileMode = false
+}
+
+// IsFileEncrypted 当前加载的配置是否整个文件加密
+func IsFileEncrypted() bool {
+	return fileMode
+}
+
+// ensureDataKey 准备数据密钥
+// 首次加密时生成随机数据密钥，并用主密码包裹后写入配置头
+func ensureDataKey(password []byte) error {
+	if dataKey != nil {
+		return nil
+	}
+
+	var err error
+	if header != nil && header.Key != "" {
+		dataKey, err = header.Unwrap(password)
+		return err
+	}
+
+	old := header
+	header, dataKey, err = crypto.NewHeader(password)
+	if err != nil {
+		header = old
+		return err
+	}
+	if old != nil {
+		header.Fields = old.Fields
+		header.PasswordCommand = old.PasswordCommand
+	}
+	return nil
+}
+
+// hasEncrypted 检查节点树中是否有加密的节点
+func hasEncrypted(nodes []*Node) bool {
+	for _, node := range nodes {
+		if node.IsEncrypted || hasEncrypted(node.Children) || hasEncrypted(node.Jump) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rekey 使用新的主密码保护已解锁的配置
+// 有配置头时重新包裹数据密钥，旧格式的配置会重新加密为新格式
+// 节点需要已经解密，保存前会用数据密钥重新加密
+func Rekey(password []byte) error {
+	if dataKey == nil {
+		return EncryptNodes(config, password)
+	}
+	if err := header.Wrap(dataKey, password); err != nil {
+		return err
+	}
+	return encryptConfig()
+}
@@ -0,0 +1,325 @@
+package sshw
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zdev0x/sshw/crypto"
+)
+
+// saveState 保存包级的配置状态，测试结束后还原
+func saveState(t testing.TB) {
+	t.Helper()
+	c, h, k, f, lk := config, header, dataKey, fileMode, legacyKey
+	vc, vcl, ca, rk, ui, cs := vaultConfig, vaultClient, hostCAKeys, revokedHostKeys, uiConfig, defaultCertSigner
+	t.Cleanup(func() {
+		config, header, dataKey, fileMode, legacyKey = c, h, k, f, lk
+		vaultConfig, vaultClient, hostCAKeys, revokedHostKeys, uiConfig, defaultCertSigner = vc, vcl, ca, rk, ui, cs
+	})
+	config, header, dataKey, fileMode, legacyKey = nil, nil, nil, false, false
+	vaultConfig, vaultClient, uiConfig, defaultCertSigner = nil, nil, nil, vaultCertSigner{}
+}
+
+// writeConfig 在临时目录中写入配置文件并返回路径
+func writeConfig(t testing.TB, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "sshw.yml")
+	if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// assertNoPlaintext 检查保存的配置文件中没有明文的敏感内容
+func assertNoPlaintext(t *testing.T, p string, secrets ...string) {
+	t.Helper()
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range secrets {
+		if strings.Contains(string(b), s) {
+			t.Fatalf("%q saved in plaintext:\n%s", s, b)
+		}
+	}
+}
+
+func testCipher(t *testing.T) crypto.Cipher {
+	t.Helper()
+	key, err := crypto.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := crypto.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func testTree() *Node {
+	return &Node{
+		Name:       "prod",
+		Host:       "10.0.0.1",
+		User:       "admin",
+		Password:   "s3cret",
+		Passphrase: "keypass",
+		TOTPSecret: "JBSWY3DPEHPK3PXP",
+		KeyPaths:   []*KeyFile{{Path: "~/.ssh/id_ed25519", Passphrase: "other"}},
+		KeyboardInteractive: []*PromptAnswer{
+			{PromptRegex: "PIN", Answer: "1234"},
+		},
+		Children: []*Node{
+			{Name: "web", Host: "10.0.0.2", Password: "child"},
+		},
+		Jump: []*Node{
+			{Name: "bastion", Host: "10.0.0.3", Password: "jump"},
+		},
+	}
+}
+
+func TestEncryptFieldsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		fields    []string
+		encrypted func(n *Node) []string
+		plain     func(n *Node) []string
+	}{
+		{
+			name:   "default fields",
+			fields: nil,
+			encrypted: func(n *Node) []string {
+				return []string{n.Password, n.Passphrase, n.TOTPSecret, n.KeyPaths[0].Passphrase, n.KeyboardInteractive[0].Answer, n.Children[0].Password, n.Jump[0].Password}
+			},
+			plain: func(n *Node) []string { return []string{n.Name, n.Host, n.User} },
+		},
+		{
+			name:      "custom fields",
+			fields:    []string{"host", "user"},
+			encrypted: func(n *Node) []string { return []string{n.Host, n.User, n.Children[0].Host, n.Jump[0].Host} },
+			plain:     func(n *Node) []string { return []string{n.Name, n.Password} },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saveState(t)
+			if tt.fields != nil {
+				header = &crypto.Header{Fields: tt.fields}
+			}
+			c := testCipher(t)
+			want, n := testTree(), testTree()
+
+			if err := n.EncryptFields(c); err != nil {
+				t.Fatal(err)
+			}
+			if !n.IsEncrypted || !n.Children[0].IsEncrypted || !n.Jump[0].IsEncrypted {
+				t.Fatal("nodes not marked as encrypted")
+			}
+			before, after := tt.encrypted(want), tt.encrypted(n)
+			for i := range before {
+				if before[i] == after[i] {
+					t.Errorf("field %d left in plaintext: %q", i, after[i])
+				}
+			}
+			if !reflect.DeepEqual(tt.plain(want), tt.plain(n)) {
+				t.Errorf("unselected fields changed: %v", tt.plain(n))
+			}
+
+			// 重复加密不应再次加密
+			snapshot := n.Password
+			if err := n.EncryptFields(c); err != nil || n.Password != snapshot {
+				t.Fatal("encrypting an encrypted node changed it")
+			}
+
+			if err := n.DecryptFields(c); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(n, want) {
+				t.Fatalf("round trip:\n got %+v\nwant %+v", n, want)
+			}
+		})
+	}
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	saveState(t)
+	header = &crypto.Header{Fields: []string{WholeNode}}
+	c := testCipher(t)
+	want, n := testTree(), testTree()
+
+	if err := n.EncryptFields(c); err != nil {
+		t.Fatal(err)
+	}
+	if n.Sealed == "" || n.Name != "" || n.Host != "" || n.Password != "" {
+		t.Fatalf("node fields left in plaintext: %+v", n)
+	}
+	if n.Children[0].Sealed == "" || n.Jump[0].Sealed == "" {
+		t.Fatal("children and jump hosts are not sealed separately")
+	}
+
+	if err := n.DecryptFields(c); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(n, want) {
+		t.Fatalf("round trip:\n got %+v\nwant %+v", n, want)
+	}
+}
+
+func TestDecryptFieldsWrongKey(t *testing.T) {
+	saveState(t)
+	n := testTree()
+	if err := n.EncryptFields(testCipher(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.DecryptFields(testCipher(t)); err == nil {
+		t.Fatal("decrypting with another key should fail")
+	}
+}
+
+func TestSetEncryptedFields(t *testing.T) {
+	saveState(t)
+	if err := SetEncryptedFields([]string{"password", "bogus"}); err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+	if err := SetEncryptedFields([]string{"host", WholeNode}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(encryptedFields(), []string{"host", WholeNode}) {
+		t.Fatalf("fields not set: %v", encryptedFields())
+	}
+
+	config = []*Node{{Name: "a", IsEncrypted: true}}
+	if err := SetEncryptedFields([]string{"host"}); err == nil {
+		t.Fatal("changing fields of an encrypted config should fail")
+	}
+}
+
+func TestRekey(t *testing.T) {
+	saveState(t)
+	p := encryptedConfig(t, "old")
+
+	if err := LoadConfig([]byte("old"), p); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rekey([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(GetConfig(), p); err != nil {
+		t.Fatal(err)
+	}
+	assertNoPlaintext(t, p, "s3cret")
+
+	if err := LoadConfig([]byte("old"), p); err == nil {
+		t.Fatal("old password still unlocks the config")
+	}
+	if err := LoadConfig([]byte("new"), p); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetConfig()[0].Password; got != "s3cret" {
+		t.Fatalf("password after rekey: %q", got)
+	}
+}
+
+func TestRekeyLegacy(t *testing.T) {
+	saveState(t)
+	p := writeConfig(t, recipientConfig)
+	if err := LoadConfig(nil, p); err != nil {
+		t.Fatal(err)
+	}
+	// 旧格式每个字段用密码单独派生密钥，没有配置头
+	for _, node := range GetConfig() {
+		if err := node.EncryptFields(crypto.NewPasswordCipher([]byte("legacy"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SaveConfig(GetConfig(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadConfig([]byte("legacy"), p); err != nil {
+		t.Fatal(err)
+	}
+	if !IsLegacyEncrypted() {
+		t.Fatal("legacy config not reported")
+	}
+	if err := Rekey([]byte("master")); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(GetConfig(), p); err != nil {
+		t.Fatal(err)
+	}
+	assertNoPlaintext(t, p, "s3cret")
+
+	if err := LoadConfig([]byte("master"), p); err != nil {
+		t.Fatal(err)
+	}
+	if header == nil || header.Key == "" || IsLegacyEncrypted() {
+		t.Fatal("legacy config not converted to a data key")
+	}
+	if got := GetConfig()[0].Password; got != "s3cret" {
+		t.Fatalf("password after rekey: %q", got)
+	}
+}
+
+// benchNodes 模拟节点很多的配置
+const benchNodes = 200
+
+// benchConfig 生成 benchNodes 个节点的配置，用 encrypt 加密后写入文件
+func benchConfig(b *testing.B, encrypt func(nodes []*Node) error) string {
+	b.Helper()
+	nodes := make([]*Node, benchNodes)
+	for i := range nodes {
+		nodes[i] = &Node{
+			Name:     fmt.Sprintf("node-%d", i),
+			Host:     fmt.Sprintf("10.0.%d.%d", i/256, i%256),
+			Password: fmt.Sprintf("secret-%d", i),
+		}
+	}
+	config = nodes
+	if err := encrypt(nodes); err != nil {
+		b.Fatal(err)
+	}
+	p := writeConfig(b, "")
+	if err := SaveConfig(nodes, p); err != nil {
+		b.Fatal(err)
+	}
+	return p
+}
+
+// BenchmarkLoadConfig 比较加载节点很多的加密配置：旧格式每个字段都要派生密钥，新格式只解包一次数据密钥
+func BenchmarkLoadConfig(b *testing.B) {
+	password := []byte("master")
+	formats := []struct {
+		name    string
+		encrypt func(nodes []*Node) error
+	}{
+		{"legacy", func(nodes []*Node) error {
+			c := crypto.NewPasswordCipher(password)
+			for _, n := range nodes {
+				if err := n.EncryptFields(c); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"data-key", func(nodes []*Node) error { return EncryptNodes(nodes, password) }},
+	}
+
+	for _, f := range formats {
+		b.Run(f.name, func(b *testing.B) {
+			saveState(b)
+			p := benchConfig(b, f.encrypt)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := LoadConfig(password, p); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package sshw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zdev0x/sshw/crypto"
+)
+
+const (
+	// 最多保留的登录记录数
+	maxHistory = 1000
+	// 加密的历史文件前缀，后面是用配置数据密钥加密的 JSON
+	historyEncryptedPrefix = "sshw-encrypted-history:"
+	// 等待历史文件锁的时间，超过 historyLockStale 的锁视为残留
+	historyLockTimeout = 3 * time.Second
+	historyLockStale   = 30 * time.Second
+)
+
+// ErrHistoryDisabled 旧格式加密的配置没有数据密钥，不记录历史，避免以明文保存节点信息
+var ErrHistoryDisabled = errors.New("history is disabled for legacy encrypted config, run 'sshw -decrypt' and 'sshw -encrypt' to migrate")
+
+// HistoryEntry 一次成功登录的记录，Key 为节点别名，没有别名时为节点路径
+type HistoryEntry struct {
+	Key      string        `json:"key"`
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+}
+
+// History 登录历史和收藏的节点，保存在 ~/.sshw-history
+type History struct {
+	Favourites []string        `json:"favourites,omitempty"`
+	Entries    []*HistoryEntry `json:"entries,omitempty"`
+}
+
+// nodeKey 节点在历史中的标识
+func nodeKey(n *Node) string {
+	if n.Alias != "" {
+		return n.Alias
+	}
+	return n.Path()
+}
+
+func historyPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".sshw-history"), nil
+}
+
+// historyCipher 配置加密时返回加密历史文件使用的加密器，配置未加密时返回 nil
+// 历史和配置使用同一个数据密钥，修改主密码后仍然可以读取
+func historyCipher() (crypto.Cipher, error) {
+	if dataKey != nil {
+		return crypto.NewCipher(dataKey)
+	}
+	if fileMode || legacyKey || hasEncrypted(config) || (header != nil && (header.Key != "" || len(header.Recipients) > 0)) {
+		return nil, ErrHistoryDisabled
+	}
+	return nil, nil
+}
+
+// LoadHistory 读取登录历史，文件不存在或历史被禁用时返回空记录
+// 配置已加密时历史文件也是加密的，需要先解锁配置
+func LoadHistory() (*History, error) {
+	h := new(History)
+	p, err := historyPath()
+	if err != nil {
+		return h, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+
+	if data := strings.TrimSpace(string(b)); strings.HasPrefix(data, historyEncryptedPrefix) {
+		c, err := historyCipher()
+		if err == ErrHistoryDisabled {
+			return h, nil
+		}
+		if err != nil {
+			return h, err
+		}
+		if c == nil {
+			return h, fmt.Errorf("history file %s is encrypted but the config is not, remove it to start a new history", p)
+		}
+		b, err = c.Decrypt(strings.TrimPrefix(data, historyEncryptedPrefix))
+		if err != nil {
+			return h, fmt.Errorf("failed to decrypt history file %s: %v", p, err)
+		}
+	}
+
+	if err := json.Unmarshal(b, h); err != nil {
+		return h, fmt.Errorf("invalid history file %s: %v", p, err)
+	}
+	return h, nil
+}
+
+// Save 写回登录历史，先写入临时文件再替换，配置加密时历史也加密保存
+func (h *History) Save() error {
+	c, err := historyCipher()
+	if err != nil {
+		return err
+	}
+	p, err := historyPath()
+	if err != nil {
+		return err
+	}
+	if len(h.Entries) > maxHistory {
+		h.Entries = h.Entries[len(h.Entries)-maxHistory:]
+	}
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		data, err := c.Encrypt(b)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt history: %v", err)
+		}
+		b = []byte(historyEncryptedPrefix + data + "\n")
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(p), ".sshw-history-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// lockHistory 创建锁文件，防止多个 sshw 进程同时读写历史时丢失记录
+// 返回释放锁的函数
+func lockHistory() (func(), error) {
+	p, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+	lock := p + ".lock"
+	deadline := time.Now().Add(historyLockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		// 进程异常退出残留的锁
+		if fi, err := os.Stat(lock); err == nil && time.Since(fi.ModTime()) > historyLockStale {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("history file is locked, remove %s if no other sshw is running", lock)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// UpdateHistory 在文件锁内读取历史、调用 update 修改后写回
+func UpdateHistory(update func(h *History) error) error {
+	unlock, err := lockHistory()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	h, err := LoadHistory()
+	if err != nil {
+		return err
+	}
+	if err := update(h); err != nil {
+		return err
+	}
+	return h.Save()
+}
+
+// recordLogin 记录一次成功登录，失败时只打印日志
+func recordLogin(n *Node, start time.Time) {
+	if _, err := historyCipher(); err == ErrHistoryDisabled {
+		return
+	}
+	err := UpdateHistory(func(h *History) error {
+		h.Entries = append(h.Entries, &HistoryEntry{
+			Key:      nodeKey(n),
+			Time:     start,
+			Duration: time.Since(start).Round(time.Second),
+		})
+		return nil
+	})
+	if err != nil {
+		l.Error("Failed to save history:", err)
+	}
+}
+
+// frecencyWeight 按登录时间远近给出权重，越近越高
+func frecencyWeight(age time.Duration) int {
+	switch {
+	case age < 4*time.Hour:
+		return 100
+	case age < 24*time.Hour:
+		return 80
+	case age < 7*24*time.Hour:
+		return 60
+	case age < 30*24*time.Hour:
+		return 40
+	case age < 90*24*time.Hour:
+		return 20
+	}
+	return 10
+}
+
+// frecency 按登录次数和时间计算每个节点的得分
+func (h *History) frecency(now time.Time) map[string]int {
+	scores := make(map[string]int)
+	for _, e := range h.Entries {
+		scores[e.Key] += frecencyWeight(now.Sub(e.Time))
+	}
+	return scores
+}
+
+// resolve 把历史中的标识转换为节点，按 frecency 从高到低排序，忽略配置中已不存在的节点
+func (h *History) resolve(nodes []*Node, keys []string) []*Node {
+	scores := h.frecency(time.Now())
+	var found []*Node
+	seen := make(map[*Node]bool)
+	seenKey := make(map[string]bool)
+	for _, key := range keys {
+		if seenKey[key] {
+			continue
+		}
+		seenKey[key] = true
+		n := FindNode(nodes, key)
+		if n == nil || seen[n] {
+			continue
+		}
+		seen[n] = true
+		found = append(found, n)
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return scores[nodeKey(found[i])] > scores[nodeKey(found[j])]
+	})
+	return found
+}
+
+// Recent 返回最近登录过的节点，按 frecency 排序，最多 limit 个
+func (h *History) Recent(nodes []*Node, limit int) []*Node {
+	// 从最近的记录开始，得分相同时最近登录的排在前面
+	var keys []string
+	for i := len(h.Entries) - 1; i >= 0; i-- {
+		keys = append(keys, h.Entries[i].Key)
+	}
+	recent := h.resolve(nodes, keys)
+	if len(recent) > limit {
+		recent = recent[:limit]
+	}
+	return recent
+}
+
+// FavouriteNodes 返回收藏的节点，按 frecency 排序
+func (h *History) FavouriteNodes(nodes []*Node) []*Node {
+	return h.resolve(nodes, h.Favourites)
+}
+
+// Last 返回最后一次登录的节点
+func (h *History) Last(nodes []*Node) *Node {
+	for i := len(h.Entries) - 1; i >= 0; i-- {
+		if n := FindNode(nodes, h.Entries[i].Key); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// LastLogin 返回节点最近一次登录的记录，没有时返回 nil
+func (h *History) LastLogin(n *Node) *HistoryEntry {
+	key := nodeKey(n)
+	for i := len(h.Entries) - 1; i >= 0; i-- {
+		if h.Entries[i].Key == key {
+			return h.Entries[i]
+		}
+	}
+	return nil
+}
+
+// AddFavourite 收藏节点
+func (h *History) AddFavourite(n *Node) bool {
+	key := nodeKey(n)
+	for _, f := range h.Favourites {
+		if f == key {
+			return false
+		}
+	}
+	h.Favourites = append(h.Favourites, key)
+	return true
+}
+
+// RemoveFavourite 取消收藏，key 可以是别名或路径，节点已从配置中删除时按原标识匹配
+func (h *History) RemoveFavourite(nodes []*Node, key string) bool {
+	n := FindNode(nodes, key)
+	for i, f := range h.Favourites {
+		if f == key || (n != nil && FindNode(nodes, f) == n) {
+			h.Favourites = append(h.Favourites[:i], h.Favourites[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// FindNode 按别名或路径查找可以连接的节点
+func FindNode(nodes []*Node, key string) *Node {
+	leaves := Leaves(nodes)
+	for _, n := range leaves {
+		if n.Alias == key {
+			return n
+		}
+	}
+	for _, n := range leaves {
+		if n.Path() == key {
+			return n
+		}
+	}
+	return nil
+}
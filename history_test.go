@@ -0,0 +1,279 @@
+package sshw
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zdev0x/sshw/crypto"
+)
+
+// historyHome 使用临时目录作为主目录，返回历史文件路径
+func historyHome(t *testing.T) string {
+	t.Helper()
+	saveState(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	return filepath.Join(home, ".sshw-history")
+}
+
+func nodePaths(nodes []*Node) []string {
+	var paths []string
+	for _, n := range nodes {
+		paths = append(paths, n.Path())
+	}
+	return paths
+}
+
+func TestLoadHistory(t *testing.T) {
+	p := historyHome(t)
+
+	h, err := LoadHistory()
+	if err != nil || len(h.Entries) != 0 || len(h.Favourites) != 0 {
+		t.Fatalf("missing file: %+v, %v", h, err)
+	}
+
+	content := `{
+  "favourites": ["pweb"],
+  "entries": [
+    {"key": "prod/db", "time": "2026-01-02T03:04:05Z", "duration": 90000000000}
+  ]
+}`
+	if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h, err = LoadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &HistoryEntry{Key: "prod/db", Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Duration: 90 * time.Second}
+	if !reflect.DeepEqual(h.Favourites, []string{"pweb"}) || len(h.Entries) != 1 || !h.Entries[0].Time.Equal(want.Time) ||
+		h.Entries[0].Key != want.Key || h.Entries[0].Duration != want.Duration {
+		t.Fatalf("parsed history: %+v %+v", h, h.Entries[0])
+	}
+
+	ioutil.WriteFile(p, []byte("{not json"), 0600)
+	if _, err := LoadHistory(); err == nil || !strings.Contains(err.Error(), "invalid history file") {
+		t.Fatalf("invalid file: got %v", err)
+	}
+}
+
+func TestRecordLogin(t *testing.T) {
+	historyHome(t)
+	nodes := searchTree()
+	web, db := nodes[0].Children[0], nodes[0].Children[1]
+
+	recordLogin(web, time.Now().Add(-time.Minute))
+	recordLogin(db, time.Now())
+
+	h, err := LoadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 有别名时使用别名，否则使用路径
+	if len(h.Entries) != 2 || h.Entries[0].Key != "pweb" || h.Entries[1].Key != "prod/db" {
+		t.Fatalf("entries: %+v", h.Entries)
+	}
+	if d := h.Entries[0].Duration; d < time.Minute || d > 2*time.Minute {
+		t.Fatalf("duration: %v", d)
+	}
+}
+
+func TestRecordLoginConcurrent(t *testing.T) {
+	p := historyHome(t)
+	nodes := searchTree()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recordLogin(nodes[0].Children[0], time.Now())
+		}()
+	}
+	wg.Wait()
+
+	h, err := LoadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Entries) != 20 {
+		t.Fatalf("got %d entries, want 20", len(h.Entries))
+	}
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(p), ".sshw-history?*"))
+	if len(files) != 0 {
+		t.Fatalf("left over files: %v", files)
+	}
+}
+
+func TestHistoryStaleLock(t *testing.T) {
+	p := historyHome(t)
+	lock := p + ".lock"
+	if err := ioutil.WriteFile(lock, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * historyLockStale)
+	os.Chtimes(lock, old, old)
+
+	if err := UpdateHistory(func(h *History) error {
+		h.Favourites = append(h.Favourites, "pweb")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Fatalf("lock not released: %v", err)
+	}
+}
+
+func TestHistoryEncrypted(t *testing.T) {
+	p := historyHome(t)
+	nodes := searchTree()
+	// 加密前的明文历史在下次保存时加密
+	ioutil.WriteFile(p, []byte(`{"favourites": ["prod/db"]}`), 0600)
+
+	dataKey, _ = crypto.NewDataKey()
+	recordLogin(nodes[0].Children[0], time.Now())
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), historyEncryptedPrefix) {
+		t.Fatalf("history not encrypted: %s", b)
+	}
+	assertNoPlaintext(t, p, "pweb", "prod/db")
+
+	h, err := LoadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Entries) != 1 || h.Entries[0].Key != "pweb" || !reflect.DeepEqual(h.Favourites, []string{"prod/db"}) {
+		t.Fatalf("history: %+v", h)
+	}
+
+	// 换用其他数据密钥或配置不再加密时无法读取
+	dataKey, _ = crypto.NewDataKey()
+	if _, err := LoadHistory(); err == nil || !strings.Contains(err.Error(), "failed to decrypt history") {
+		t.Fatalf("wrong key: got %v", err)
+	}
+	dataKey = nil
+	if _, err := LoadHistory(); err == nil || !strings.Contains(err.Error(), "config is not") {
+		t.Fatalf("plaintext config: got %v", err)
+	}
+}
+
+func TestHistoryDisabled(t *testing.T) {
+	p := historyHome(t)
+	nodes := searchTree()
+
+	// 旧格式加密的配置没有数据密钥，不写入明文历史
+	legacyKey = true
+	recordLogin(nodes[0].Children[0], time.Now())
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Fatalf("history written for legacy config: %v", err)
+	}
+	if err := new(History).Save(); err != ErrHistoryDisabled {
+		t.Fatalf("save: got %v", err)
+	}
+	if h, err := LoadHistory(); err != nil || len(h.Entries) != 0 {
+		t.Fatalf("load: %+v, %v", h, err)
+	}
+}
+
+func TestHistorySaveLimit(t *testing.T) {
+	historyHome(t)
+	h := new(History)
+	for i := 0; i < maxHistory+10; i++ {
+		h.Entries = append(h.Entries, &HistoryEntry{Key: "k", Time: time.Unix(int64(i), 0)})
+	}
+	if err := h.Save(); err != nil {
+		t.Fatal(err)
+	}
+	h, _ = LoadHistory()
+	if len(h.Entries) != maxHistory || h.Entries[0].Time.Unix() != 10 {
+		t.Fatalf("kept %d entries starting at %d", len(h.Entries), h.Entries[0].Time.Unix())
+	}
+}
+
+func TestRecent(t *testing.T) {
+	nodes := searchTree()
+	now := time.Now()
+	h := &History{Entries: []*HistoryEntry{
+		// 很久以前多次登录
+		{Key: "Bastion", Time: now.Add(-200 * 24 * time.Hour)},
+		{Key: "Bastion", Time: now.Add(-200 * 24 * time.Hour)},
+		{Key: "prod/db", Time: now.Add(-2 * 24 * time.Hour)},
+		{Key: "removed/host", Time: now.Add(-time.Hour)},
+		{Key: "dev/web-1", Time: now.Add(-time.Hour)},
+		// 路径和别名指向同一个节点
+		{Key: "prod/web-1", Time: now.Add(-30 * 24 * time.Hour)},
+		{Key: "pweb", Time: now.Add(-time.Hour)},
+	}}
+
+	want := []string{"prod/web-1", "dev/web-1", "prod/db", "Bastion"}
+	if got := nodePaths(h.Recent(nodes, 10)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("recent: got %v, want %v", got, want)
+	}
+	if got := nodePaths(h.Recent(nodes, 2)); !reflect.DeepEqual(got, want[:2]) {
+		t.Fatalf("recent with limit: got %v", got)
+	}
+
+	if got := h.Last(nodes); got == nil || got.Path() != "prod/web-1" {
+		t.Fatalf("last: %v", got)
+	}
+	h.Entries = append(h.Entries, &HistoryEntry{Key: "removed/host", Time: now})
+	if got := h.Last(nodes); got == nil || got.Path() != "prod/web-1" {
+		t.Fatalf("last skips removed nodes: %v", got)
+	}
+	if e := h.LastLogin(nodes[0].Children[1]); e == nil || e.Key != "prod/db" {
+		t.Fatalf("last login: %+v", e)
+	}
+	if e := h.LastLogin(nodes[1].Children[0]); e == nil || !e.Time.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("last login: %+v", e)
+	}
+}
+
+func TestFrecencyWeight(t *testing.T) {
+	ages := []time.Duration{time.Minute, 5 * time.Hour, 2 * 24 * time.Hour, 10 * 24 * time.Hour, 60 * 24 * time.Hour, 365 * 24 * time.Hour}
+	for i := 1; i < len(ages); i++ {
+		if frecencyWeight(ages[i-1]) <= frecencyWeight(ages[i]) {
+			t.Errorf("weight of %v should be higher than %v", ages[i-1], ages[i])
+		}
+	}
+}
+
+func TestFavourites(t *testing.T) {
+	nodes := searchTree()
+	web, db := nodes[0].Children[0], nodes[0].Children[1]
+	h := &History{
+		Favourites: []string{"removed/host"},
+		Entries:    []*HistoryEntry{{Key: "prod/db", Time: time.Now()}},
+	}
+
+	if !h.AddFavourite(web) || !h.AddFavourite(db) || h.AddFavourite(web) {
+		t.Fatal("favourite added twice or not at all")
+	}
+	if !reflect.DeepEqual(h.Favourites, []string{"removed/host", "pweb", "prod/db"}) {
+		t.Fatalf("favourites: %v", h.Favourites)
+	}
+	// 登录过的排在前面，已删除的节点不显示
+	if got := nodePaths(h.FavouriteNodes(nodes)); !reflect.DeepEqual(got, []string{"prod/db", "prod/web-1"}) {
+		t.Fatalf("favourite nodes: %v", got)
+	}
+
+	// 可以按路径取消按别名收藏的节点，已删除的节点按原标识取消
+	if !h.RemoveFavourite(nodes, "prod/web-1") || !h.RemoveFavourite(nodes, "removed/host") {
+		t.Fatal("favourite not removed")
+	}
+	if h.RemoveFavourite(nodes, "dev/web-1") {
+		t.Fatal("removed a node that is not a favourite")
+	}
+	if !reflect.DeepEqual(h.Favourites, []string{"prod/db"}) {
+		t.Fatalf("favourites after remove: %v", h.Favourites)
+	}
+}
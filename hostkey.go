@@ -0,0 +1,246 @@
+package sshw
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/atrox/homedir"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// 配置中信任的主机 CA 公钥，可以是公钥、公钥文件或 known_hosts 格式的 "<主机模式> <公钥>"
+	hostCAKeys []string
+	// 吊销的主机密钥或 CA 公钥列表文件，每行一个公钥
+	revokedHostKeys string
+)
+
+// hostCA 信任的主机 CA，patterns 为空时适用于所有主机
+type hostCA struct {
+	key      ssh.PublicKey
+	patterns []string
+}
+
+// hostTrust 签发主机证书的 CA 和吊销的密钥
+type hostTrust struct {
+	cas     []hostCA
+	revoked []ssh.PublicKey
+}
+
+// loadHostTrust 读取配置中的 host_ca_keys、revoked_host_keys 及 known_hosts 中的
+// @cert-authority 和 @revoked 记录
+func loadHostTrust() (*hostTrust, error) {
+	t := new(hostTrust)
+	for _, entry := range hostCAKeys {
+		if err := t.addCAEntry(entry); err != nil {
+			return nil, fmt.Errorf("invalid host_ca_keys entry %q: %v", entry, err)
+		}
+	}
+
+	if revokedHostKeys != "" {
+		p, _ := homedir.Expand(revokedHostKeys)
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read revoked_host_keys: %v", err)
+		}
+		for len(bytes.TrimSpace(b)) > 0 {
+			var key ssh.PublicKey
+			key, _, _, b, err = ssh.ParseAuthorizedKey(b)
+			if err != nil {
+				return nil, fmt.Errorf("invalid revoked_host_keys: %v", err)
+			}
+			t.revoked = append(t.revoked, key)
+		}
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return t, nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return t, nil
+	}
+	for len(b) > 0 {
+		marker, hosts, key, _, rest, err := ssh.ParseKnownHosts(b)
+		if err != nil {
+			break
+		}
+		switch marker {
+		case "cert-authority":
+			t.cas = append(t.cas, hostCA{key: key, patterns: hosts})
+		case "revoked":
+			t.revoked = append(t.revoked, key)
+		}
+		b = rest
+	}
+	return t, nil
+}
+
+// addCAEntry 解析 host_ca_keys 中的一项
+func (t *hostTrust) addCAEntry(entry string) error {
+	if _, hosts, key, _, _, err := ssh.ParseKnownHosts([]byte(entry)); err == nil {
+		t.cas = append(t.cas, hostCA{key: key, patterns: hosts})
+		return nil
+	}
+	if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(entry)); err == nil {
+		t.cas = append(t.cas, hostCA{key: key})
+		return nil
+	}
+
+	p, _ := homedir.Expand(entry)
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return err
+	}
+	t.cas = append(t.cas, hostCA{key: key})
+	return nil
+}
+
+// authorities 返回适用于该地址的 CA
+func (t *hostTrust) authorities(address string) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	for _, ca := range t.cas {
+		if len(ca.patterns) == 0 || matchHostPatterns(ca.patterns, address) {
+			keys = append(keys, ca.key)
+		}
+	}
+	return keys
+}
+
+func (t *hostTrust) isRevoked(key ssh.PublicKey) bool {
+	for _, r := range t.revoked {
+		if bytes.Equal(r.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// HostKeyCallback 校验主机证书
+// 有适用的 CA 时主机必须提供该 CA 签发、主体包含节点 host、在有效期内且未吊销的证书，
+// 没有适用的 CA 时保持原来不校验主机密钥的行为
+func (t *hostTrust) HostKeyCallback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if t.isRevoked(key) {
+		return fmt.Errorf("host key for %s is revoked", hostname)
+	}
+
+	cas := t.authorities(hostname)
+	if len(cas) == 0 {
+		return nil
+	}
+	if _, ok := key.(*ssh.Certificate); !ok {
+		return fmt.Errorf("host %s did not present a certificate signed by a trusted host CA", hostname)
+	}
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			for _, ca := range cas {
+				if bytes.Equal(ca.Marshal(), auth.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		IsRevoked: func(cert *ssh.Certificate) bool {
+			return t.isRevoked(cert.Key) || t.isRevoked(cert.SignatureKey)
+		},
+	}
+	return checker.CheckHostKey(hostname, remote, key)
+}
+
+// matchHostPatterns 按 known_hosts 的规则匹配主机，支持 * ? 通配、! 排除、[host]:port
+// 和 ssh-keygen -H 生成的 |1|salt|hash 哈希主机名
+func matchHostPatterns(patterns []string, address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, "22"
+	}
+	candidates := []string{host}
+	if port != "22" {
+		candidates = []string{"[" + host + "]:" + port}
+	}
+
+	matched := false
+	for _, p := range patterns {
+		negate := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		for _, c := range candidates {
+			if strings.HasPrefix(p, "|1|") && hashedMatch(p, c) || wildcardMatch(p, c) {
+				if negate {
+					return false
+				}
+				matched = true
+			}
+		}
+	}
+	return matched
+}
+
+// hashedMatch 匹配 |1|base64(salt)|base64(HMAC-SHA1(salt, host)) 格式的哈希主机名，
+// 格式不正确的记录不匹配任何主机
+func hashedMatch(pattern, host string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+// wildcardMatch 匹配只包含 * 和 ? 通配符的模式
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// hostKeyCallback 返回连接时使用的主机密钥校验，配置有误时拒绝连接
+func hostKeyCallback() ssh.HostKeyCallback {
+	t, err := loadHostTrust()
+	if err != nil {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return err
+		}
+	}
+	return t.HostKeyCallback
+}
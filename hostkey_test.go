@@ -0,0 +1,177 @@
+package sshw
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// hostCert 使用 ca 签发主机证书
+func hostCert(t *testing.T, ca ssh.Signer, principals []string, after, before time.Time) *ssh.Certificate {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             newTestSigner(t).PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(after.Unix()),
+		ValidBefore:     uint64(before.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// useKnownHosts 将 HOME 指向临时目录并写入 known_hosts
+func useKnownHosts(t *testing.T, lines ...string) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.Mkdir(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	content := strings.Join(lines, "\n") + "\n"
+	if err := ioutil.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func hashHost(host string) string {
+	salt := make([]byte, sha1.Size)
+	rand.Read(salt)
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func checkHost(t *testing.T, address string, key ssh.PublicKey) error {
+	t.Helper()
+	trust, err := loadHostTrust()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trust.HostKeyCallback(address, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}, key)
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	saveState(t)
+	ca := newTestSigner(t)
+	other := newTestSigner(t)
+	now := time.Now()
+	valid := hostCert(t, ca, []string{"web1.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	plain := newTestSigner(t).PublicKey()
+
+	tests := []struct {
+		name    string
+		cas     []string
+		known   []string
+		address string
+		key     ssh.PublicKey
+		err     string
+	}{
+		{"signed", []string{authorizedKey(ca.PublicKey())}, nil, "web1.example.com:22", valid, ""},
+		{"unknown CA", []string{authorizedKey(other.PublicKey())}, nil, "web1.example.com:22", valid, "ssh: no authorities for hostname"},
+		{"wrong principal", []string{authorizedKey(ca.PublicKey())}, nil, "web2.example.com:22", valid, "not in the set of valid principals"},
+		{"expired", []string{authorizedKey(ca.PublicKey())}, nil, "web1.example.com:22",
+			hostCert(t, ca, []string{"web1.example.com"}, now.Add(-2*time.Hour), now.Add(-time.Hour)), "cert has expired"},
+		{"not yet valid", []string{authorizedKey(ca.PublicKey())}, nil, "web1.example.com:22",
+			hostCert(t, ca, []string{"web1.example.com"}, now.Add(time.Hour), now.Add(2*time.Hour)), "cert is not yet valid"},
+		{"plain key with CA", []string{authorizedKey(ca.PublicKey())}, nil, "web1.example.com:22", plain, "did not present a certificate"},
+		{"plain key without CA", nil, nil, "web1.example.com:22", plain, ""},
+		{"plain key with other host CA", []string{"*.internal " + authorizedKey(ca.PublicKey())}, nil, "web1.example.com:22", plain, ""},
+		{"known_hosts CA", nil, []string{"@cert-authority *.example.com " + authorizedKey(ca.PublicKey())}, "web1.example.com:22", valid, ""},
+		{"known_hosts CA plain key", nil, []string{"@cert-authority *.example.com " + authorizedKey(ca.PublicKey())}, "web1.example.com:22", plain, "did not present a certificate"},
+		{"hashed known_hosts CA", nil, []string{"@cert-authority " + hashHost("web1.example.com") + " " + authorizedKey(ca.PublicKey())}, "web1.example.com:22", plain, "did not present a certificate"},
+		{"hashed known_hosts other host", nil, []string{"@cert-authority " + hashHost("web2.example.com") + " " + authorizedKey(ca.PublicKey())}, "web1.example.com:22", plain, ""},
+		{"revoked CA", []string{authorizedKey(ca.PublicKey())}, []string{"@revoked * " + authorizedKey(ca.PublicKey())}, "web1.example.com:22", valid, "revoked"},
+		{"revoked cert key", []string{authorizedKey(ca.PublicKey())}, []string{"@revoked * " + authorizedKey(valid.Key)}, "web1.example.com:22", valid, "revoked"},
+		{"revoked plain key", nil, []string{"@revoked * " + authorizedKey(plain)}, "web1.example.com:22", plain, "is revoked"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostCAKeys, revokedHostKeys = tt.cas, ""
+			useKnownHosts(t, tt.known...)
+			err := checkHost(t, tt.address, tt.key)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRevokedHostKeysFile(t *testing.T) {
+	saveState(t)
+	ca := newTestSigner(t)
+	now := time.Now()
+	cert := hostCert(t, ca, []string{"web1.example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	useKnownHosts(t)
+
+	p := filepath.Join(t.TempDir(), "revoked")
+	if err := ioutil.WriteFile(p, ssh.MarshalAuthorizedKey(ca.PublicKey()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	hostCAKeys, revokedHostKeys = []string{authorizedKey(ca.PublicKey())}, p
+	if err := checkHost(t, "web1.example.com:22", cert); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Fatalf("got error %v, want revoked", err)
+	}
+
+	revokedHostKeys = filepath.Join(t.TempDir(), "missing")
+	if _, err := loadHostTrust(); err == nil {
+		t.Fatal("missing revoked_host_keys accepted")
+	}
+}
+
+func TestMatchHostPatterns(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		address  string
+		want     bool
+	}{
+		{[]string{"web1.example.com"}, "web1.example.com:22", true},
+		{[]string{"web1.example.com"}, "web1.example.com", true},
+		{[]string{"web1.example.com"}, "web2.example.com:22", false},
+		{[]string{"*.example.com"}, "web1.example.com:22", true},
+		{[]string{"*.example.com"}, "example.com:22", false},
+		{[]string{"web?.example.com"}, "web1.example.com:22", true},
+		{[]string{"web?.example.com"}, "web10.example.com:22", false},
+		{[]string{"*"}, "10.0.0.1:22", true},
+		{[]string{"10.0.*"}, "10.0.3.4:22", true},
+		{[]string{"*.example.com", "!db.example.com"}, "web.example.com:22", true},
+		{[]string{"*.example.com", "!db.example.com"}, "db.example.com:22", false},
+		{[]string{"!db.example.com"}, "web.example.com:22", false},
+		{[]string{"[web1.example.com]:2222"}, "web1.example.com:2222", true},
+		{[]string{"web1.example.com"}, "web1.example.com:2222", false},
+		{[]string{"[web1.example.com]:2222"}, "web1.example.com:22", false},
+		{[]string{"[*.example.com]:*"}, "web1.example.com:2222", true},
+		{[]string{hashHost("web1.example.com")}, "web1.example.com:22", true},
+		{[]string{hashHost("web1.example.com")}, "web2.example.com:22", false},
+		{[]string{hashHost("[web1.example.com]:2222")}, "web1.example.com:2222", true},
+		{[]string{hashHost("web1.example.com")}, "web1.example.com:2222", false},
+		{[]string{"|1|bad|hash"}, "web1.example.com:22", false},
+	}
+	for _, tt := range tests {
+		if got := matchHostPatterns(tt.patterns, tt.address); got != tt.want {
+			t.Errorf("matchHostPatterns(%q, %q) = %v, want %v", tt.patterns, tt.address, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package sshw
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/zdev0x/sshw/crypto"
+	"golang.org/x/crypto/ssh"
+)
+
+// MasterPasswordRecipient 表示主密码包裹的数据密钥，可以像接收者一样删除
+const MasterPasswordRecipient = "master-password"
+
+var ErrNotUnlocked = errors.New("config is not encrypted or not unlocked, run sshw -encrypt first")
+
+// DefaultIdentityFiles 默认查找的身份文件
+func DefaultIdentityFiles() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	return []string{
+		filepath.Join(home, ".sshw-identity"),
+		filepath.Join(home, ".ssh", "id_ed25519"),
+	}
+}
+
+// LoadIdentities 读取身份文件，只在当前配置有接收者时才读取
+// 有密码保护的 ssh 私钥只在匹配某个接收者时才通过 passphrase 询问密码
+func LoadIdentities(paths []string, passphrase func(path string) ([]byte, error)) []*crypto.Identity {
+	if header == nil || len(header.Recipients) == 0 {
+		return nil
+	}
+
+	var ids []*crypto.Identity
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				l.Error(err)
+			}
+			continue
+		}
+
+		id, err := crypto.ParseIdentity(b, nil)
+		if missing, ok := err.(*ssh.PassphraseMissingError); ok {
+			if passphrase == nil || missing.PublicKey == nil || !header.HasRecipient(missing.PublicKey) {
+				continue
+			}
+			var pass []byte
+			if pass, err = passphrase(p); err == nil {
+				id, err = crypto.ParseIdentity(b, pass)
+			}
+		}
+		if err != nil {
+			l.Errorf("failed to load identity %s: %v", p, err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// IsUnlocked 当前配置的数据密钥是否已经解出
+func IsUnlocked() bool {
+	return dataKey != nil
+}
+
+// HasMasterPassword 当前配置的数据密钥是否由主密码包裹
+func HasMasterPassword() bool {
+	return header != nil && header.Key != ""
+}
+
+// GetRecipients 返回当前配置的接收者
+func GetRecipients() []*crypto.Recipient {
+	if header == nil {
+		return nil
+	}
+	return header.Recipients
+}
+
+// AddRecipient 为新的接收者包裹数据密钥，配置需要已加密并解锁
+func AddRecipient(publicKey, name string) error {
+	if dataKey == nil {
+		return ErrNotUnlocked
+	}
+	if err := header.AddRecipient(dataKey, publicKey, name); err != nil {
+		return err
+	}
+	return encryptConfig()
+}
+
+// RemoveRecipient 删除接收者并轮换数据密钥，被删除的接收者无法解密重新保存后的配置
+// 数据密钥同时由主密码包裹时需要提供主密码
+func RemoveRecipient(match string, password []byte) error {
+	if dataKey == nil {
+		return ErrNotUnlocked
+	}
+
+	// 先校验主密码，避免失败时配置头已被修改
+	if match != MasterPasswordRecipient && header.Key != "" {
+		if password == nil {
+			return ErrPasswordRequired
+		}
+		if _, err := header.Unwrap(password); err != nil {
+			return err
+		}
+	}
+
+	if match == MasterPasswordRecipient {
+		if len(header.Recipients) == 0 {
+			return fmt.Errorf("cannot remove master password without any recipient")
+		}
+		header.RemovePassword()
+	} else if !header.RemoveRecipient(match) {
+		return fmt.Errorf("recipient not found: %s", match)
+	}
+
+	return rotateKey(password)
+}
+
+// rotateKey 生成新的数据密钥，重新包裹并重新加密所有节点
+// 节点需要已经解密，主密码需要已经校验
+func rotateKey(password []byte) error {
+	key, err := crypto.NewDataKey()
+	if err != nil {
+		return err
+	}
+	if header.Key != "" {
+		if err := header.Wrap(key, password); err != nil {
+			return err
+		}
+	}
+	if err := header.WrapRecipients(key); err != nil {
+		return err
+	}
+	dataKey = key
+	return encryptConfig()
+}
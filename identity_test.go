@@ -0,0 +1,107 @@
+package sshw
+
+import (
+	"testing"
+
+	"github.com/zdev0x/sshw/crypto"
+)
+
+const recipientConfig = `
+- name: web
+  host: 10.0.0.1
+  password: s3cret
+`
+
+// encryptedConfig 写入并用主密码加密一个配置文件
+func encryptedConfig(t *testing.T, password string) string {
+	t.Helper()
+	p := writeConfig(t, recipientConfig)
+	if err := LoadConfig(nil, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptNodes(GetConfig(), []byte(password)); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(GetConfig(), p); err != nil {
+		t.Fatal(err)
+	}
+	assertNoPlaintext(t, p, "s3cret")
+	return p
+}
+
+func TestAddRecipientKeepsEncryption(t *testing.T) {
+	saveState(t)
+	p := encryptedConfig(t, "master")
+	id, err := crypto.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadConfig([]byte("master"), p); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddRecipient(id.Recipient(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(GetConfig(), p); err != nil {
+		t.Fatal(err)
+	}
+	assertNoPlaintext(t, p, "s3cret")
+
+	if err := LoadConfigWithIdentities([]*crypto.Identity{id}, p); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetConfig()[0].Password; got != "s3cret" {
+		t.Fatalf("unlocked with identity: password %q", got)
+	}
+	if len(GetRecipients()) != 1 || GetRecipients()[0].Name != "alice" {
+		t.Fatalf("recipient not saved: %v", GetRecipients())
+	}
+
+	if err := LoadConfig([]byte("master"), p); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetConfig()[0].Password; got != "s3cret" {
+		t.Fatalf("unlocked with password: password %q", got)
+	}
+}
+
+func TestRemoveRecipientRotatesKey(t *testing.T) {
+	saveState(t)
+	p := encryptedConfig(t, "master")
+	id, _ := crypto.GenerateIdentity()
+
+	if err := LoadConfig([]byte("master"), p); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddRecipient(id.Recipient(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(GetConfig(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadConfig([]byte("master"), p); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveRecipient("alice", nil); err != ErrPasswordRequired {
+		t.Fatalf("remove without password: got %v, want %v", err, ErrPasswordRequired)
+	}
+	if err := RemoveRecipient("alice", []byte("master")); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(GetConfig(), p); err != nil {
+		t.Fatal(err)
+	}
+	assertNoPlaintext(t, p, "s3cret")
+
+	if err := LoadConfigWithIdentities([]*crypto.Identity{id}, p); err == nil {
+		t.Fatal("removed recipient can still unlock the config")
+	}
+	if err := LoadConfig([]byte("master"), p); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetConfig()[0].Password; got != "s3cret" {
+		t.Fatalf("password after rotation: %q", got)
+	}
+}
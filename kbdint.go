@@ -0,0 +1,99 @@
+package sshw
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// PromptAnswer keyboard-interactive 问题的预设回答
+type PromptAnswer struct {
+	PromptRegex string `yaml:"prompt_regex" json:"prompt_regex"`
+	Answer      string `yaml:"answer" json:"answer"`
+}
+
+// scriptedAnswer 编译后的预设回答
+type scriptedAnswer struct {
+	prompt *regexp.Regexp
+	answer string
+	used   bool
+}
+
+// keyboardInteractive 回答 keyboard-interactive 认证的问题
+// 依次使用 keyboard_interactive 中匹配的预设回答、totp_secret 生成的验证码，其余问题从终端读取
+// 每个预设回答和验证码在同一次连接中只自动使用一次，认证失败后改为终端输入
+func (n *Node) keyboardInteractive() ssh.AuthMethod {
+	var scripted []*scriptedAnswer
+	for _, a := range n.KeyboardInteractive {
+		re, err := regexp.Compile(a.PromptRegex)
+		if err != nil {
+			l.Errorf("%s: invalid prompt_regex %q: %v", n.Path(), a.PromptRegex, err)
+			continue
+		}
+		scripted = append(scripted, &scriptedAnswer{prompt: re, answer: a.Answer})
+	}
+
+	var totpPrompt *regexp.Regexp
+	if n.TOTPSecret != "" {
+		pattern := n.TOTPPrompt
+		if pattern == "" {
+			pattern = defaultTOTPPrompt
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			l.Errorf("%s: invalid totp_prompt: %v", n.Path(), err)
+		} else {
+			totpPrompt = re
+		}
+	}
+	totpAnswered := false
+
+	return ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, 0, len(questions))
+	questions:
+		for i, q := range questions {
+			for _, a := range scripted {
+				if !a.used && a.prompt.MatchString(q) {
+					a.used = true
+					answers = append(answers, a.answer)
+					continue questions
+				}
+			}
+			if totpPrompt != nil && !totpAnswered && totpPrompt.MatchString(q) {
+				code, err := TOTP(n.TOTPSecret)
+				if err != nil {
+					l.Errorf("%s: %v", n.Path(), err)
+				} else {
+					totpAnswered = true
+					answers = append(answers, code)
+					continue
+				}
+			}
+
+			fmt.Print(q)
+			if echos[i] {
+				scan := bufio.NewScanner(os.Stdin)
+				if scan.Scan() {
+					answers = append(answers, scan.Text())
+				}
+				err := scan.Err()
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				b, err := terminal.ReadPassword(int(syscall.Stdin))
+				if err != nil {
+					return nil, err
+				}
+				fmt.Println()
+				answers = append(answers, string(b))
+			}
+		}
+		return answers, nil
+	})
+}
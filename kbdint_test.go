@@ -0,0 +1,81 @@
+package sshw
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// kbdintServer 依次提出 questions，返回服务器收到的回答
+func kbdintServer(t *testing.T, questions ...string) (string, <-chan []string) {
+	t.Helper()
+	received := make(chan []string, 4)
+	config := &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("alice", "", questions, make([]bool, len(questions)))
+			if err != nil {
+				return nil, err
+			}
+			received <- answers
+			return nil, nil
+		},
+	}
+	return serveSSH(t, config), received
+}
+
+func TestKeyboardInteractiveScripted(t *testing.T) {
+	addr, received := kbdintServer(t, "Password: ", "PIN: ", "Verification code: ")
+	n := &Node{
+		Name:       "web",
+		TOTPSecret: "JBSWY3DPEHPK3PXP",
+		KeyboardInteractive: []*PromptAnswer{
+			{PromptRegex: "[", Answer: "skipped"},
+			{PromptRegex: "(?i)^pin", Answer: "1234"},
+			{PromptRegex: "(?i)password", Answer: "s3cret"},
+		},
+	}
+
+	// 登录可能跨过验证码的时间窗口，前后两个验证码都可以
+	before, err := TOTP(n.TOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dialTest(addr, "alice", n.keyboardInteractive()); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := TOTP(n.TOTPSecret)
+
+	got := <-received
+	if got[0] != "s3cret" || got[1] != "1234" || (got[2] != before && got[2] != after) {
+		t.Fatalf("answers: %q", got)
+	}
+}
+
+func TestKeyboardInteractivePrecedence(t *testing.T) {
+	// 预设回答优先于 totp_secret，同一个回答只使用一次
+	addr, received := kbdintServer(t, "OTP token: ", "OTP token: ")
+	n := &Node{
+		Name:                "web",
+		TOTPSecret:          "JBSWY3DPEHPK3PXP",
+		KeyboardInteractive: []*PromptAnswer{{PromptRegex: "token", Answer: "static"}},
+	}
+
+	if err := dialTest(addr, "alice", n.keyboardInteractive()); err != nil {
+		t.Fatal(err)
+	}
+	got := <-received
+	if got[0] != "static" || len(got[1]) != 6 || got[1] == "static" {
+		t.Fatalf("answers: %q", got)
+	}
+}
+
+func TestKeyboardInteractiveNoQuestions(t *testing.T) {
+	addr, received := kbdintServer(t)
+	n := &Node{Name: "web", KeyboardInteractive: []*PromptAnswer{{PromptRegex: ".", Answer: "x"}}}
+	if err := dialTest(addr, "alice", n.keyboardInteractive()); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; len(got) != 0 {
+		t.Fatalf("answers: %q", got)
+	}
+}
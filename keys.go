@@ -0,0 +1,296 @@
+package sshw
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/atrox/homedir"
+	"golang.org/x/crypto/ssh"
+)
+
+// 证书剩余有效期少于该时间时提示
+const certExpiryWarning = time.Hour
+
+var (
+	// 未设置私钥时按顺序查找的默认私钥
+	defaultKeyTypes = []string{"ed25519", "ecdsa", "rsa"}
+	defaultKeyFiles = map[string]string{
+		"ed25519": "id_ed25519",
+		"ecdsa":   "id_ecdsa",
+		"rsa":     "id_rsa",
+	}
+)
+
+// KeyFile 私钥文件，配置中可以只写路径
+type KeyFile struct {
+	Path       string `yaml:"path" json:"path"`
+	Passphrase string `yaml:"passphrase,omitempty" json:"passphrase,omitempty"`
+	CertPath   string `yaml:"certpath,omitempty" json:"certpath,omitempty"`
+	// 是否是自动查找到的默认私钥
+	discovered bool
+}
+
+func (k *KeyFile) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&k.Path); err == nil {
+		return nil
+	}
+	type plain KeyFile
+	return unmarshal((*plain)(k))
+}
+
+func (k *KeyFile) MarshalYAML() (interface{}, error) {
+	if k.Passphrase == "" && k.CertPath == "" {
+		return k.Path, nil
+	}
+	type plain KeyFile
+	return (*plain)(k), nil
+}
+
+func (k *KeyFile) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &k.Path); err == nil {
+		return nil
+	}
+	type plain KeyFile
+	return json.Unmarshal(b, (*plain)(k))
+}
+
+func (k *KeyFile) MarshalJSON() ([]byte, error) {
+	if k.Passphrase == "" && k.CertPath == "" {
+		return json.Marshal(k.Path)
+	}
+	type plain KeyFile
+	return json.Marshal((*plain)(k))
+}
+
+// keyTypes 返回查找默认私钥的类型顺序，未设置时从上级分组继承
+func (n *Node) keyTypes() []string {
+	for p := n; p != nil; p = p.parent {
+		if len(p.KeyTypes) > 0 {
+			return p.KeyTypes
+		}
+	}
+	return defaultKeyTypes
+}
+
+// maxKeys 返回最多提供的私钥数量，0 表示不限制，未设置时从上级分组继承
+func (n *Node) maxKeys() int {
+	for p := n; p != nil; p = p.parent {
+		if p.MaxKeys > 0 {
+			return p.MaxKeys
+		}
+	}
+	return 0
+}
+
+// identities 返回节点使用的私钥：keypath、keypaths，都没有设置时查找存在的默认私钥
+// 没有单独设置密码的私钥使用节点的 passphrase
+func (n *Node) identities() []*KeyFile {
+	var keys []*KeyFile
+	if n.KeyPath != "" {
+		keys = append(keys, &KeyFile{Path: n.KeyPath, Passphrase: n.Passphrase, CertPath: n.CertPath})
+	}
+	for _, k := range n.KeyPaths {
+		key := *k
+		if key.Passphrase == "" {
+			key.Passphrase = n.Passphrase
+		}
+		keys = append(keys, &key)
+	}
+	for _, k := range keys {
+		k.Path, _ = homedir.Expand(k.Path)
+	}
+	if len(keys) > 0 {
+		return keys
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	for _, t := range n.keyTypes() {
+		name, ok := defaultKeyFiles[t]
+		if !ok {
+			continue
+		}
+		path := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(path); err == nil {
+			keys = append(keys, &KeyFile{Path: path, Passphrase: n.Passphrase, discovered: true})
+		}
+	}
+	return keys
+}
+
+// certPath 返回证书路径，未设置 certpath 时使用私钥旁边的 -cert.pub
+// 第二个返回值表示是否显式设置
+func (k *KeyFile) certPath() (string, bool) {
+	if k.CertPath != "" {
+		p, _ := homedir.Expand(k.CertPath)
+		return p, true
+	}
+	return k.Path + "-cert.pub", false
+}
+
+// signers 读取节点的所有私钥及证书，超过 max_keys 的私钥不提供
+// max_keys 只计算私钥，保留的私钥都会带上各自的证书
+func (n *Node) signers() []ssh.Signer {
+	var signers []ssh.Signer
+	max, kept := n.maxKeys(), 0
+	for _, k := range n.identities() {
+		if max > 0 && kept >= max {
+			break
+		}
+		pemBytes, err := ioutil.ReadFile(k.Path)
+		if err != nil {
+			l.Errorf("%s: %v", n.Path(), err)
+			continue
+		}
+		// 节点的 passphrase 会用于所有私钥，没有密码的私钥直接解析
+		signer, err := ssh.ParsePrivateKey(pemBytes)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok && k.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(k.Passphrase))
+		}
+		if err != nil {
+			// 自动查找到的私钥有密码时跳过，不打扰用户
+			if _, ok := err.(*ssh.PassphraseMissingError); !ok || !k.discovered {
+				l.Errorf("%s: %s: %v", n.Path(), k.Path, err)
+			}
+			continue
+		}
+		signers = append(signers, n.certSigners(k, signer)...)
+		kept++
+	}
+	return signers
+}
+
+// loadCert 读取 OpenSSH 证书
+func loadCert(path string) (*ssh.Certificate, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s: %v", path, err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", path)
+	}
+	return cert, nil
+}
+
+// certSigners 为私钥加上证书，证书在前，私钥本身作为后备
+// 没有证书时只返回私钥
+func (n *Node) certSigners(k *KeyFile, signer ssh.Signer) []ssh.Signer {
+	path, explicit := k.certPath()
+	cert, err := loadCert(path)
+	if err != nil {
+		if explicit || !os.IsNotExist(err) {
+			l.Errorf("%s: %v", n.Path(), err)
+		}
+		return []ssh.Signer{signer}
+	}
+
+	now := time.Now()
+	if status, warn := CertStatus(cert, now); warn {
+		l.Infof("%s: certificate %s %s\n", n.Path(), path, status)
+	}
+	// 不在有效期内的证书不提供，避免占用认证次数
+	if !certValid(cert, now) {
+		return []ssh.Signer{signer}
+	}
+	cs, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		l.Errorf("%s: certificate %s: %v", n.Path(), path, err)
+		return []ssh.Signer{signer}
+	}
+	return []ssh.Signer{cs, signer}
+}
+
+func certValid(cert *ssh.Certificate, now time.Time) bool {
+	unix := uint64(now.Unix())
+	return unix >= cert.ValidAfter && (cert.ValidBefore == ssh.CertTimeInfinity || unix < cert.ValidBefore)
+}
+
+// CertStatus 返回证书的有效状态，已过期、未生效或即将过期时 warn 为 true
+func CertStatus(cert *ssh.Certificate, now time.Time) (status string, warn bool) {
+	unix := uint64(now.Unix())
+	switch {
+	case cert.ValidAfter != 0 && unix < cert.ValidAfter:
+		return "not valid yet", true
+	case cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore:
+		return "expired", true
+	case cert.ValidBefore == ssh.CertTimeInfinity:
+		return "valid forever", false
+	}
+	left := time.Unix(int64(cert.ValidBefore), 0).Sub(now)
+	if left < certExpiryWarning {
+		return "expires in " + formatRemaining(left), true
+	}
+	return "valid for " + formatRemaining(left), false
+}
+
+// formatRemaining 把剩余时间按分钟格式化，如 2h5m、45m，不足一分钟时为 <1m
+func formatRemaining(d time.Duration) string {
+	d = d.Truncate(time.Minute)
+	h, m := int(d/time.Hour), int(d%time.Hour/time.Minute)
+	switch {
+	case d < time.Minute:
+		return "<1m"
+	case h == 0:
+		return fmt.Sprintf("%dm", m)
+	case m == 0:
+		return fmt.Sprintf("%dh", h)
+	}
+	return fmt.Sprintf("%dh%dm", h, m)
+}
+
+// KeyInfo 配置中使用的私钥及其证书
+type KeyInfo struct {
+	KeyPath  string
+	CertPath string
+	Cert     *ssh.Certificate
+	// 使用该私钥的节点路径
+	Nodes []string
+}
+
+// ListKeys 列出节点树中使用的私钥和证书
+func ListKeys(nodes []*Node) []*KeyInfo {
+	keys := make(map[string]*KeyInfo)
+	var walk func(nodes []*Node)
+	walk = func(nodes []*Node) {
+		for _, n := range nodes {
+			if n.Host != "" {
+				for _, id := range n.identities() {
+					certPath, _ := id.certPath()
+					k, ok := keys[id.Path+"\x00"+certPath]
+					if !ok {
+						k = &KeyInfo{KeyPath: id.Path, CertPath: certPath}
+						if cert, err := loadCert(certPath); err == nil {
+							k.Cert = cert
+						}
+						keys[id.Path+"\x00"+certPath] = k
+					}
+					k.Nodes = append(k.Nodes, n.Path())
+				}
+			}
+			walk(n.Children)
+			walk(n.Jump)
+		}
+	}
+	walk(nodes)
+
+	list := make([]*KeyInfo, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, k)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].KeyPath < list[j].KeyPath
+	})
+	return list
+}
@@ -0,0 +1,228 @@
+package sshw
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeKey 在 dir 中生成私钥文件，withCert 为 true 时同时写入 CA 签发的 -cert.pub
+func writeKey(t *testing.T, dir, name string, ca ssh.Signer, withCert bool) (string, ssh.PublicKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, _ := ssh.NewSignerFromKey(priv)
+	if !withCert {
+		return p, signer.PublicKey()
+	}
+
+	cert := &ssh.Certificate{
+		Key:         signer.PublicKey(),
+		CertType:    ssh.UserCert,
+		ValidAfter:  uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore: uint64(time.Now().Add(24 * time.Hour).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p, signer.PublicKey()
+}
+
+// signerKeys 返回 signer 对应的私钥公钥，证书返回其中的公钥，用于比较顺序
+func signerKeys(signers []ssh.Signer) ([][]byte, []bool) {
+	var keys [][]byte
+	var certs []bool
+	for _, s := range signers {
+		pub := s.PublicKey()
+		cert, ok := pub.(*ssh.Certificate)
+		if ok {
+			pub = cert.Key
+		}
+		keys = append(keys, pub.Marshal())
+		certs = append(certs, ok)
+	}
+	return keys, certs
+}
+
+func TestSignersMaxKeys(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestSigner(t)
+	first, firstPub := writeKey(t, dir, "first", ca, true)
+	second, secondPub := writeKey(t, dir, "second", ca, true)
+	third, _ := writeKey(t, dir, "third", ca, false)
+	missing := filepath.Join(dir, "missing")
+
+	type want struct {
+		key  ssh.PublicKey
+		cert bool
+	}
+	tests := []struct {
+		name string
+		keys []string
+		max  int
+		want []want
+	}{
+		{"cert of the last kept key", []string{first, second, third}, 2,
+			[]want{{firstPub, true}, {firstPub, false}, {secondPub, true}, {secondPub, false}}},
+		{"single key with cert", []string{first, second}, 1,
+			[]want{{firstPub, true}, {firstPub, false}}},
+		{"unreadable key not counted", []string{missing, second, first}, 1,
+			[]want{{secondPub, true}, {secondPub, false}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &Node{Name: "web"}
+			for _, k := range tt.keys {
+				n.KeyPaths = append(n.KeyPaths, &KeyFile{Path: k})
+			}
+			// max_keys 从上级分组继承
+			linkParents([]*Node{{Name: "prod", MaxKeys: tt.max, Children: []*Node{n}}}, nil)
+
+			keys, certs := signerKeys(n.signers())
+			if len(keys) != len(tt.want) {
+				t.Fatalf("got %d signers, want %d", len(keys), len(tt.want))
+			}
+			for i, w := range tt.want {
+				if !bytes.Equal(keys[i], w.key.Marshal()) || certs[i] != w.cert {
+					t.Errorf("signer %d: unexpected key or certificate (cert %v, want %v)", i, certs[i], w.cert)
+				}
+			}
+		})
+	}
+}
+
+func TestSignersUnlimited(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestSigner(t)
+	var n Node
+	for _, name := range []string{"a", "b", "c"} {
+		p, _ := writeKey(t, dir, name, ca, name == "b")
+		n.KeyPaths = append(n.KeyPaths, &KeyFile{Path: p})
+	}
+	if got := len(n.signers()); got != 4 {
+		t.Fatalf("got %d signers, want 4", got)
+	}
+}
+
+func TestCertStatus(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	at := func(d time.Duration) uint64 { return uint64(now.Add(d).Unix()) }
+
+	tests := []struct {
+		name          string
+		after, before uint64
+		status        string
+		warn          bool
+	}{
+		{"expired", at(-2 * time.Hour), at(-time.Second), "expired", true},
+		{"expires now", at(-time.Hour), at(0), "expired", true},
+		{"not valid yet", at(time.Minute), at(time.Hour), "not valid yet", true},
+		{"no expiry", 0, ssh.CertTimeInfinity, "valid forever", false},
+		{"under a minute", 0, at(50 * time.Second), "expires in <1m", true},
+		{"ten seconds", 0, at(10 * time.Second), "expires in <1m", true},
+		{"minutes", 0, at(30*time.Minute + 40*time.Second), "expires in 30m", true},
+		{"just under an hour", 0, at(59*time.Minute + 59*time.Second), "expires in 59m", true},
+		{"hours", 0, at(2 * time.Hour), "valid for 2h", false},
+		{"hours and minutes", 0, at(26*time.Hour + 5*time.Minute), "valid for 26h5m", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := &ssh.Certificate{ValidAfter: tt.after, ValidBefore: tt.before}
+			status, warn := CertStatus(cert, now)
+			if status != tt.status || warn != tt.warn {
+				t.Fatalf("got %q, %v, want %q, %v", status, warn, tt.status, tt.warn)
+			}
+		})
+	}
+}
+
+// writeCert 为 key 写入 ca 签发的证书，有效期为 after 到 before
+func writeCert(t *testing.T, path string, key ssh.PublicKey, ca ssh.Signer, after, before time.Time) {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:         key,
+		CertType:    ssh.UserCert,
+		ValidAfter:  uint64(after.Unix()),
+		ValidBefore: uint64(before.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertSigners(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestSigner(t)
+	keyPath, pub := writeKey(t, dir, "id", ca, false)
+	_, otherPub := writeKey(t, dir, "other", ca, false)
+	b, _ := ioutil.ReadFile(keyPath)
+	signer, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	pubFile := filepath.Join(dir, "id.pub")
+	ioutil.WriteFile(pubFile, ssh.MarshalAuthorizedKey(pub), 0600)
+
+	tests := []struct {
+		name     string
+		certPath string
+		write    func(path string)
+		certs    []bool
+	}{
+		{"no certificate", "", nil, []bool{false}},
+		{"valid certificate", "", func(p string) { writeCert(t, p, pub, ca, now.Add(-time.Minute), now.Add(time.Hour)) }, []bool{true, false}},
+		{"expiring certificate still used", "", func(p string) { writeCert(t, p, pub, ca, now.Add(-time.Minute), now.Add(time.Minute)) }, []bool{true, false}},
+		{"expired certificate", "", func(p string) { writeCert(t, p, pub, ca, now.Add(-time.Hour), now.Add(-time.Minute)) }, []bool{false}},
+		{"certificate not valid yet", "", func(p string) { writeCert(t, p, pub, ca, now.Add(time.Hour), now.Add(2*time.Hour)) }, []bool{false}},
+		{"certificate for another key", "", func(p string) { writeCert(t, p, otherPub, ca, now.Add(-time.Minute), now.Add(time.Hour)) }, []bool{false}},
+		{"explicit certpath", filepath.Join(dir, "custom-cert.pub"), func(p string) { writeCert(t, p, pub, ca, now.Add(-time.Minute), now.Add(time.Hour)) }, []bool{true, false}},
+		{"missing explicit certpath", filepath.Join(dir, "missing-cert.pub"), nil, []bool{false}},
+		{"certpath is not a certificate", pubFile, nil, []bool{false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KeyFile{Path: keyPath, CertPath: tt.certPath}
+			certPath, _ := k.certPath()
+			os.Remove(keyPath + "-cert.pub")
+			if tt.write != nil {
+				tt.write(certPath)
+			}
+
+			keys, certs := signerKeys((&Node{Name: "web"}).certSigners(k, signer))
+			if !reflect.DeepEqual(certs, tt.certs) {
+				t.Fatalf("certificates: got %v, want %v", certs, tt.certs)
+			}
+			// 私钥本身总是作为最后的后备
+			if !bytes.Equal(keys[len(keys)-1], pub.Marshal()) {
+				t.Fatal("private key not offered")
+			}
+		})
+	}
+}
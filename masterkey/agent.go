@@ -0,0 +1,237 @@
+package masterkey
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	agentSocketName  = "sshw-agent.sock"
+	agentDialTimeout = time.Second
+)
+
+var ErrAgentLocked = errors.New("agent is locked")
+
+// AgentSocket 返回 agent 的 Unix socket 路径
+// 优先使用 SSHW_AGENT_SOCK，其次是 XDG_RUNTIME_DIR，最后是用户主目录
+func AgentSocket() string {
+	if sock := os.Getenv("SSHW_AGENT_SOCK"); sock != "" {
+		return sock
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, agentSocketName)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), agentSocketName)
+	}
+	return filepath.Join(home, "."+agentSocketName)
+}
+
+// Agent 在内存中保存解锁后的主密码，空闲超时后自动清除
+type Agent struct {
+	store   *MemoryStore
+	timeout time.Duration
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// NewAgent 创建 agent，timeout 为 0 时不自动清除
+func NewAgent(timeout time.Duration) *Agent {
+	return &Agent{store: NewMemoryStore(), timeout: timeout}
+}
+
+// touch 重置空闲计时
+func (a *Agent) touch() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.timeout <= 0 {
+		return
+	}
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.timer = time.AfterFunc(a.timeout, a.Lock)
+}
+
+// Lock 清除内存中的主密码
+func (a *Agent) Lock() {
+	a.store.DeleteSecret()
+}
+
+// Serve 在 socket 上提供服务，直到 listener 关闭
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go a.handle(conn)
+	}
+}
+
+// ListenAndServe 监听 socket 并提供服务，socket 只允许当前用户访问
+func (a *Agent) ListenAndServe(socket string) error {
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return err
+	}
+	// 已有 agent 在运行时不覆盖
+	if conn, err := net.DialTimeout("unix", socket, agentDialTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("agent already running on %s", socket)
+	}
+	os.Remove(socket)
+
+	l, err := listen(socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+	return a.Serve(l)
+}
+
+// errNoPeerCred 系统不支持获取 socket 对端的身份
+var errNoPeerCred = errors.New("peer credentials not supported")
+
+// checkPeer 只允许当前用户的进程连接，无法获取对端身份时依赖 socket 的权限
+func checkPeer(conn net.Conn) error {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	uid, err := peerUID(uc)
+	if err == errNoPeerCred {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get peer credentials: %v", err)
+	}
+	if int(uid) != os.Getuid() {
+		return fmt.Errorf("connection from uid %d refused", uid)
+	}
+	return nil
+}
+
+// 协议：每行一个命令，GET / SET <base64> / LOCK，返回 OK [base64] 或 ERR <message>
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+	if err := checkPeer(conn); err != nil {
+		fmt.Fprintln(conn, "ERR permission denied")
+		return
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+
+	switch cmd {
+	case "GET":
+		secret, err := a.store.GetSecret()
+		if err != nil {
+			fmt.Fprintln(conn, "ERR locked")
+			return
+		}
+		a.touch()
+		fmt.Fprintln(conn, "OK", base64.StdEncoding.EncodeToString(secret))
+		wipe(secret)
+	case "SET":
+		secret, err := base64.StdEncoding.DecodeString(arg)
+		if err != nil || len(secret) == 0 {
+			fmt.Fprintln(conn, "ERR invalid secret")
+			return
+		}
+		a.store.SetSecret(secret)
+		wipe(secret)
+		a.touch()
+		fmt.Fprintln(conn, "OK")
+	case "LOCK":
+		a.Lock()
+		fmt.Fprintln(conn, "OK")
+	default:
+		fmt.Fprintln(conn, "ERR unknown command")
+	}
+}
+
+// agentRequest 向 agent 发送一条命令
+func agentRequest(cmd string) (string, error) {
+	conn, err := net.DialTimeout("unix", AgentSocket(), agentDialTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := fmt.Fprintln(conn, cmd); err != nil {
+		return "", err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	status, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if status != "OK" {
+		if arg == "locked" {
+			return "", ErrAgentLocked
+		}
+		return "", fmt.Errorf("agent: %s", arg)
+	}
+	return arg, nil
+}
+
+// AgentRunning 检查 agent 是否在运行
+func AgentRunning() bool {
+	conn, err := net.DialTimeout("unix", AgentSocket(), agentDialTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// AgentUnlocked 检查 agent 是否在运行并保存了主密码
+func AgentUnlocked() bool {
+	password, err := agentGet()
+	wipe(password)
+	return err == nil
+}
+
+// agentGet 从 agent 获取主密码
+func agentGet() ([]byte, error) {
+	arg, err := agentRequest("GET")
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(arg)
+}
+
+// agentSet 把主密码交给 agent 保存
+func agentSet(password []byte) error {
+	_, err := agentRequest("SET " + base64.StdEncoding.EncodeToString(password))
+	return err
+}
+
+// Lock 清除 agent 和本地缓存的主密码
+func Lock() error {
+	if AgentRunning() {
+		if _, err := agentRequest("LOCK"); err != nil {
+			return err
+		}
+	}
+	store, err := GetPasswordStore()
+	if err != nil {
+		return err
+	}
+	return store.DeleteSecret()
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package masterkey
+
+import (
+	"net"
+	"os"
+)
+
+// listen 监听 socket，并只允许当前用户访问
+func listen(socket string) (net.Listener, error) {
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
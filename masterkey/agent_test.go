@@ -0,0 +1,119 @@
+package masterkey
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startAgent 在临时 socket 上运行 agent
+func startAgent(t *testing.T) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	t.Setenv("SSHW_AGENT_SOCK", socket)
+
+	go NewAgent(0).ListenAndServe(socket)
+	for i := 0; i < 100 && !AgentRunning(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !AgentRunning() {
+		t.Fatal("agent did not start")
+	}
+	return socket
+}
+
+func TestAgentSocketMode(t *testing.T) {
+	socket := startAgent(t)
+	fi, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Fatalf("socket mode: got %o, want 600", mode)
+	}
+
+	if err := NewAgent(0).ListenAndServe(socket); err == nil {
+		t.Fatal("second agent replaced the running one")
+	}
+}
+
+func TestAgentGetSet(t *testing.T) {
+	startAgent(t)
+
+	if _, err := agentGet(); err != ErrAgentLocked {
+		t.Fatalf("empty agent: got %v, want %v", err, ErrAgentLocked)
+	}
+	if err := agentSet([]byte("master")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := agentGet(); err != nil || string(got) != "master" {
+		t.Fatalf("get: %q, %v", got, err)
+	}
+	if _, err := agentRequest("LOCK"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := agentGet(); err != ErrAgentLocked {
+		t.Fatalf("after lock: got %v, want %v", err, ErrAgentLocked)
+	}
+	if _, err := agentRequest("DUMP"); err == nil {
+		t.Fatal("unknown command accepted")
+	}
+}
+
+func TestAgentTimeout(t *testing.T) {
+	a := NewAgent(20 * time.Millisecond)
+	a.store.SetSecret([]byte("master"))
+	a.touch()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := a.store.GetSecret(); err != ErrNotFound {
+		t.Fatalf("secret after timeout: got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCheckPeer(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "peer.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		if c, err := net.Dial("unix", socket); err == nil {
+			defer c.Close()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := checkPeer(conn); err != nil {
+		t.Fatalf("same user refused: %v", err)
+	}
+	if uid, err := peerUID(conn.(*net.UnixConn)); err != errNoPeerCred && (err != nil || int(uid) != os.Getuid()) {
+		t.Fatalf("peer uid: %d, %v", uid, err)
+	}
+}
+
+func TestAgentCachesPassword(t *testing.T) {
+	setup(t, "master")
+	startAgent(t)
+	store := NewMemoryStore()
+	mustSetVerifier(t, store, "master")
+
+	// agent 运行时主密码只交给 agent，不写入本地存储
+	if _, err := getMasterPassword(store, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetSecret(); err != ErrNotFound {
+		t.Fatalf("secret written to the store: %v", err)
+	}
+	if got, err := getMasterPassword(store, true); err != nil || string(got) != "master" {
+		t.Fatalf("password from agent: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package masterkey
+
+import (
+	"net"
+	"syscall"
+)
+
+// listen 监听 socket，创建时设置 umask，socket 从创建起就只允许当前用户访问
+func listen(socket string) (net.Listener, error) {
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", socket)
+}
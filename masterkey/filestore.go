@@ -23,9 +23,8 @@ func NewFileStore() (*FileStore, error) {
 	return &FileStore{path: path}, nil
 }
 
-// Get 从文件读取密码哈希
-func (s *FileStore) Get() ([]byte, error) {
-	data, err := ioutil.ReadFile(s.path)
+func (s *FileStore) read(suffix string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path + suffix)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, ErrNotFound
@@ -35,30 +34,88 @@ func (s *FileStore) Get() ([]byte, error) {
 	return data, nil
 }
 
-// Set 将密码哈希保存到文件
-func (s *FileStore) Set(data []byte) error {
-	return ioutil.WriteFile(s.path, data, 0600)
+func (s *FileStore) remove(suffix string) error {
+	if err := os.Remove(s.path + suffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
-// GetHash 从文件读取密码哈希
-func (s *FileStore) GetHash() ([]byte, error) {
-	data, err := ioutil.ReadFile(s.path + "_hash")
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, ErrNotFound
+// exists 检查是否已有文件存储的记录（包括旧版本）
+func (s *FileStore) exists() bool {
+	for _, suffix := range []string{".verifier", "", "_hash"} {
+		if _, err := os.Stat(s.path + suffix); err == nil {
+			return true
 		}
-		return nil, err
 	}
-	return data, nil
+	return false
 }
 
-// Delete 删除密码文件
+// GetVerifier 从文件读取密码哈希
+func (s *FileStore) GetVerifier() ([]byte, error) {
+	return s.read(".verifier")
+}
+
+// SetVerifier 将密码哈希保存到文件
+func (s *FileStore) SetVerifier(data []byte) error {
+	return ioutil.WriteFile(s.path+".verifier", data, 0600)
+}
+
+// GetSecret 从文件读取缓存的密码
+func (s *FileStore) GetSecret() ([]byte, error) {
+	return s.read(".secret")
+}
+
+// SetSecret 将密码缓存到文件
+func (s *FileStore) SetSecret(data []byte) error {
+	return ioutil.WriteFile(s.path+".secret", data, 0600)
+}
+
+// DeleteSecret 删除缓存的密码
+func (s *FileStore) DeleteSecret() error {
+	return s.remove(".secret")
+}
+
+// Delete 删除所有密码文件
 func (s *FileStore) Delete() error {
-	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+	for _, suffix := range []string{".verifier", ".secret", ".legacy", "", "_hash"} {
+		if err := s.remove(suffix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrate 迁移旧版本的 .sshw-master 和 .sshw-master_hash
+func (s *FileStore) migrate() error {
+	old, err := s.read("")
+	if err != nil && err != ErrNotFound {
 		return err
 	}
-	if err := os.Remove(s.path + "_hash"); err != nil && !os.IsNotExist(err) {
+	oldHash, err := s.read("_hash")
+	if err != nil && err != ErrNotFound {
 		return err
 	}
-	return nil
+	if old == nil && oldHash == nil {
+		return nil
+	}
+	if err := migrateRecords(s, old, oldHash, func(legacy []byte) error {
+		return ioutil.WriteFile(s.path+".legacy", legacy, 0600)
+	}); err != nil {
+		return err
+	}
+	if err := s.remove(""); err != nil {
+		return err
+	}
+	return s.remove("_hash")
+}
+
+// LegacyPassword 旧版本把密码哈希当作主密码加密了配置，迁移后保留以便重新加密
+func (s *FileStore) LegacyPassword() ([]byte, error) {
+	return s.read(".legacy")
+}
+
+// ClearLegacyPassword 配置重新加密后删除旧版本的密码
+func (s *FileStore) ClearLegacyPassword() error {
+	return s.remove(".legacy")
 }
@@ -16,16 +16,35 @@ const (
 
 var (
 	ErrNotFound = fmt.Errorf("master password not found")
+
+	// readPassword 从终端读取密码
+	readPassword = func(prompt string) ([]byte, error) {
+		if !terminal.IsTerminal(int(syscall.Stdin)) {
+			return nil, fmt.Errorf("stdin is not a terminal, provide the password with SSHW_MASTER_PASSWORD, -password-stdin, -password-file or -password-command")
+		}
+		fmt.Print(prompt)
+		password, err := terminal.ReadPassword(int(syscall.Stdin))
+		fmt.Println()
+		return password, err
+	}
 )
 
 // PasswordStore 定义密码存储接口
+// verifier 保存主密码的 bcrypt 哈希，只用于校验；secret 保存缓存的主密码明文
 type PasswordStore interface {
-	Get() ([]byte, error)
-	Set([]byte) error
-	GetHash() ([]byte, error)
+	GetVerifier() ([]byte, error)
+	SetVerifier([]byte) error
+	GetSecret() ([]byte, error)
+	SetSecret([]byte) error
+	DeleteSecret() error
 	Delete() error
 }
 
+// legacyStore 旧版本的存储需要迁移
+type legacyStore interface {
+	migrate() error
+}
+
 // KeyringStore 实现系统密钥环存储
 type KeyringStore struct {
 	service  string
@@ -39,45 +58,150 @@ func NewKeyringStore() *KeyringStore {
 	}
 }
 
-func (s *KeyringStore) Get() ([]byte, error) {
-	password, err := keyring.Get(s.service, s.username)
+func (s *KeyringStore) get(suffix string) ([]byte, error) {
+	data, err := keyring.Get(s.service, s.username+suffix)
 	if err != nil {
+		if err == keyring.ErrNotFound {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
-	return []byte(password), nil
+	return []byte(data), nil
 }
 
-func (s *KeyringStore) Set(password []byte) error {
-	return keyring.Set(s.service, s.username, string(password))
+func (s *KeyringStore) delete(suffix string) error {
+	if err := keyring.Delete(s.service, s.username+suffix); err != nil && err != keyring.ErrNotFound {
+		return err
+	}
+	return nil
 }
 
-func (s *KeyringStore) GetHash() ([]byte, error) {
-	hash, err := keyring.Get(s.service, s.username+"_hash")
-	if err != nil {
-		return nil, err
-	}
-	return []byte(hash), nil
+func (s *KeyringStore) GetVerifier() ([]byte, error) {
+	return s.get(".verifier")
+}
+
+func (s *KeyringStore) SetVerifier(verifier []byte) error {
+	return keyring.Set(s.service, s.username+".verifier", string(verifier))
+}
+
+func (s *KeyringStore) GetSecret() ([]byte, error) {
+	return s.get(".secret")
+}
+
+func (s *KeyringStore) SetSecret(secret []byte) error {
+	return keyring.Set(s.service, s.username+".secret", string(secret))
+}
+
+func (s *KeyringStore) DeleteSecret() error {
+	return s.delete(".secret")
 }
 
 func (s *KeyringStore) Delete() error {
-	return keyring.Delete(s.service, s.username)
+	for _, suffix := range []string{".verifier", ".secret", ".legacy", "", "_hash"} {
+		if err := s.delete(suffix); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// GetPasswordStore 获取密码存储实例
-func GetPasswordStore() (PasswordStore, error) {
-	// 尝试使用系统密钥环
-	store := NewKeyringStore()
-	_, err := store.Get()
-	if err == nil {
-		return store, nil
+// migrate 迁移旧版本的记录：master 保存哈希或缓存的密码，master_hash 保存哈希
+func (s *KeyringStore) migrate() error {
+	old, err := s.get("")
+	if err != nil && err != ErrNotFound {
+		return err
 	}
+	oldHash, err := s.get("_hash")
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if old == nil && oldHash == nil {
+		return nil
+	}
+	if err := migrateRecords(s, old, oldHash, func(legacy []byte) error {
+		return keyring.Set(s.service, s.username+".legacy", string(legacy))
+	}); err != nil {
+		return err
+	}
+	if err := s.delete(""); err != nil {
+		return err
+	}
+	return s.delete("_hash")
+}
+
+// LegacyPassword 旧版本把密码哈希当作主密码加密了配置，迁移后保留以便重新加密
+func (s *KeyringStore) LegacyPassword() ([]byte, error) {
+	return s.get(".legacy")
+}
 
-	// 如果系统密钥环不可用，使用文件存储
+// ClearLegacyPassword 配置重新加密后删除旧版本的密码
+func (s *KeyringStore) ClearLegacyPassword() error {
+	return s.delete(".legacy")
+}
+
+// migrateRecords 把旧记录写入新的 verifier 和 secret
+// 旧版本 GetMasterPassword 会把保存的哈希当作密码返回，这类配置实际使用哈希加密，
+// 因此哈希同时作为 legacy 密码保留
+func migrateRecords(store PasswordStore, old, oldHash []byte, setLegacy func([]byte) error) error {
+	verifier := oldHash
+	if isHash(old) {
+		if verifier == nil {
+			verifier = old
+		}
+		if err := setLegacy(old); err != nil {
+			return err
+		}
+	} else if old != nil {
+		// 只有缓存的密码时用它生成校验记录，未开启 rememberPassword 时不再缓存
+		if verifier == nil {
+			hash, err := bcrypt.GenerateFromPassword(old, bcrypt.DefaultCost)
+			if err != nil {
+				return err
+			}
+			verifier = hash
+		}
+		if rememberPassword {
+			if err := store.SetSecret(old); err != nil {
+				return err
+			}
+		}
+	}
+
+	if verifier != nil {
+		if _, err := store.GetVerifier(); err == ErrNotFound {
+			return store.SetVerifier(verifier)
+		}
+	}
+	return nil
+}
+
+func isHash(data []byte) bool {
+	_, err := bcrypt.Cost(data)
+	return err == nil
+}
+
+// GetPasswordStore 获取密码存储实例
+func GetPasswordStore() (PasswordStore, error) {
 	fileStore, err := NewFileStore()
 	if err != nil {
 		return nil, err
 	}
-	return fileStore, nil
+
+	// 已经使用文件存储的安装继续使用文件，否则优先使用系统密钥环
+	var store PasswordStore = fileStore
+	if !fileStore.exists() {
+		keyringStore := NewKeyringStore()
+		if _, err := keyringStore.GetVerifier(); err == nil || err == ErrNotFound {
+			store = keyringStore
+		}
+	}
+
+	if s, ok := store.(legacyStore); ok {
+		if err := s.migrate(); err != nil {
+			return nil, fmt.Errorf("failed to migrate master password: %v", err)
+		}
+	}
+	return store, nil
 }
 
 // GetMasterPassword 获取主密码
@@ -86,60 +210,134 @@ func GetMasterPassword() ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize password store: %v", err)
 	}
+	return getMasterPassword(store, true)
+}
 
-	// 尝试获取已存储的密码
-	password, err := store.Get()
-	if err == nil {
-		// 如果成功获取到密码，直接返回
-		return password, nil
+// getMasterPassword 获取主密码，cached 为 false 时总是要求输入并校验
+func getMasterPassword(store PasswordStore, cached bool) ([]byte, error) {
+	// 优先使用非交互方式提供的密码，不缓存
+	if password, err := providedPassword(); err != ErrNotFound {
+		if err != nil {
+			return nil, err
+		}
+		return password, verifyPassword(store, password)
 	}
 
-	// 如果没有存储的密码，请求用户输入
-	fmt.Print("Enter master password: ")
-	password, err = terminal.ReadPassword(int(syscall.Stdin))
-	fmt.Println()
+	// 尝试获取已缓存的密码，agent 在运行时只使用 agent 中的密码
+	// 没有 agent 时只有开启 rememberPassword 才读取长期缓存
+	if cached {
+		if AgentRunning() {
+			if password, err := agentGet(); err == nil {
+				return password, nil
+			}
+		} else if rememberPassword {
+			if password, err := store.GetSecret(); err == nil {
+				return password, nil
+			}
+		}
+	}
+
+	verifier, err := store.GetVerifier()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read password: %v", err)
+		if err == ErrNotFound {
+			return nil, fmt.Errorf("master password not set, use -set-master-password first")
+		}
+		return nil, fmt.Errorf("failed to get password verifier: %v", err)
 	}
 
-	// 验证密码
-	hash, err := store.GetHash()
+	// 如果没有缓存的密码，请求用户输入
+	password, err := readPassword("Enter master password: ")
 	if err != nil {
-		return nil, fmt.Errorf("failed to get password hash: %v", err)
+		return nil, fmt.Errorf("failed to read password: %v", err)
 	}
 
-	if err := bcrypt.CompareHashAndPassword(hash, password); err != nil {
+	// 验证密码
+	if err := bcrypt.CompareHashAndPassword(verifier, password); err != nil {
 		return nil, fmt.Errorf("invalid password")
 	}
 
-	// 存储密码以供后续使用
-	if err := store.Set(password); err != nil {
+	// 缓存密码以供后续使用
+	if err := cachePassword(store, password); err != nil {
 		return nil, fmt.Errorf("failed to store password: %v", err)
 	}
 
 	return password, nil
 }
 
+// verifyPassword 有校验记录时校验非交互提供的密码
+// CI 等环境可以不设置主密码，直接用提供的密码解锁配置
+func verifyPassword(store PasswordStore, password []byte) error {
+	verifier, err := store.GetVerifier()
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get password verifier: %v", err)
+	}
+	if err := bcrypt.CompareHashAndPassword(verifier, password); err != nil {
+		return fmt.Errorf("invalid password")
+	}
+	return nil
+}
+
+// rememberPassword 没有运行 agent 时是否把主密码长期缓存到密钥环或本地文件
+var rememberPassword bool
+
+// SetRememberPassword 设置没有运行 agent 时是否长期缓存主密码，默认不缓存
+// 开启后明文的主密码会保存在密钥环或 ~/.sshw-master.secret 中，直到执行 sshw lock
+func SetRememberPassword(remember bool) {
+	rememberPassword = remember
+}
+
+// cachePassword 缓存主密码，agent 在运行时只交给 agent 保存
+// 没有 agent 时只在开启 rememberPassword 后写入本地存储，否则删除旧版本留下的缓存
+func cachePassword(store PasswordStore, password []byte) error {
+	if AgentRunning() {
+		if err := agentSet(password); err == nil {
+			return nil
+		}
+	}
+	if rememberPassword {
+		return store.SetSecret(password)
+	}
+	return store.DeleteSecret()
+}
+
 // SetMasterPassword 设置主密码
 func SetMasterPassword() error {
 	store, err := GetPasswordStore()
 	if err != nil {
 		return fmt.Errorf("failed to initialize password store: %v", err)
 	}
+	if err := setMasterPassword(store); err != nil {
+		return err
+	}
+	fmt.Println("Master password set successfully")
+	return nil
+}
 
+func setMasterPassword(store PasswordStore) error {
 	// 检查是否已设置密码
-	_, err = store.Get()
-	if err == nil {
+	if _, err := store.GetVerifier(); err == nil {
 		return fmt.Errorf("master password already set")
 	}
 
-	// 请求用户输入密码
-	fmt.Print("Enter new master password: ")
-	password, err := terminal.ReadPassword(int(syscall.Stdin))
-	fmt.Println()
+	// 请求用户输入密码，也可以非交互地提供
+	password, err := providedPassword()
+	if err == ErrNotFound {
+		password, err = readPassword("Enter new master password: ")
+	}
 	if err != nil {
 		return fmt.Errorf("failed to read password: %v", err)
 	}
+	return storePassword(store, password)
+}
+
+// storePassword 保存新密码的哈希，并替换缓存的密码
+func storePassword(store PasswordStore, password []byte) error {
+	if len(password) == 0 {
+		return fmt.Errorf("master password can not be empty")
+	}
 
 	// 生成密码哈希
 	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
@@ -148,48 +346,48 @@ func SetMasterPassword() error {
 	}
 
 	// 存储密码哈希
-	if err := store.Set(hash); err != nil {
+	if err := store.SetVerifier(hash); err != nil {
 		return fmt.Errorf("failed to store password hash: %v", err)
 	}
-
-	fmt.Println("Master password set successfully")
+	if err := cachePassword(store, password); err != nil {
+		return fmt.Errorf("failed to store password: %v", err)
+	}
 	return nil
 }
 
 // ChangeMasterPassword 修改主密码
-func ChangeMasterPassword() error {
+// rekey 在保存新密码之前调用，用于以新密码重新加密配置，返回错误时不修改主密码
+func ChangeMasterPassword(rekey func(old, new []byte) error) error {
 	store, err := GetPasswordStore()
 	if err != nil {
 		return fmt.Errorf("failed to initialize password store: %v", err)
 	}
+	if err := changeMasterPassword(store, rekey); err != nil {
+		return err
+	}
+	fmt.Println("Master password changed successfully")
+	return nil
+}
 
-	// 验证当前密码
-	_, err = GetMasterPassword()
+func changeMasterPassword(store PasswordStore, rekey func(old, new []byte) error) error {
+	// 验证当前密码，不使用缓存的密码
+	old, err := getMasterPassword(store, false)
 	if err != nil {
 		return fmt.Errorf("failed to verify current password: %v", err)
 	}
 
 	// 请求用户输入新密码
-	fmt.Print("Enter new master password: ")
-	newPassword, err := terminal.ReadPassword(int(syscall.Stdin))
-	fmt.Println()
+	newPassword, err := readPassword("Enter new master password: ")
 	if err != nil {
 		return fmt.Errorf("failed to read new password: %v", err)
 	}
 
-	// 生成新密码哈希
-	hash, err := bcrypt.GenerateFromPassword(newPassword, bcrypt.DefaultCost)
-	if err != nil {
-		return fmt.Errorf("failed to generate new password hash: %v", err)
-	}
-
-	// 存储新密码哈希
-	if err := store.Set(hash); err != nil {
-		return fmt.Errorf("failed to store new password hash: %v", err)
+	if rekey != nil {
+		if err := rekey(old, newPassword); err != nil {
+			return fmt.Errorf("failed to re-encrypt config: %v", err)
+		}
 	}
-
-	fmt.Println("Master password changed successfully")
-	return nil
+	return storePassword(store, newPassword)
 }
 
 // RemoveMasterPassword 删除主密码
@@ -198,10 +396,16 @@ func RemoveMasterPassword() error {
 	if err != nil {
 		return fmt.Errorf("failed to initialize password store: %v", err)
 	}
+	if err := removeMasterPassword(store); err != nil {
+		return err
+	}
+	fmt.Println("Master password removed successfully")
+	return nil
+}
 
+func removeMasterPassword(store PasswordStore) error {
 	// 验证当前密码
-	_, err = GetMasterPassword()
-	if err != nil {
+	if _, err := getMasterPassword(store, false); err != nil {
 		return fmt.Errorf("failed to verify current password: %v", err)
 	}
 
@@ -209,7 +413,40 @@ func RemoveMasterPassword() error {
 	if err := store.Delete(); err != nil {
 		return fmt.Errorf("failed to remove master password: %v", err)
 	}
+	if AgentRunning() {
+		if _, err := agentRequest("LOCK"); err != nil {
+			return fmt.Errorf("failed to lock agent: %v", err)
+		}
+	}
+	return nil
+}
 
-	fmt.Println("Master password removed successfully")
+// legacyPasswordStore 迁移后保留了旧版本密码的存储
+type legacyPasswordStore interface {
+	LegacyPassword() ([]byte, error)
+	ClearLegacyPassword() error
+}
+
+// LegacyPassword 返回旧版本误用作主密码的密码哈希，没有时返回 ErrNotFound
+func LegacyPassword() ([]byte, error) {
+	store, err := GetPasswordStore()
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := store.(legacyPasswordStore); ok {
+		return s.LegacyPassword()
+	}
+	return nil, ErrNotFound
+}
+
+// ClearLegacyPassword 配置已用主密码重新加密后删除旧版本的密码
+func ClearLegacyPassword() error {
+	store, err := GetPasswordStore()
+	if err != nil {
+		return err
+	}
+	if s, ok := store.(legacyPasswordStore); ok {
+		return s.ClearLegacyPassword()
+	}
 	return nil
 }
@@ -0,0 +1,316 @@
+package masterkey
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// setup 隔离 agent 和非交互方式，终端输入依次返回 answers
+func setup(t *testing.T, answers ...string) {
+	t.Helper()
+	t.Setenv("SSHW_AGENT_SOCK", filepath.Join(t.TempDir(), "agent.sock"))
+
+	oldProviders, oldRead, oldRemember := providers, readPassword, rememberPassword
+	t.Cleanup(func() {
+		providers, readPassword, rememberPassword = oldProviders, oldRead, oldRemember
+	})
+	providers, rememberPassword = nil, false
+	readPassword = func(prompt string) ([]byte, error) {
+		if len(answers) == 0 {
+			t.Fatalf("unexpected prompt %q", prompt)
+		}
+		answer := answers[0]
+		answers = answers[1:]
+		return []byte(answer), nil
+	}
+}
+
+func mustSet(t *testing.T, store PasswordStore, password string) {
+	t.Helper()
+	if err := storePassword(store, []byte(password)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetMasterPassword(t *testing.T) {
+	setup(t, "master")
+	store := NewMemoryStore()
+
+	if err := setMasterPassword(store); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := store.GetVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword(verifier, []byte("master")) != nil {
+		t.Fatal("verifier does not match the password")
+	}
+	// 默认不长期缓存主密码
+	if _, err := store.GetSecret(); err != ErrNotFound {
+		t.Fatalf("secret cached without remember: %v", err)
+	}
+
+	if err := setMasterPassword(store); err == nil || !strings.Contains(err.Error(), "already set") {
+		t.Fatalf("setting twice: got %v", err)
+	}
+	if err := storePassword(NewMemoryStore(), nil); err == nil {
+		t.Fatal("empty password should be rejected")
+	}
+}
+
+func TestSetMasterPasswordProvided(t *testing.T) {
+	setup(t)
+	SetProviders(staticProvider("from-env"))
+	store := NewMemoryStore()
+
+	if err := setMasterPassword(store); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyPassword(store, []byte("from-env")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetMasterPassword(t *testing.T) {
+	setup(t, "wrong", "master", "master")
+	store := NewMemoryStore()
+
+	if _, err := getMasterPassword(store, true); err == nil || !strings.Contains(err.Error(), "not set") {
+		t.Fatalf("without verifier: got %v", err)
+	}
+
+	mustSet(t, store, "master")
+
+	if _, err := getMasterPassword(store, true); err == nil || err.Error() != "invalid password" {
+		t.Fatalf("wrong password: got %v", err)
+	}
+	got, err := getMasterPassword(store, true)
+	if err != nil || string(got) != "master" {
+		t.Fatalf("correct password: %q, %v", got, err)
+	}
+
+	// 没有 agent 也没有开启长期缓存时每次都要输入
+	if _, err := store.GetSecret(); err != ErrNotFound {
+		t.Fatalf("secret cached without remember: %v", err)
+	}
+	if got, err := getMasterPassword(store, true); err != nil || string(got) != "master" {
+		t.Fatalf("second prompt: %q, %v", got, err)
+	}
+}
+
+func TestRememberPassword(t *testing.T) {
+	setup(t, "master", "master")
+	store := NewMemoryStore()
+	mustSet(t, store, "master")
+
+	// 开启后校验通过的密码长期缓存，之后不再询问
+	SetRememberPassword(true)
+	if _, err := getMasterPassword(store, true); err != nil {
+		t.Fatal(err)
+	}
+	if secret, _ := store.GetSecret(); string(secret) != "master" {
+		t.Fatalf("cached secret: got %q", secret)
+	}
+	if got, err := getMasterPassword(store, true); err != nil || string(got) != "master" {
+		t.Fatalf("cached password: %q, %v", got, err)
+	}
+
+	// 关闭后忽略旧的缓存，输入后删除
+	SetRememberPassword(false)
+	if got, err := getMasterPassword(store, true); err != nil || string(got) != "master" {
+		t.Fatalf("password after disabling remember: %q, %v", got, err)
+	}
+	if _, err := store.GetSecret(); err != ErrNotFound {
+		t.Fatalf("stale secret kept: %v", err)
+	}
+}
+
+func TestVerifyProvidedPassword(t *testing.T) {
+	setup(t)
+	store := NewMemoryStore()
+
+	// 没有设置主密码时直接使用提供的密码
+	if err := verifyPassword(store, []byte("any")); err != nil {
+		t.Fatal(err)
+	}
+
+	mustSet(t, store, "master")
+	SetProviders(staticProvider("wrong"))
+	if _, err := getMasterPassword(store, true); err == nil {
+		t.Fatal("wrong provided password accepted")
+	}
+	SetProviders(staticProvider("master"))
+	if got, err := getMasterPassword(store, true); err != nil || string(got) != "master" {
+		t.Fatalf("provided password: %q, %v", got, err)
+	}
+}
+
+func TestChangeMasterPassword(t *testing.T) {
+	setup(t, "master", "changed")
+	store := NewMemoryStore()
+	mustSet(t, store, "master")
+
+	var gotOld, gotNew string
+	err := changeMasterPassword(store, func(old, new []byte) error {
+		gotOld, gotNew = string(old), string(new)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotOld != "master" || gotNew != "changed" {
+		t.Fatalf("rekey called with %q, %q", gotOld, gotNew)
+	}
+	if err := verifyPassword(store, []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetSecret(); err != ErrNotFound {
+		t.Fatalf("secret cached without remember: %v", err)
+	}
+}
+
+func TestChangeMasterPasswordRekeyFails(t *testing.T) {
+	setup(t, "master", "changed")
+	store := NewMemoryStore()
+	mustSet(t, store, "master")
+
+	err := changeMasterPassword(store, func(old, new []byte) error {
+		return fmt.Errorf("disk full")
+	})
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("got %v", err)
+	}
+	// 重新加密失败时保留旧密码
+	if err := verifyPassword(store, []byte("master")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoveMasterPassword(t *testing.T) {
+	setup(t, "wrong", "master")
+	store := NewMemoryStore()
+	mustSet(t, store, "master")
+
+	if err := removeMasterPassword(store); err == nil {
+		t.Fatal("removed with a wrong password")
+	}
+	if _, err := store.GetVerifier(); err != nil {
+		t.Fatal("verifier removed after a failed attempt")
+	}
+
+	if err := removeMasterPassword(store); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetVerifier(); err != ErrNotFound {
+		t.Fatalf("verifier: got %v, want %v", err, ErrNotFound)
+	}
+	if _, err := store.GetSecret(); err != ErrNotFound {
+		t.Fatalf("secret: got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestMigrateRecords(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("master"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHash, _ := bcrypt.GenerateFromPassword([]byte("other"), bcrypt.MinCost)
+
+	tests := []struct {
+		name         string
+		remember     bool
+		old, oldHash []byte
+		verifier     []byte
+		secret       []byte
+		legacy       []byte
+	}{
+		{name: "nothing"},
+		{name: "cached password with hash", old: []byte("master"), oldHash: hash, verifier: hash},
+		{name: "remember cached password", remember: true, old: []byte("master"), oldHash: hash, verifier: hash, secret: []byte("master")},
+		{name: "hash saved as password", old: hash, verifier: hash, legacy: hash},
+		{name: "hash in both records", old: otherHash, oldHash: hash, verifier: hash, legacy: otherHash},
+		{name: "only hash", oldHash: hash, verifier: hash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			SetRememberPassword(tt.remember)
+			store := NewMemoryStore()
+			var legacy []byte
+			err := migrateRecords(store, tt.old, tt.oldHash, func(b []byte) error {
+				legacy = b
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifier, _ := store.GetVerifier()
+			secret, _ := store.GetSecret()
+			if !bytes.Equal(verifier, tt.verifier) {
+				t.Errorf("verifier: got %q, want %q", verifier, tt.verifier)
+			}
+			if !bytes.Equal(secret, tt.secret) {
+				t.Errorf("secret: got %q, want %q", secret, tt.secret)
+			}
+			if !bytes.Equal(legacy, tt.legacy) {
+				t.Errorf("legacy: got %q, want %q", legacy, tt.legacy)
+			}
+		})
+	}
+}
+
+func TestMigrateCachedPasswordOnly(t *testing.T) {
+	setup(t)
+	store := NewMemoryStore()
+
+	// 只有缓存的明文密码时生成校验记录，不再保留明文
+	if err := migrateRecords(store, []byte("master"), nil, func([]byte) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyPassword(store, []byte("master")); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyPassword(store, []byte("other")); err == nil {
+		t.Fatal("verifier accepts another password")
+	}
+	if _, err := store.GetSecret(); err != ErrNotFound {
+		t.Fatalf("plaintext secret kept: %v", err)
+	}
+}
+
+func TestMigrateKeepsVerifier(t *testing.T) {
+	store := NewMemoryStore()
+	mustSetVerifier(t, store, "current")
+	old, _ := bcrypt.GenerateFromPassword([]byte("old"), bcrypt.MinCost)
+
+	if err := migrateRecords(store, nil, old, func([]byte) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	verifier, _ := store.GetVerifier()
+	if bcrypt.CompareHashAndPassword(verifier, []byte("current")) != nil {
+		t.Fatal("existing verifier overwritten by migration")
+	}
+}
+
+func mustSetVerifier(t *testing.T, store PasswordStore, password string) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetVerifier(hash)
+}
+
+// staticProvider 总是返回固定密码
+type staticProvider string
+
+func (p staticProvider) Password() ([]byte, error) {
+	return []byte(p), nil
+}
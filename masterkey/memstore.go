@@ -0,0 +1,72 @@
+package masterkey
+
+import "sync"
+
+// MemoryStore 提供基于内存的密码存储，进程退出后即失效
+type MemoryStore struct {
+	mu       sync.Mutex
+	verifier []byte
+	secret   []byte
+}
+
+// NewMemoryStore 创建新的内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) GetVerifier() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.verifier == nil {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, s.verifier...), nil
+}
+
+func (s *MemoryStore) SetVerifier(verifier []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.verifier = append([]byte{}, verifier...)
+	return nil
+}
+
+func (s *MemoryStore) GetSecret() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.secret == nil {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, s.secret...), nil
+}
+
+func (s *MemoryStore) SetSecret(secret []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secret = append([]byte{}, secret...)
+	return nil
+}
+
+// DeleteSecret 清除缓存的密码，并覆盖内存中的内容
+func (s *MemoryStore) DeleteSecret() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wipe(s.secret)
+	s.secret = nil
+	return nil
+}
+
+func (s *MemoryStore) Delete() error {
+	if err := s.DeleteSecret(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.verifier = nil
+	return nil
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,28 @@
+package masterkey
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerUID 通过 LOCAL_PEERCRED 获取连接方的 uid
+func peerUID(conn *net.UnixConn) (uint32, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		cred *unix.Xucred
+		cerr error
+	)
+	if err := raw.Control(func(fd uintptr) {
+		cred, cerr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	}); err != nil {
+		return 0, err
+	}
+	if cerr != nil {
+		return 0, cerr
+	}
+	return cred.Uid, nil
+}
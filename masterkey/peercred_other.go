@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package masterkey
+
+import "net"
+
+// peerUID 其他系统不检查连接方，依赖 socket 的权限
+func peerUID(conn *net.UnixConn) (uint32, error) {
+	return 0, errNoPeerCred
+}
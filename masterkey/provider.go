@@ -0,0 +1,146 @@
+package masterkey
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+)
+
+// PasswordProvider 非交互地提供主密码，用于脚本和 CI
+// 没有可用的密码时返回 ErrNotFound，其他错误会中止获取主密码
+type PasswordProvider interface {
+	Password() ([]byte, error)
+}
+
+var providers []PasswordProvider
+
+// SetProviders 设置非交互获取主密码的方式，按顺序使用第一个提供了密码的方式
+func SetProviders(p ...PasswordProvider) {
+	providers = p
+}
+
+// providedPassword 从非交互方式获取主密码
+func providedPassword() ([]byte, error) {
+	for _, p := range providers {
+		password, err := p.Password()
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(password) == 0 {
+			return nil, fmt.Errorf("%s returned an empty password", p)
+		}
+		return password, nil
+	}
+	return nil, ErrNotFound
+}
+
+// EnvProvider 从环境变量读取主密码
+type EnvProvider string
+
+func (p EnvProvider) Password() ([]byte, error) {
+	v, ok := os.LookupEnv(string(p))
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return []byte(v), nil
+}
+
+func (p EnvProvider) String() string {
+	return "environment variable " + string(p)
+}
+
+// fdProvider 从文件描述符读取主密码，只读取一次
+type fdProvider struct {
+	fd uintptr
+
+	once     sync.Once
+	password []byte
+	err      error
+}
+
+// NewFDProvider 从文件描述符读取主密码，0 表示标准输入
+func NewFDProvider(fd uintptr) PasswordProvider {
+	return &fdProvider{fd: fd}
+}
+
+func (p *fdProvider) Password() ([]byte, error) {
+	p.once.Do(func() {
+		f := os.Stdin
+		if p.fd != 0 {
+			if f = os.NewFile(p.fd, fmt.Sprintf("fd%d", p.fd)); f == nil {
+				p.err = fmt.Errorf("invalid file descriptor %d", p.fd)
+				return
+			}
+			defer f.Close()
+		}
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			p.err = fmt.Errorf("failed to read password from fd %d: %v", p.fd, err)
+			return
+		}
+		p.password = trimNewline(b)
+	})
+	return p.password, p.err
+}
+
+func (p *fdProvider) String() string {
+	return fmt.Sprintf("file descriptor %d", p.fd)
+}
+
+// FileProvider 从密钥文件读取主密码，文件只能由当前用户访问
+type FileProvider string
+
+func (p FileProvider) Password() ([]byte, error) {
+	fi, err := os.Stat(string(p))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password file: %v", err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("password file %s is accessible by others, chmod 600 it", string(p))
+	}
+	b, err := ioutil.ReadFile(string(p))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password file: %v", err)
+	}
+	return trimNewline(b), nil
+}
+
+func (p FileProvider) String() string {
+	return "password file " + string(p)
+}
+
+// CommandProvider 执行外部命令，使用其标准输出作为主密码，如 pass show sshw
+type CommandProvider string
+
+func (p CommandProvider) Password() ([]byte, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", string(p))
+	} else {
+		cmd = exec.Command("sh", "-c", string(p))
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	b, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("password command %q failed: %v", string(p), err)
+	}
+	return trimNewline(b), nil
+}
+
+func (p CommandProvider) String() string {
+	return "password command"
+}
+
+// trimNewline 去掉末尾的换行，保留密码中的其他空白
+func trimNewline(b []byte) []byte {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	return bytes.TrimSuffix(b, []byte("\r"))
+}
@@ -0,0 +1,103 @@
+package masterkey
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTrimNewline(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"secret", "secret"},
+		{"secret\n", "secret"},
+		{"secret\r\n", "secret"},
+		{"secret\n\n", "secret\n"},
+		{" secret \n", " secret "},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := string(trimNewline([]byte(tt.in))); got != tt.want {
+			t.Errorf("trimNewline(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	t.Setenv("SSHW_TEST_PASSWORD", "")
+	os.Unsetenv("SSHW_TEST_PASSWORD")
+	if _, err := EnvProvider("SSHW_TEST_PASSWORD").Password(); err != ErrNotFound {
+		t.Fatalf("unset variable: got %v, want %v", err, ErrNotFound)
+	}
+	t.Setenv("SSHW_TEST_PASSWORD", "from env\n")
+	if got, err := EnvProvider("SSHW_TEST_PASSWORD").Password(); err != nil || string(got) != "from env\n" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(p, []byte("from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := FileProvider(p).Password(); err != nil || string(got) != "from file" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	if _, err := FileProvider(filepath.Join(dir, "missing")).Password(); err == nil || err == ErrNotFound {
+		t.Fatalf("missing file: got %v", err)
+	}
+
+	if runtime.GOOS != "windows" {
+		os.Chmod(p, 0644)
+		if _, err := FileProvider(p).Password(); err == nil || !strings.Contains(err.Error(), "chmod 600") {
+			t.Fatalf("readable by others: got %v", err)
+		}
+	}
+}
+
+func TestCommandProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	if got, err := CommandProvider("echo from command").Password(); err != nil || string(got) != "from command" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if got, err := CommandProvider("printf 'no newline'").Password(); err != nil || string(got) != "no newline" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	_, err := CommandProvider("echo partial; exit 3").Password()
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("failing command: got %v", err)
+	}
+}
+
+func TestProvidedPassword(t *testing.T) {
+	setup(t)
+
+	if _, err := providedPassword(); err != ErrNotFound {
+		t.Fatalf("no providers: got %v, want %v", err, ErrNotFound)
+	}
+
+	// 按顺序使用第一个提供了密码的方式
+	t.Setenv("SSHW_TEST_PASSWORD", "")
+	os.Unsetenv("SSHW_TEST_PASSWORD")
+	SetProviders(EnvProvider("SSHW_TEST_PASSWORD"), staticProvider("second"), staticProvider("third"))
+	if got, err := providedPassword(); err != nil || string(got) != "second" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	// 出错时中止，不使用后面的方式
+	SetProviders(CommandProvider("exit 1"), staticProvider("second"))
+	if _, err := providedPassword(); err == nil || err == ErrNotFound {
+		t.Fatalf("failing provider: got %v", err)
+	}
+
+	SetProviders(staticProvider(""))
+	if _, err := providedPassword(); err == nil || !strings.Contains(err.Error(), "empty password") {
+		t.Fatalf("empty password: got %v", err)
+	}
+}
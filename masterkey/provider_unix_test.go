@@ -0,0 +1,37 @@
+//go:build unix
+
+package masterkey
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// pipeFD 返回写入了 content 的管道读端，文件描述符由读取方关闭
+func pipeFD(t *testing.T, content string) uintptr {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.Write([]byte(content))
+	w.Close()
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uintptr(fd)
+}
+
+func TestFDProvider(t *testing.T) {
+	p := NewFDProvider(pipeFD(t, "from fd\r\n"))
+	if got, err := p.Password(); err != nil || string(got) != "from fd" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	// 只读取一次，之后返回同一个密码
+	if got, err := p.Password(); err != nil || string(got) != "from fd" {
+		t.Fatalf("second read: %q, %v", got, err)
+	}
+}
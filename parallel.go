@@ -0,0 +1,99 @@
+package sshw
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 同时执行命令的最大节点数
+var maxParallel = 10
+
+// ExecResult 一个节点上命令的执行结果
+type ExecResult struct {
+	Node *Node
+	Err  error
+}
+
+// ExecAll 在多个节点上并行执行命令，输出的每一行前加上节点的别名或路径
+// 建立连接时可能需要输入密码或确认主机密钥，连接依次建立，命令并行执行，同时最多在 maxParallel 个节点上执行
+// 某个节点失败时其他节点继续执行，错误记录在各自的结果中
+func ExecAll(nodes []*Node, cmd string, out io.Writer) []ExecResult {
+	width := 0
+	for _, n := range nodes {
+		if w := len(nodeKey(n)); w > width {
+			width = w
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		dialMu  sync.Mutex
+		writeMu sync.Mutex
+		slots   = make(chan struct{}, maxParallel)
+	)
+	results := make([]ExecResult, len(nodes))
+	for i, n := range nodes {
+		results[i].Node = n
+		wg.Add(1)
+		go func(r *ExecResult) {
+			defer wg.Done()
+			prefix := fmt.Sprintf("%-*s | ", width, nodeKey(r.Node))
+			stdout := &prefixWriter{w: out, mu: &writeMu, prefix: prefix}
+			stderr := &prefixWriter{w: out, mu: &writeMu, prefix: prefix}
+			defer stdout.Flush()
+			defer stderr.Flush()
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			dialMu.Lock()
+			c := NewClient(r.Node).(*defaultClient)
+			client, err := c.dial()
+			dialMu.Unlock()
+			if err != nil {
+				r.Err = err
+				return
+			}
+			defer client.Close()
+			r.Err = run(client, cmd, stdout, stderr)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// prefixWriter 按行写入输出，每行前加上前缀，多个 writer 共享锁避免输出交错
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.writeLine(p.buf[:i])
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush 写入最后不以换行结束的输出
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(p.buf)
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.w, "%s%s\n", p.prefix, bytes.TrimSuffix(line, []byte("\r")))
+}
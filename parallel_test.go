@@ -0,0 +1,188 @@
+package sshw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// serveExec 在本地端口运行只支持 exec 的 ssh 服务器，exec 返回命令的输出和退出状态
+func serveExec(t *testing.T, exec func(cmd string, out io.Writer) uint32) int {
+	t.Helper()
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(newTestSigner(t))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				defer sc.Close()
+				go ssh.DiscardRequests(reqs)
+				for nc := range chans {
+					ch, reqs, err := nc.Accept()
+					if err != nil {
+						continue
+					}
+					go func() {
+						defer ch.Close()
+						for req := range reqs {
+							if req.Type != "exec" {
+								req.Reply(false, nil)
+								continue
+							}
+							req.Reply(true, nil)
+							cmd := string(req.Payload[4:])
+							status := make([]byte, 4)
+							binary.BigEndian.PutUint32(status, exec(cmd, ch))
+							ch.SendRequest("exit-status", false, status)
+							return
+						}
+					}()
+				}
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// execNodes 返回连接到测试服务器的节点
+func execNodes(t *testing.T, port int, names ...string) []*Node {
+	t.Helper()
+	saveState(t)
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	var nodes []*Node
+	for _, name := range names {
+		nodes = append(nodes, &Node{Name: name, Host: "127.0.0.1", Port: port, User: "alice", Password: "pw"})
+	}
+	return nodes
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	p := &prefixWriter{w: &out, mu: new(sync.Mutex), prefix: "web | "}
+	for _, chunk := range []string{"hel", "lo\nwor", "ld\r\n", "\n", "a\nb\n", "no newline"} {
+		if n, err := p.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("write %q: %d, %v", chunk, n, err)
+		}
+	}
+	want := "web | hello\nweb | world\nweb | \nweb | a\nweb | b\n"
+	if out.String() != want {
+		t.Fatalf("before flush:\n got %q\nwant %q", out.String(), want)
+	}
+	p.Flush()
+	p.Flush()
+	want += "web | no newline\n"
+	if out.String() != want {
+		t.Fatalf("after flush:\n got %q\nwant %q", out.String(), want)
+	}
+}
+
+func TestExecAll(t *testing.T) {
+	port := serveExec(t, func(cmd string, out io.Writer) uint32 {
+		fmt.Fprint(out, "line 1\nline 2\npartial")
+		if cmd == "fail" {
+			return 3
+		}
+		return 0
+	})
+	nodes := execNodes(t, port, "a", "bbb")
+	// 无法连接的节点不影响其他节点
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	down := &Node{Name: "down", Host: "127.0.0.1", Port: closed.Addr().(*net.TCPAddr).Port, User: "alice", Password: "pw"}
+	nodes = append(nodes, down)
+
+	var out bytes.Buffer
+	results := ExecAll(nodes, "uptime", &out)
+	if len(results) != 3 {
+		t.Fatalf("got %d results", len(results))
+	}
+	for i, r := range results {
+		if r.Node != nodes[i] {
+			t.Fatalf("result %d is for %s", i, r.Node.Name)
+		}
+	}
+	if results[0].Err != nil || results[1].Err != nil || results[2].Err == nil {
+		t.Fatalf("errors: %v, %v, %v", results[0].Err, results[1].Err, results[2].Err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	sort.Strings(lines)
+	want := []string{
+		"a    | line 1", "a    | line 2", "a    | partial",
+		"bbb  | line 1", "bbb  | line 2", "bbb  | partial",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("output:\n%s", out.String())
+	}
+
+	// 命令失败时返回退出状态，其他节点照常完成
+	results = ExecAll(nodes[:2], "fail", io.Discard)
+	for _, r := range results {
+		if e, ok := r.Err.(*ssh.ExitError); !ok || e.ExitStatus() != 3 {
+			t.Fatalf("%s: got %v, want exit status 3", r.Node.Name, r.Err)
+		}
+	}
+}
+
+func TestExecAllLimit(t *testing.T) {
+	old := maxParallel
+	t.Cleanup(func() { maxParallel = old })
+	maxParallel = 3
+
+	var running, peak int32
+	port := serveExec(t, func(cmd string, out io.Writer) uint32 {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return 0
+	})
+	var names []string
+	for i := 0; i < 8; i++ {
+		names = append(names, "web"+strconv.Itoa(i))
+	}
+
+	for _, r := range ExecAll(execNodes(t, port, names...), "sleep", io.Discard) {
+		if r.Err != nil {
+			t.Fatalf("%s: %v", r.Node.Name, r.Err)
+		}
+	}
+	if peak > int32(maxParallel) || peak < 2 {
+		t.Fatalf("%d commands ran at the same time, limit %d", peak, maxParallel)
+	}
+}
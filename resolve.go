@@ -0,0 +1,110 @@
+package sshw
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNodeNotFound 没有节点匹配别名或路径
+var ErrNodeNotFound = errors.New("node not found")
+
+// AmbiguousError 别名或路径匹配到多个节点
+type AmbiguousError struct {
+	Target     string
+	Candidates []*Node
+}
+
+func (e *AmbiguousError) Error() string {
+	paths := make([]string, len(e.Candidates))
+	for i, n := range e.Candidates {
+		paths[i] = n.Path()
+		// 同名节点通过主机区分
+		if host := n.GetMaskedHost(); host != "" {
+			paths[i] += " (" + host + ")"
+		}
+	}
+	return fmt.Sprintf("%q matches multiple nodes: %s", e.Target, strings.Join(paths, ", "))
+}
+
+// Resolve 按别名或路径查找节点，依次尝试：
+// 完整别名、分组路径 group/sub/name（每一级优先完全匹配，否则使用唯一的名称前缀）、别名前缀
+// 某一步匹配到多个节点时返回 AmbiguousError，找到的可能是分组
+func Resolve(nodes []*Node, target string) (*Node, error) {
+	if strings.Trim(target, "/") == "" {
+		return nil, ErrNodeNotFound
+	}
+	all := allNodes(nodes)
+	steps := []func() []*Node{
+		func() []*Node {
+			return filterNodes(all, func(n *Node) bool { return n.Alias == target })
+		},
+		func() []*Node {
+			return matchPath(nodes, strings.Split(strings.Trim(target, "/"), "/"))
+		},
+		func() []*Node {
+			return filterNodes(all, func(n *Node) bool {
+				return n.Alias != "" && strings.HasPrefix(strings.ToLower(n.Alias), strings.ToLower(target))
+			})
+		},
+	}
+	for _, step := range steps {
+		switch found := step(); len(found) {
+		case 0:
+			continue
+		case 1:
+			return found[0], nil
+		default:
+			return nil, &AmbiguousError{Target: target, Candidates: found}
+		}
+	}
+	return nil, ErrNodeNotFound
+}
+
+// matchPath 逐级匹配路径中的名称，同一级有完全匹配的名称时不再使用前缀匹配
+func matchPath(nodes []*Node, segs []string) []*Node {
+	if len(segs) == 0 || segs[0] == "" {
+		return nil
+	}
+	var exact, prefix []*Node
+	for _, n := range nodes {
+		if n.Name == segs[0] {
+			exact = append(exact, n)
+		} else if strings.HasPrefix(strings.ToLower(n.Name), strings.ToLower(segs[0])) {
+			prefix = append(prefix, n)
+		}
+	}
+	candidates := exact
+	if len(candidates) == 0 {
+		candidates = prefix
+	}
+	if len(segs) == 1 {
+		return candidates
+	}
+
+	var found []*Node
+	for _, c := range candidates {
+		found = append(found, matchPath(c.Children, segs[1:])...)
+	}
+	return found
+}
+
+// allNodes 返回节点树中的所有节点，包括分组
+func allNodes(nodes []*Node) []*Node {
+	var all []*Node
+	for _, n := range nodes {
+		all = append(all, n)
+		all = append(all, allNodes(n.Children)...)
+	}
+	return all
+}
+
+func filterNodes(nodes []*Node, match func(n *Node) bool) []*Node {
+	var found []*Node
+	for _, n := range nodes {
+		if match(n) {
+			found = append(found, n)
+		}
+	}
+	return found
+}
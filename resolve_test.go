@@ -0,0 +1,99 @@
+package sshw
+
+import (
+	"testing"
+)
+
+// resolveTree 包含深层分组、同名节点和别名的节点树
+func resolveTree() []*Node {
+	nodes := []*Node{
+		{Name: "prod", Children: []*Node{
+			{Name: "eu", Children: []*Node{
+				{Name: "web", Children: []*Node{
+					{Name: "web-1", Host: "10.0.1.1", Alias: "euweb1"},
+					{Name: "web-2", Host: "10.0.1.2", Alias: "euweb2"},
+				}},
+				{Name: "db", Host: "10.0.1.10", Alias: "eudb"},
+			}},
+			{Name: "eu-west", Children: []*Node{
+				{Name: "db", Host: "10.0.3.10", ShowHost: true},
+			}},
+			{Name: "us", Children: []*Node{
+				{Name: "web", Children: []*Node{
+					{Name: "web-1", Host: "10.0.2.1", ShowHost: true},
+				}},
+				{Name: "db", Host: "10.0.2.10", ShowHost: true, MaskHost: true},
+			}},
+		}},
+		{Name: "production-legacy", Host: "10.9.9.9"},
+		{Name: "staging", Children: []*Node{
+			{Name: "web", Host: "10.1.0.1"},
+			{Name: "webmail", Host: "10.1.0.2"},
+		}},
+		// 别名与其他节点的路径冲突时别名优先
+		{Name: "jump", Host: "10.2.0.1", Alias: "staging/web"},
+	}
+	linkParents(nodes, nil)
+	return nodes
+}
+
+func TestResolve(t *testing.T) {
+	nodes := resolveTree()
+
+	tests := []struct {
+		target string
+		want   string
+		err    string
+	}{
+		// 完整别名
+		{target: "eudb", want: "prod/eu/db"},
+		{target: "staging/web", want: "jump"},
+		// 深层路径，首尾的 / 被忽略
+		{target: "prod/eu/web/web-1", want: "prod/eu/web/web-1"},
+		{target: "/prod/us/db/", want: "prod/us/db"},
+		// 分组也可以被找到
+		{target: "prod/eu/web", want: "prod/eu/web"},
+		// 每一级使用唯一的前缀，不区分大小写
+		{target: "prod/E/w/web-2", want: "prod/eu/web/web-2"},
+		{target: "PROD/us/web/w", want: "prod/us/web/web-1"},
+		// 完全匹配优先于前缀：prod 不会匹配 production-legacy
+		{target: "prod", want: "prod"},
+		{target: "produ", want: "production-legacy"},
+		{target: "staging/webm", want: "staging/webmail"},
+		// 别名前缀
+		{target: "EUWEB2", want: "prod/eu/web/web-2"},
+
+		// 没有匹配和有歧义的目标
+		{target: "prod/e/db/x", err: "node not found"},
+		{target: "missing", err: "node not found"},
+		{target: "", err: "node not found"},
+		{target: "/", err: "node not found"},
+		{target: "prod/eu/web/web", err: `"prod/eu/web/web" matches multiple nodes: prod/eu/web/web-1, prod/eu/web/web-2`},
+		{target: "prod//db", err: "node not found"},
+		{target: "staging/we", err: `"staging/we" matches multiple nodes: staging/web, staging/webmail`},
+		// 前缀匹配到多个分组时，每个分组下的匹配都是候选，显示主机以便区分同名节点
+		{target: "prod/e/db", err: `"prod/e/db" matches multiple nodes: prod/eu/db, prod/eu-west/db (10.0.3.10)`},
+		{target: "prod/*/db", err: "node not found"},
+		{target: "prod/eu/db", want: "prod/eu/db"},
+		{target: "p/eu-/db", want: "prod/eu-west/db"},
+		{target: "prod/u/db", want: "prod/us/db"},
+		{target: "euweb", err: `"euweb" matches multiple nodes: prod/eu/web/web-1, prod/eu/web/web-2`},
+	}
+
+	for _, tt := range tests {
+		got, err := Resolve(nodes, tt.target)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Resolve(%q): got %v, %v, want error %q", tt.target, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", tt.target, err)
+			continue
+		}
+		if got.Path() != tt.want {
+			t.Errorf("Resolve(%q) = %s, want %s", tt.target, got.Path(), tt.want)
+		}
+	}
+}
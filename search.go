@@ -0,0 +1,191 @@
+package sshw
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// 匹配得分
+const (
+	scoreMatch       = 16
+	scoreConsecutive = 8
+	scoreBoundary    = 8
+	scoreFirstChar   = 8
+	scoreExact       = 32
+	penaltyGap       = 1
+)
+
+// searchField 参与搜索的字段
+type searchField struct {
+	name   string
+	weight int
+	get    func(n *Node) []string
+}
+
+var (
+	// searchFields 可以参与搜索的字段及权重，别名和标签比名称和路径更重要
+	searchFields = []searchField{
+		{"alias", 3, func(n *Node) []string { return []string{n.Alias} }},
+		{"tags", 3, func(n *Node) []string { return n.AllTags() }},
+		{"name", 2, func(n *Node) []string { return []string{n.Name} }},
+		{"path", 1, func(n *Node) []string { return []string{n.Path()} }},
+		{"user", 1, func(n *Node) []string { return []string{n.User} }},
+		{"host", 1, func(n *Node) []string { return []string{n.Host} }},
+	}
+
+	// 选择列表默认搜索的字段
+	defaultPickerFields = []string{"name", "user", "host", "tags"}
+)
+
+// enabledFields 返回 ui.search_fields 中设置的字段，未设置时返回 defaults，defaults 为空表示全部字段
+func enabledFields(defaults []string) []searchField {
+	names := UI().SearchFields
+	if len(names) == 0 {
+		names = defaults
+	}
+	if len(names) == 0 {
+		return searchFields
+	}
+	var fields []searchField
+	for _, f := range searchFields {
+		for _, name := range names {
+			if f.name == name {
+				fields = append(fields, f)
+			}
+		}
+	}
+	return fields
+}
+
+// SearchText 返回选择列表中用于搜索的内容
+func SearchText(n *Node) string {
+	var values []string
+	for _, f := range enabledFields(defaultPickerFields) {
+		values = append(values, f.get(n)...)
+	}
+	return strings.Join(values, " ")
+}
+
+// SearchResult 搜索结果
+type SearchResult struct {
+	Node  *Node
+	Score int
+}
+
+// Leaves 返回节点树中所有可以连接的节点
+func Leaves(nodes []*Node) []*Node {
+	var leaves []*Node
+	for _, n := range nodes {
+		if len(n.Children) > 0 {
+			leaves = append(leaves, Leaves(n.Children)...)
+		} else if n.Host != "" {
+			leaves = append(leaves, n)
+		}
+	}
+	return leaves
+}
+
+// Search 在整个节点树中模糊搜索，按得分从高到低排序
+// 查询按空格分成多个词，每个词都需要匹配某个字段；包含大写字母的词区分大小写
+// tag:prod 形式的词只保留包含该标签的节点
+func Search(nodes []*Node, query string) []*SearchResult {
+	tags, terms := SplitTags(query)
+	fields := enabledFields(nil)
+	var results []*SearchResult
+	for _, n := range Leaves(nodes) {
+		if !n.HasTags(tags) {
+			continue
+		}
+		score, ok := matchNode(n, terms, fields)
+		if ok {
+			results = append(results, &SearchResult{Node: n, Score: score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Node.Path() < results[j].Node.Path()
+	})
+	return results
+}
+
+func matchNode(n *Node, terms []string, fields []searchField) (int, bool) {
+	total := 0
+	for _, term := range terms {
+		best := -1
+		for _, f := range fields {
+			for _, v := range f.get(n) {
+				if s, ok := fuzzyScore(v, term); ok && s*f.weight > best {
+					best = s * f.weight
+				}
+			}
+		}
+		if best < 0 {
+			return 0, false
+		}
+		total += best
+	}
+	return total, true
+}
+
+// fuzzyScore 按顺序匹配 pattern 的每个字符，连续匹配、单词开头和完全匹配得分更高
+func fuzzyScore(text, pattern string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+	if text == "" {
+		return 0, false
+	}
+	caseSensitive := strings.IndexFunc(pattern, unicode.IsUpper) >= 0
+	t, p := []rune(text), []rune(pattern)
+	if !caseSensitive {
+		t, p = []rune(strings.ToLower(text)), []rune(strings.ToLower(pattern))
+	}
+
+	// 从每个可能的起点贪心匹配，取最高分
+	best, found := 0, false
+	for start := 0; start < len(t); start++ {
+		if t[start] != p[0] {
+			continue
+		}
+		score, ok := scoreFrom(t, p, start)
+		if ok && (!found || score > best) {
+			best, found = score, true
+		}
+	}
+	if found && len(t) == len(p) && string(t) == string(p) {
+		best += scoreExact
+	}
+	return best, found
+}
+
+func scoreFrom(t, p []rune, start int) (int, bool) {
+	score, last, pi := 0, -1, 0
+	for i := start; i < len(t) && pi < len(p); i++ {
+		if t[i] != p[pi] {
+			continue
+		}
+		score += scoreMatch
+		if i == 0 {
+			score += scoreFirstChar
+		} else if isBoundary(t[i-1]) {
+			score += scoreBoundary
+		}
+		if last >= 0 {
+			if i == last+1 {
+				score += scoreConsecutive
+			} else {
+				score -= (i - last - 1) * penaltyGap
+			}
+		}
+		last = i
+		pi++
+	}
+	return score, pi == len(p)
+}
+
+func isBoundary(r rune) bool {
+	return r == '/' || r == '-' || r == '_' || r == '.' || r == ' ' || r == '@'
+}
@@ -0,0 +1,152 @@
+package sshw
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// searchTree 搜索测试使用的节点树
+func searchTree() []*Node {
+	nodes := []*Node{
+		{Name: "prod", Tags: []string{"prod"}, Children: []*Node{
+			{Name: "web-1", Alias: "pweb", Host: "10.0.0.1", User: "deploy"},
+			{Name: "db", Host: "10.0.0.2", User: "postgres", Tags: []string{"database"}},
+			{Name: "empty"},
+		}},
+		{Name: "dev", Children: []*Node{
+			{Name: "web-1", Host: "10.1.0.1", Tags: []string{"staging"}},
+		}},
+		{Name: "Bastion", Host: "bastion.example.com"},
+	}
+	linkParents(nodes, nil)
+	return nodes
+}
+
+func resultPaths(results []*SearchResult) []string {
+	var paths []string
+	for _, r := range results {
+		paths = append(paths, r.Node.Path())
+	}
+	return paths
+}
+
+func TestSearch(t *testing.T) {
+	saveState(t)
+	nodes := searchTree()
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"pweb", []string{"prod/web-1"}},
+		// 别名 pweb 的权重高于名称
+		{"web", []string{"prod/web-1", "dev/web-1"}},
+		// 得分相同时按路径排序
+		{"web-1", []string{"dev/web-1", "prod/web-1"}},
+		{"web tag:prod", []string{"prod/web-1"}},
+		{"TAG:prod", []string{"prod/db", "prod/web-1"}},
+		{"tag:prod,database", []string{"prod/db"}},
+		{"tag:staging tag:prod", nil},
+		{"postgres", []string{"prod/db"}},
+		{"10.1.0", []string{"dev/web-1"}},
+		{"web postgres", nil},
+		// 包含大写字母时区分大小写
+		{"bastion", []string{"Bastion"}},
+		{"Bastion", []string{"Bastion"}},
+		{"BASTION", nil},
+		{"empty", nil},
+		{"nomatch", nil},
+	}
+	for _, tt := range tests {
+		if got := resultPaths(Search(nodes, tt.query)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// 空查询返回所有可以连接的节点
+	if got := len(Search(nodes, "")); got != 4 {
+		t.Errorf("empty query: got %d results, want 4", got)
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	saveState(t)
+	nodes := []*Node{
+		{Name: "mysql-web", Host: "h1"},
+		{Name: "web", Host: "h2"},
+		{Name: "w-e-b", Host: "h3"},
+		{Name: "other", Host: "h4", Alias: "web"},
+	}
+	linkParents(nodes, nil)
+
+	// 别名权重最高，完全匹配高于单词开头，连续匹配高于分散匹配
+	want := []string{"other", "web", "mysql-web", "w-e-b"}
+	if got := resultPaths(Search(nodes, "web")); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ranking: got %v, want %v", got, want)
+	}
+}
+
+func TestSearchFields(t *testing.T) {
+	saveState(t)
+	nodes := searchTree()
+
+	uiConfig = &UIConfig{SearchFields: []string{"host"}}
+	if got := resultPaths(Search(nodes, "pweb")); got != nil {
+		t.Errorf("alias searched when only host is enabled: %v", got)
+	}
+	if got := resultPaths(Search(nodes, "10.0.0.2")); !reflect.DeepEqual(got, []string{"prod/db"}) {
+		t.Errorf("host search: %v", got)
+	}
+
+	uiConfig = nil
+	db := nodes[0].Children[1]
+	if got := SearchText(db); got != "database prod db postgres 10.0.0.2" {
+		t.Errorf("default picker text: %q", got)
+	}
+	uiConfig = &UIConfig{SearchFields: []string{"path", "alias"}}
+	if got := SearchText(nodes[0].Children[0]); got != "pweb prod/web-1" {
+		t.Errorf("picker text with search_fields: %q", got)
+	}
+}
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		text, pattern string
+		ok            bool
+	}{
+		{"web-1", "", true},
+		{"", "w", false},
+		{"web-1", "w1", true},
+		{"web-1", "1w", false},
+		{"WEB", "web", true},
+		{"web", "Web", false},
+		{"数据库-主", "主", true},
+	}
+	for _, tt := range tests {
+		if _, ok := fuzzyScore(tt.text, tt.pattern); ok != tt.ok {
+			t.Errorf("fuzzyScore(%q, %q) matched %v, want %v", tt.text, tt.pattern, ok, tt.ok)
+		}
+	}
+
+	score := func(text string) int {
+		s, _ := fuzzyScore(text, "db")
+		return s
+	}
+	ordered := []string{"db", "prod-db", "d-b", "dxxxxb"}
+	for i := 1; i < len(ordered); i++ {
+		if score(ordered[i-1]) <= score(ordered[i]) {
+			t.Errorf("%q (%d) should score higher than %q (%d)", ordered[i-1], score(ordered[i-1]), ordered[i], score(ordered[i]))
+		}
+	}
+}
+
+func TestLeaves(t *testing.T) {
+	var names []string
+	for _, n := range Leaves(searchTree()) {
+		names = append(names, n.Path())
+	}
+	if got := strings.Join(names, " "); got != "prod/web-1 prod/db dev/web-1 Bastion" {
+		t.Fatalf("leaves: %s", got)
+	}
+}
@@ -0,0 +1,115 @@
+package sshw
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/atrox/homedir"
+)
+
+var (
+	// secretFields 连接时需要解析外部引用的字段
+	secretFields = []string{"password", "passphrase", "totp_secret", "keyboard_interactive"}
+
+	// secretBackends 外部密钥引用，按前缀选择解析方式，传入去掉前缀后的内容
+	secretBackends = map[string]func(ref string) (string, error){
+		"ref:cmd:":  resolveCommand,
+		"ref:file:": resolveFile,
+		"ref:env:":  resolveEnv,
+	}
+)
+
+// IsSecretRef 检查字段值是否是外部密钥引用
+func IsSecretRef(v string) bool {
+	_, _, ok := secretBackend(v)
+	return ok
+}
+
+func secretBackend(v string) (func(string) (string, error), string, bool) {
+	for prefix, resolve := range secretBackends {
+		if strings.HasPrefix(v, prefix) {
+			return resolve, strings.TrimPrefix(v, prefix), true
+		}
+	}
+	return nil, "", false
+}
+
+// resolveSecrets 返回解析了外部引用的节点副本，只在连接时调用，密钥不会写回配置
+// 解析失败的字段会被清空，错误中包含节点路径
+func (n *Node) resolveSecrets() (*Node, error) {
+	resolved := *n
+	// 私钥列表和预设回答会被修改，需要复制
+	resolved.KeyPaths = make([]*KeyFile, len(n.KeyPaths))
+	for i, k := range n.KeyPaths {
+		key := *k
+		resolved.KeyPaths[i] = &key
+	}
+	resolved.KeyboardInteractive = make([]*PromptAnswer, len(n.KeyboardInteractive))
+	for i, a := range n.KeyboardInteractive {
+		answer := *a
+		resolved.KeyboardInteractive[i] = &answer
+	}
+	var errs []string
+	for _, name := range secretFields {
+		for _, field := range encryptableFields[name](&resolved) {
+			resolve, ref, ok := secretBackend(*field)
+			if !ok {
+				continue
+			}
+			v, err := resolve(ref)
+			if err != nil {
+				*field = ""
+				errs = append(errs, fmt.Sprintf("%s: failed to resolve %s: %v", n.Path(), name, err))
+				continue
+			}
+			*field = v
+		}
+	}
+	if len(errs) > 0 {
+		return &resolved, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return &resolved, nil
+}
+
+// resolveCommand 执行命令，使用其标准输出，如 ref:cmd:pass show prod/db
+func resolveCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("command %q failed: %v", command, err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// resolveFile 读取文件内容，如 ref:file:/run/secrets/db
+func resolveFile(path string) (string, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// resolveEnv 读取环境变量，如 ref:env:DB_PASSWORD
+func resolveEnv(name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return v, nil
+}
@@ -0,0 +1,144 @@
+package sshw
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/zdev0x/sshw/vault"
+	"golang.org/x/crypto/ssh"
+)
+
+// useSecretVault 运行只提供 secret/data/ssh/prod 的 Vault
+func useSecretVault(t *testing.T) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/ssh/prod" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"password":"from-vault"},"metadata":{"version":1}}}`))
+	}))
+	t.Cleanup(srv.Close)
+	vaultConfig, vaultClient = &vault.Config{Address: srv.URL, Token: "root"}, nil
+}
+
+func TestResolveSecrets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	saveState(t)
+	useSecretVault(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "secret")
+	ioutil.WriteFile(file, []byte("from-file\r\n"), 0600)
+	t.Setenv("SSHW_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+		err   string
+	}{
+		{"literal", "plain", "plain", ""},
+		{"unknown scheme", "ref:ftp:x", "ref:ftp:x", ""},
+		{"env", "ref:env:SSHW_TEST_SECRET", "from-env", ""},
+		{"missing env", "ref:env:SSHW_TEST_MISSING", "", "prod/web: failed to resolve password: environment variable SSHW_TEST_MISSING is not set"},
+		{"file", "ref:file:" + file, "from-file", ""},
+		{"missing file", "ref:file:" + filepath.Join(dir, "missing"), "", "prod/web: failed to resolve password: open "},
+		{"command", "ref:cmd:printf 'from-cmd\\n'", "from-cmd", ""},
+		{"failing command", "ref:cmd:echo leaked; exit 3", "", "prod/web: failed to resolve password: command \"echo leaked; exit 3\" failed: exit status 3"},
+		{"vault", "vault:secret/data/ssh/prod#password", "from-vault", ""},
+		{"missing vault key", "vault:secret/data/ssh/prod#token", "", `prod/web: failed to resolve password: key "token" not found in secret/data/ssh/prod`},
+		{"missing vault path", "vault:secret/data/ssh/dev#password", "", "prod/web: failed to resolve password: vault GET secret/data/ssh/dev: 404 Not Found"},
+		{"vault without key", "vault:secret/data/ssh/prod", "", "prod/web: failed to resolve password: invalid vault reference"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &Node{Name: "web", Password: tt.value}
+			linkParents([]*Node{{Name: "prod", Children: []*Node{n}}}, nil)
+
+			got, err := n.resolveSecrets()
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)) {
+				t.Fatalf("error: got %v, want prefix %q", err, tt.err)
+			}
+			if got.Password != tt.want {
+				t.Fatalf("password: got %q, want %q", got.Password, tt.want)
+			}
+			if n.Password != tt.value {
+				t.Fatal("resolving changed the config")
+			}
+		})
+	}
+}
+
+func TestResolveSecretFields(t *testing.T) {
+	saveState(t)
+	t.Setenv("SSHW_TEST_SECRET", "from-env")
+	n := &Node{
+		Name:                "web",
+		Host:                "ref:env:SSHW_TEST_SECRET",
+		Passphrase:          "ref:env:SSHW_TEST_SECRET",
+		TOTPSecret:          "ref:env:SSHW_TEST_SECRET",
+		KeyPaths:            []*KeyFile{{Path: "~/.ssh/id", Passphrase: "ref:env:SSHW_TEST_SECRET"}},
+		KeyboardInteractive: []*PromptAnswer{{PromptRegex: "PIN", Answer: "ref:env:SSHW_TEST_MISSING"}},
+	}
+
+	got, err := n.resolveSecrets()
+	if err == nil || !strings.Contains(err.Error(), "web: failed to resolve keyboard_interactive") {
+		t.Fatalf("error: got %v", err)
+	}
+	if got.Passphrase != "from-env" || got.TOTPSecret != "from-env" || got.KeyPaths[0].Passphrase != "from-env" {
+		t.Fatalf("secrets not resolved: %+v", got)
+	}
+	// 只解析敏感字段
+	if got.Host != n.Host {
+		t.Fatalf("host resolved: %q", got.Host)
+	}
+	if got.KeyboardInteractive[0].Answer != "" {
+		t.Fatalf("unresolved answer kept: %q", got.KeyboardInteractive[0].Answer)
+	}
+	// 副本不共享私钥列表和预设回答
+	if n.KeyPaths[0].Passphrase != "ref:env:SSHW_TEST_SECRET" || n.KeyboardInteractive[0].Answer != "ref:env:SSHW_TEST_MISSING" {
+		t.Fatal("resolving changed the config")
+	}
+}
+
+func TestUnresolvedSecretNotSent(t *testing.T) {
+	saveState(t)
+	var sent []string
+	addr := serveSSH(t, &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			sent = append(sent, string(pass))
+			return nil, ssh.ErrNoAuth
+		},
+		KeyboardInteractiveCallback: func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"Password: "}, []bool{false})
+			if err == nil {
+				sent = append(sent, answers...)
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	})
+	n := &Node{Name: "web", Password: "ref:env:SSHW_TEST_MISSING", KeyboardInteractive: []*PromptAnswer{{PromptRegex: "Password", Answer: "ref:env:SSHW_TEST_MISSING"}}}
+
+	if err := dialTest(addr, "root", genSSHConfig(n).clientConfig.Auth...); err == nil {
+		t.Fatal("login succeeded")
+	}
+	if len(sent) == 0 {
+		t.Fatal("keyboard-interactive not attempted")
+	}
+	for _, s := range sent {
+		if strings.Contains(s, "ref:") {
+			t.Fatalf("unresolved reference sent to the server: %q", s)
+		}
+	}
+}
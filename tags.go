@@ -0,0 +1,68 @@
+package sshw
+
+import "strings"
+
+// 查询中按标签过滤的前缀，如 tag:prod
+const tagPrefix = "tag:"
+
+// AllTags 返回节点及所有上级分组的标签
+func (n *Node) AllTags() []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for p := n; p != nil; p = p.parent {
+		for _, t := range p.Tags {
+			if !seen[t] {
+				seen[t] = true
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
+
+// HasTags 检查节点是否包含所有标签（含继承的标签），不区分大小写
+func (n *Node) HasTags(tags []string) bool {
+	all := n.AllTags()
+	for _, want := range tags {
+		found := false
+		for _, t := range all {
+			if strings.EqualFold(t, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchTags 检查节点是否匹配标签，分组中任意一个节点匹配时分组也匹配
+func MatchTags(n *Node, tags []string) bool {
+	if len(n.Children) == 0 {
+		return n.HasTags(tags)
+	}
+	for _, c := range n.Children {
+		if MatchTags(c, tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitTags 把查询中的 tag: 词和其他词分开，tag:a,b 等同于 tag:a tag:b
+func SplitTags(query string) (tags []string, terms []string) {
+	for _, term := range strings.Fields(query) {
+		if !strings.HasPrefix(strings.ToLower(term), tagPrefix) {
+			terms = append(terms, term)
+			continue
+		}
+		for _, t := range strings.Split(term[len(tagPrefix):], ",") {
+			if t != "" {
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags, terms
+}
@@ -0,0 +1,76 @@
+package sshw
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitTags(t *testing.T) {
+	tests := []struct {
+		query string
+		tags  []string
+		terms []string
+	}{
+		{"", nil, nil},
+		{"web", nil, []string{"web"}},
+		{"tag:prod web", []string{"prod"}, []string{"web"}},
+		{"TAG:prod Tag:db", []string{"prod", "db"}, nil},
+		{"tag:prod,db,  web 10.0", []string{"prod", "db"}, []string{"web", "10.0"}},
+		{"tag:,prod,, tag:", []string{"prod"}, nil},
+		{"tags:prod", nil, []string{"tags:prod"}},
+	}
+	for _, tt := range tests {
+		tags, terms := SplitTags(tt.query)
+		if !reflect.DeepEqual(tags, tt.tags) || !reflect.DeepEqual(terms, tt.terms) {
+			t.Errorf("SplitTags(%q) = %q, %q, want %q, %q", tt.query, tags, terms, tt.tags, tt.terms)
+		}
+	}
+}
+
+func TestTagInheritance(t *testing.T) {
+	nodes := []*Node{
+		{Name: "prod", Tags: []string{"prod", "web"}, Children: []*Node{
+			{Name: "eu", Tags: []string{"eu", "web"}, Children: []*Node{
+				{Name: "web-1", Host: "h1", Tags: []string{"primary"}},
+				{Name: "web-2", Host: "h2"},
+			}},
+		}},
+		{Name: "dev", Host: "h3"},
+	}
+	linkParents(nodes, nil)
+	eu := nodes[0].Children[0]
+	web1, web2, dev := eu.Children[0], eu.Children[1], nodes[1]
+
+	// 先自身后上级，重复的标签只保留一次
+	if got := web1.AllTags(); !reflect.DeepEqual(got, []string{"primary", "eu", "web", "prod"}) {
+		t.Fatalf("all tags: %v", got)
+	}
+	if got := dev.AllTags(); got != nil {
+		t.Fatalf("untagged node: %v", got)
+	}
+
+	tests := []struct {
+		node *Node
+		tags []string
+		want bool
+	}{
+		{web1, nil, true},
+		{web1, []string{"PROD", "Primary"}, true},
+		{web2, []string{"prod", "eu"}, true},
+		{web2, []string{"primary"}, false},
+		{dev, []string{"prod"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.node.HasTags(tt.tags); got != tt.want {
+			t.Errorf("%s.HasTags(%v) = %v, want %v", tt.node.Path(), tt.tags, got, tt.want)
+		}
+	}
+
+	// 分组中任意节点匹配时分组也匹配
+	if !MatchTags(nodes[0], []string{"primary"}) || !MatchTags(eu, []string{"eu"}) {
+		t.Fatal("group does not match a tag of its children")
+	}
+	if MatchTags(nodes[0], []string{"missing"}) || MatchTags(dev, []string{"web"}) {
+		t.Fatal("matched a missing tag")
+	}
+}
@@ -0,0 +1,77 @@
+package sshw
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Terminal 节点上的交互式终端，输入输出不连接到当前终端，用于同时操作多个节点
+type Terminal struct {
+	Node    *Node
+	client  *ssh.Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+	start   time.Time
+	done    chan struct{}
+	once    sync.Once
+}
+
+// OpenTerminal 连接节点并打开 w 列 h 行的终端，终端的输出写入 out
+// 连接时可能需要输入密码，多个节点需要依次打开
+// 终端类型为 dumb，远程程序不会使用光标定位和全屏绘制，输出可以按行显示
+func OpenTerminal(n *Node, w, h int, out io.Writer) (*Terminal, error) {
+	c := NewClient(n).(*defaultClient)
+	client, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	session, stdin, err := c.startShell(client, "dumb", w, h, out, out)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	go keepAlive(client)
+
+	t := &Terminal{
+		Node:    n,
+		client:  client,
+		session: session,
+		stdin:   stdin,
+		start:   time.Now(),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		session.Wait()
+		close(t.done)
+	}()
+	return t, nil
+}
+
+// Write 把输入发送到终端
+func (t *Terminal) Write(p []byte) (int, error) {
+	return t.stdin.Write(p)
+}
+
+// Resize 修改终端大小
+func (t *Terminal) Resize(w, h int) error {
+	return t.session.WindowChange(h, w)
+}
+
+// Done 返回终端会话结束时关闭的 channel
+func (t *Terminal) Done() <-chan struct{} {
+	return t.done
+}
+
+// Close 关闭终端和连接，记录登录历史
+func (t *Terminal) Close() error {
+	var err error
+	t.once.Do(func() {
+		t.session.Close()
+		err = t.client.Close()
+		recordLogin(t.Node, t.start)
+	})
+	return err
+}
@@ -0,0 +1,99 @@
+package sshw
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 匹配验证码提示的默认正则
+const defaultTOTPPrompt = `(?i)(verification code|one-time|otp|token|authenticator|2fa|totp)`
+
+// 生成验证码使用的时钟，测试中替换
+var totpNow = time.Now
+
+// totpConfig RFC 6238 参数
+type totpConfig struct {
+	key    []byte
+	digits int
+	period int64
+	hash   func() hash.Hash
+}
+
+// parseTOTPSecret 解析 base32 密钥或 otpauth://totp/ URI
+func parseTOTPSecret(secret string) (*totpConfig, error) {
+	c := &totpConfig{digits: 6, period: 30, hash: sha1.New}
+
+	if strings.HasPrefix(secret, "otpauth://") {
+		u, err := url.Parse(secret)
+		if err != nil {
+			return nil, err
+		}
+		q := u.Query()
+		secret = q.Get("secret")
+		if d := q.Get("digits"); d != "" {
+			if c.digits, err = strconv.Atoi(d); err != nil || c.digits < 6 || c.digits > 10 {
+				return nil, fmt.Errorf("invalid digits %q", d)
+			}
+		}
+		if p := q.Get("period"); p != "" {
+			if c.period, err = strconv.ParseInt(p, 10, 64); err != nil || c.period <= 0 {
+				return nil, fmt.Errorf("invalid period %q", p)
+			}
+		}
+		switch strings.ToUpper(q.Get("algorithm")) {
+		case "", "SHA1":
+		case "SHA256":
+			c.hash = sha256.New
+		case "SHA512":
+			c.hash = sha512.New
+		default:
+			return nil, fmt.Errorf("unsupported algorithm %q", q.Get("algorithm"))
+		}
+	}
+
+	// 兼容带空格、小写和省略填充的密钥
+	secret = strings.ToUpper(strings.Join(strings.Fields(secret), ""))
+	secret = strings.TrimRight(secret, "=")
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid totp secret")
+	}
+	c.key = key
+	return c, nil
+}
+
+// code 生成 t 时刻的验证码
+func (c *totpConfig) code(t time.Time) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/c.period))
+	mac := hmac.New(c.hash, c.key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	v := int64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+	mod := int64(1)
+	for i := 0; i < c.digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", c.digits, v%mod)
+}
+
+// TOTP 使用节点的 totp_secret 生成当前验证码
+func TOTP(secret string) (string, error) {
+	c, err := parseTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return c.code(totpNow()), nil
+}
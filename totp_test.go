@@ -0,0 +1,99 @@
+package sshw
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useClock 固定生成验证码使用的时间
+func useClock(t *testing.T, unix int64) {
+	t.Helper()
+	old := totpNow
+	t.Cleanup(func() { totpNow = old })
+	totpNow = func() time.Time { return time.Unix(unix, 0) }
+}
+
+// RFC 6238 附录 B 的测试向量
+func TestTOTPVectors(t *testing.T) {
+	seed := func(n int) string {
+		return base32.StdEncoding.EncodeToString([]byte(strings.Repeat("1234567890", 7)[:n]))
+	}
+	sha1Secret := seed(20)
+	times := []int64{59, 1111111109, 1111111111, 1234567890, 2000000000, 20000000000}
+	tests := []struct {
+		secret string
+		codes  []string
+	}{
+		{"otpauth://totp/sshw?digits=8&secret=" + sha1Secret,
+			[]string{"94287082", "07081804", "14050471", "89005924", "69279037", "65353130"}},
+		{"otpauth://totp/sshw?digits=8&algorithm=SHA256&secret=" + seed(32),
+			[]string{"46119246", "68084774", "67062674", "91819424", "90698825", "77737706"}},
+		{"otpauth://totp/sshw?digits=8&algorithm=SHA512&secret=" + seed(64),
+			[]string{"90693936", "25091201", "99943326", "93441116", "38618901", "47863826"}},
+		{sha1Secret, []string{"287082", "081804", "050471", "005924", "279037", "353130"}},
+	}
+	for _, tt := range tests {
+		for i, unix := range times {
+			useClock(t, unix)
+			got, err := TOTP(tt.secret)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.secret, err)
+			}
+			if got != tt.codes[i] {
+				t.Errorf("%s at %d: got %s, want %s", tt.secret, unix, got, tt.codes[i])
+			}
+		}
+	}
+}
+
+func TestTOTPSecretFormats(t *testing.T) {
+	useClock(t, 59)
+	want := "287082"
+	for _, secret := range []string{
+		"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		"gezdgnbvgy3tqojqgezdgnbvgy3tqojq",
+		"GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ",
+		" GEZDGNBVGY3TQOJQ\tGEZDGNBVGY3TQOJQ\n",
+		"otpauth://totp/sshw?secret=gezdgnbvgy3tqojqgezdgnbvgy3tqojq&period=30",
+	} {
+		got, err := TOTP(secret)
+		if err != nil {
+			t.Fatalf("%q: %v", secret, err)
+		}
+		if got != want {
+			t.Errorf("%q: got %s, want %s", secret, got, want)
+		}
+	}
+
+	// 长度不是 8 的倍数的密钥，带或不带填充结果相同
+	padded, err := TOTP("JBSWY3DPEHPK3PXPJA======")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unpadded, err := TOTP("JBSWY3DPEHPK3PXPJA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if padded != unpadded {
+		t.Fatalf("padded %s, unpadded %s", padded, unpadded)
+	}
+}
+
+func TestTOTPInvalidSecret(t *testing.T) {
+	for _, secret := range []string{
+		"",
+		"   ",
+		"not base32!",
+		"JBSWY3DP1",
+		"otpauth://totp/sshw",
+		"otpauth://totp/sshw?secret=JBSWY3DPEHPK3PXP&digits=5",
+		"otpauth://totp/sshw?secret=JBSWY3DPEHPK3PXP&period=0",
+		"otpauth://totp/sshw?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+	} {
+		if code, err := TOTP(secret); err == nil {
+			t.Errorf("%q accepted, got code %s", secret, code)
+		}
+	}
+}
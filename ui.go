@@ -0,0 +1,60 @@
+package sshw
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UIConfig 界面设置，对应配置文件中的 ui
+type UIConfig struct {
+	Templates *UITemplates `yaml:"templates,omitempty" json:"templates,omitempty"`
+	// 选择列表每页显示的行数
+	PageSize int `yaml:"page_size,omitempty" json:"page_size,omitempty"`
+	// 颜色主题，设置了 NO_COLOR 环境变量时不使用颜色
+	Theme string `yaml:"theme,omitempty" json:"theme,omitempty"`
+	// 参与搜索的字段：alias、tags、name、path、user、host
+	SearchFields []string `yaml:"search_fields,omitempty" json:"search_fields,omitempty"`
+}
+
+// UITemplates 选择列表的模板，未设置的使用默认模板
+type UITemplates struct {
+	Label    string `yaml:"label,omitempty" json:"label,omitempty"`
+	Active   string `yaml:"active,omitempty" json:"active,omitempty"`
+	Inactive string `yaml:"inactive,omitempty" json:"inactive,omitempty"`
+	Selected string `yaml:"selected,omitempty" json:"selected,omitempty"`
+	Details  string `yaml:"details,omitempty" json:"details,omitempty"`
+}
+
+// 当前配置的界面设置
+var uiConfig *UIConfig
+
+// UI 返回界面设置，未配置时返回空设置
+func UI() *UIConfig {
+	if uiConfig == nil {
+		return &UIConfig{}
+	}
+	return uiConfig
+}
+
+// validate 检查界面设置中的搜索字段和页大小
+func (c *UIConfig) validate() error {
+	if c.PageSize < 0 {
+		return fmt.Errorf("invalid ui.page_size %d", c.PageSize)
+	}
+	for _, name := range c.SearchFields {
+		known := false
+		for _, f := range searchFields {
+			if f.name == name {
+				known = true
+			}
+		}
+		if !known {
+			names := make([]string, len(searchFields))
+			for i, f := range searchFields {
+				names[i] = f.name
+			}
+			return fmt.Errorf("unknown ui.search_fields entry %q, expected one of %s", name, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
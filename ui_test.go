@@ -0,0 +1,39 @@
+package sshw
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUIConfig(t *testing.T) {
+	saveState(t)
+	tests := []struct {
+		ui  string
+		err string
+	}{
+		{"page_size: 15\n  theme: ocean\n  search_fields: [alias, tags, name, path, user, host]", ""},
+		{"page_size: -1", "invalid ui.page_size -1"},
+		{"search_fields: [alias, colour]", `unknown ui.search_fields entry "colour"`},
+	}
+	for _, tt := range tests {
+		p := writeConfig(t, "ui:\n  "+tt.ui+"\nnodes:\n  - name: web\n    host: h\n")
+		err := LoadConfig(nil, p)
+		if tt.err == "" {
+			if err != nil {
+				t.Fatalf("%s: %v", tt.ui, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%s: got %v, want %q", tt.ui, err, tt.err)
+		}
+	}
+
+	if ui := UI(); ui.PageSize != 15 || ui.Theme != "ocean" || len(ui.SearchFields) != 6 {
+		t.Fatalf("ui: %+v", ui)
+	}
+	uiConfig = nil
+	if ui := UI(); ui == nil || ui.PageSize != 0 {
+		t.Fatalf("default ui: %+v", ui)
+	}
+}
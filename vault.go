@@ -0,0 +1,61 @@
+package sshw
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zdev0x/sshw/vault"
+)
+
+const vaultPrefix = "vault:"
+
+var (
+	// 配置中的 vault 设置，客户端在第一次解析 vault 引用时创建
+	vaultConfig *vault.Config
+	vaultClient *vault.Client
+)
+
+func init() {
+	secretBackends[vaultPrefix] = resolveVault
+}
+
+// resolveVault 从 Vault 读取密钥，如 vault:secret/data/ssh/prod#password
+func resolveVault(ref string) (string, error) {
+	c, err := getVaultClient()
+	if err != nil {
+		return "", err
+	}
+	return c.Secret(ref)
+}
+
+// getVaultClient 返回本次运行共用的客户端，读取过的密钥只请求一次
+func getVaultClient() (*vault.Client, error) {
+	if vaultClient != nil {
+		return vaultClient, nil
+	}
+
+	var c vault.Config
+	if vaultConfig != nil {
+		c = *vaultConfig
+	}
+	// 令牌等凭据也可以使用 ref: 引用
+	for _, v := range []*string{&c.Token, &c.SecretID} {
+		if strings.HasPrefix(*v, vaultPrefix) {
+			return nil, fmt.Errorf("vault credentials can not reference vault")
+		}
+		if resolve, ref, ok := secretBackend(*v); ok {
+			s, err := resolve(ref)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve vault credentials: %v", err)
+			}
+			*v = s
+		}
+	}
+
+	client, err := vault.NewClient(&c)
+	if err != nil {
+		return nil, err
+	}
+	vaultClient = client
+	return vaultClient, nil
+}
@@ -0,0 +1,53 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultSSHMount = "ssh"
+
+// SignSSHKey 使用 SSH secrets engine 签发用户证书，对应 /v1/<mount>/sign/<role>
+func (c *Client) SignSSHKey(mount, role string, pub ssh.PublicKey, principals []string, ttl string) (*ssh.Certificate, error) {
+	if role == "" {
+		return nil, errors.New("vault ssh role is required")
+	}
+	if mount == "" {
+		mount = defaultSSHMount
+	}
+
+	body := map[string]string{
+		"public_key": string(ssh.MarshalAuthorizedKey(pub)),
+		"cert_type":  "user",
+	}
+	if len(principals) > 0 {
+		body["valid_principals"] = strings.Join(principals, ",")
+	}
+	if ttl != "" {
+		body["ttl"] = ttl
+	}
+
+	var resp struct {
+		Data struct {
+			SignedKey string `json:"signed_key"`
+		} `json:"data"`
+	}
+	path := strings.Trim(mount, "/") + "/sign/" + role
+	if err := c.Do(http.MethodPost, path, body, &resp); err != nil {
+		return nil, err
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(resp.Data.SignedKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signed key from vault: %v", err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("vault returned a key that is not a certificate")
+	}
+	return cert, nil
+}
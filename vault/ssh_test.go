@@ -0,0 +1,135 @@
+package vault
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// signServer 模拟 SSH secrets engine 的 /v1/<mount>/sign/<role>，用 ca 签发证书
+func signServer(t *testing.T, ca ssh.Signer) (*httptest.Server, *[]map[string]string) {
+	t.Helper()
+	var requests []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			vaultError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+		if len(parts) != 3 || parts[1] != "sign" || parts[2] == "unknown" {
+			vaultError(w, http.StatusBadRequest, "unknown role")
+			return
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		body["mount"], body["role"] = parts[0], parts[2]
+		requests = append(requests, body)
+
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(body["public_key"]))
+		if err != nil {
+			vaultError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		cert := &ssh.Certificate{
+			Key:             pub,
+			CertType:        ssh.UserCert,
+			KeyId:           "vault-" + parts[2],
+			ValidPrincipals: strings.Split(body["valid_principals"], ","),
+			ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			vaultError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"data": map[string]string{"signed_key": string(ssh.MarshalAuthorizedKey(cert))},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func testSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSignSSHKey(t *testing.T) {
+	ca, key := testSigner(t), testSigner(t)
+	srv, requests := signServer(t, ca)
+	c := newTestClient(t, Config{Address: srv.URL, Token: "root"})
+
+	cert, err := c.SignSSHKey("", "ops", key.PublicKey(), []string{"deploy", "root"}, "10m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cert.Key.Marshal()) != string(key.PublicKey().Marshal()) {
+		t.Fatal("certificate is not for the given key")
+	}
+	if string(cert.SignatureKey.Marshal()) != string(ca.PublicKey().Marshal()) {
+		t.Fatal("certificate not signed by the ca")
+	}
+	if strings.Join(cert.ValidPrincipals, ",") != "deploy,root" {
+		t.Fatalf("principals: %v", cert.ValidPrincipals)
+	}
+
+	req := (*requests)[0]
+	if req["mount"] != defaultSSHMount || req["role"] != "ops" || req["cert_type"] != "user" || req["ttl"] != "10m" {
+		t.Fatalf("sign request: %v", req)
+	}
+
+	// 自定义挂载路径，不指定 principals 和 ttl 时由角色决定
+	if _, err := c.SignSSHKey("/ssh-client/", "ops", key.PublicKey(), nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	req = (*requests)[1]
+	if req["mount"] != "ssh-client" {
+		t.Fatalf("mount: got %q", req["mount"])
+	}
+	if _, ok := req["valid_principals"]; ok {
+		t.Fatal("empty principals sent")
+	}
+	if _, ok := req["ttl"]; ok {
+		t.Fatal("empty ttl sent")
+	}
+}
+
+func TestSignSSHKeyErrors(t *testing.T) {
+	ca, key := testSigner(t), testSigner(t)
+	srv, _ := signServer(t, ca)
+	c := newTestClient(t, Config{Address: srv.URL, Token: "root"})
+
+	if _, err := c.SignSSHKey("", "", key.PublicKey(), nil, ""); err == nil || err.Error() != "vault ssh role is required" {
+		t.Fatalf("empty role: got %v", err)
+	}
+	if _, err := c.SignSSHKey("", "unknown", key.PublicKey(), nil, ""); err == nil || err.Error() != "vault POST ssh/sign/unknown: unknown role" {
+		t.Fatalf("unknown role: got %v", err)
+	}
+
+	// 返回的不是证书
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"data": map[string]string{"signed_key": string(ssh.MarshalAuthorizedKey(key.PublicKey()))},
+		})
+	}))
+	defer plain.Close()
+	c = newTestClient(t, Config{Address: plain.URL, Token: "root"})
+	if _, err := c.SignSSHKey("", "ops", key.PublicKey(), nil, ""); err == nil || !strings.Contains(err.Error(), "not a certificate") {
+		t.Fatalf("plain key: got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAddress      = "https://127.0.0.1:8200"
+	defaultAppRoleMount = "approle"
+	requestTimeout      = 10 * time.Second
+	// 令牌到期前提前重新登录
+	tokenExpiryMargin = 30 * time.Second
+)
+
+var ErrNoToken = errors.New("vault token not found, set VAULT_TOKEN, token or role_id/secret_id")
+
+// Config Vault 连接配置，未设置的项使用 VAULT_* 环境变量
+type Config struct {
+	Address   string `yaml:"address,omitempty" json:"address,omitempty"`
+	Namespace string `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	CACert    string `yaml:"ca_cert,omitempty" json:"ca_cert,omitempty"`
+	Token     string `yaml:"token,omitempty" json:"token,omitempty"`
+	// AppRole 认证
+	RoleID       string `yaml:"role_id,omitempty" json:"role_id,omitempty"`
+	SecretID     string `yaml:"secret_id,omitempty" json:"secret_id,omitempty"`
+	AppRoleMount string `yaml:"approle_mount,omitempty" json:"approle_mount,omitempty"`
+}
+
+// withEnv 返回用环境变量补全后的配置
+func (c Config) withEnv() Config {
+	fill := func(v *string, env string) {
+		if *v == "" {
+			*v = os.Getenv(env)
+		}
+	}
+	fill(&c.Address, "VAULT_ADDR")
+	fill(&c.Namespace, "VAULT_NAMESPACE")
+	fill(&c.CACert, "VAULT_CACERT")
+	fill(&c.Token, "VAULT_TOKEN")
+	fill(&c.RoleID, "VAULT_ROLE_ID")
+	fill(&c.SecretID, "VAULT_SECRET_ID")
+	if c.Address == "" {
+		c.Address = defaultAddress
+	}
+	if c.AppRoleMount == "" {
+		c.AppRoleMount = defaultAppRoleMount
+	}
+	return c
+}
+
+// Client Vault HTTP API 客户端，读取过的密钥在本次运行中缓存
+type Client struct {
+	config Config
+	http   *http.Client
+
+	mu    sync.Mutex
+	token string
+	// AppRole 令牌的过期时间，零值表示不过期
+	expires time.Time
+	cache   map[string]map[string]interface{}
+}
+
+// NewClient 创建客户端，登录延迟到第一次请求
+func NewClient(config *Config) (*Client, error) {
+	var c Config
+	if config != nil {
+		c = *config
+	}
+	c = c.withEnv()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.CACert != "" {
+		pem, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vault ca cert: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid vault ca cert: %s", c.CACert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &Client{
+		config: c,
+		http:   &http.Client{Timeout: requestTimeout, Transport: transport},
+		cache:  make(map[string]map[string]interface{}),
+	}, nil
+}
+
+// Secret 解析 path#key 形式的引用，如 secret/data/ssh/prod#password
+func (c *Client) Secret(ref string) (string, error) {
+	path, key := ref, ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		path, key = ref[:i], ref[i+1:]
+	}
+	if path == "" || key == "" {
+		return "", fmt.Errorf("invalid vault reference %q, expected path#key", ref)
+	}
+
+	data, err := c.Read(path)
+	if err != nil {
+		return "", err
+	}
+	v, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in %s", key, path)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// Read 读取密钥，兼容 KV v2（data.data）和 KV v1（data）
+func (c *Client) Read(path string) (map[string]interface{}, error) {
+	path = strings.Trim(path, "/")
+
+	c.mu.Lock()
+	data, ok := c.cache[path]
+	c.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := c.Do(http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	data = resp.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+
+	c.mu.Lock()
+	c.cache[path] = data
+	c.mu.Unlock()
+	return data, nil
+}
+
+// Do 带认证地调用 /v1/<path>，out 为 nil 时忽略返回内容
+func (c *Client) Do(method, path string, body, out interface{}) error {
+	token, err := c.login()
+	if err != nil {
+		return err
+	}
+	return c.request(method, path, token, body, out)
+}
+
+// login 返回访问令牌，没有令牌或 AppRole 令牌即将过期时使用 AppRole 登录
+func (c *Client) login() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && (c.expires.IsZero() || time.Now().Before(c.expires)) {
+		return c.token, nil
+	}
+
+	switch {
+	case c.config.Token != "":
+		c.token = c.config.Token
+	case c.config.RoleID != "":
+		var resp struct {
+			Auth struct {
+				ClientToken   string `json:"client_token"`
+				LeaseDuration int    `json:"lease_duration"`
+			} `json:"auth"`
+		}
+		body := map[string]string{"role_id": c.config.RoleID, "secret_id": c.config.SecretID}
+		path := "auth/" + strings.Trim(c.config.AppRoleMount, "/") + "/login"
+		if err := c.request(http.MethodPost, path, "", body, &resp); err != nil {
+			return "", fmt.Errorf("approle login failed: %v", err)
+		}
+		if resp.Auth.ClientToken == "" {
+			return "", errors.New("approle login returned no token")
+		}
+		c.token, c.expires = resp.Auth.ClientToken, time.Time{}
+		if resp.Auth.LeaseDuration > 0 {
+			ttl := time.Duration(resp.Auth.LeaseDuration) * time.Second
+			if ttl > 2*tokenExpiryMargin {
+				ttl -= tokenExpiryMargin
+			}
+			c.expires = time.Now().Add(ttl)
+		}
+	default:
+		// 与 vault 命令行一致，使用 vault login 保存的令牌
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", ErrNoToken
+		}
+		b, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+		if err != nil {
+			return "", ErrNoToken
+		}
+		c.token = strings.TrimSpace(string(b))
+	}
+	return c.token, nil
+}
+
+func (c *Client) request(method, path, token string, body, out interface{}) error {
+	var r *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	} else {
+		r = bytes.NewReader(nil)
+	}
+
+	url := strings.TrimRight(c.config.Address, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.config.Namespace)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(b, &e) == nil && len(e.Errors) > 0 {
+			return fmt.Errorf("vault %s %s: %s", method, path, strings.Join(e.Errors, "; "))
+		}
+		return fmt.Errorf("vault %s %s: %s", method, path, resp.Status)
+	}
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
@@ -0,0 +1,272 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVault 模拟 Vault 的 KV 和 AppRole 接口
+type fakeVault struct {
+	mu      sync.Mutex
+	secrets map[string]interface{}
+	tokens  map[string]bool
+	lease   int
+	logins  int
+	reads   int
+	// 最近一次请求的命名空间
+	namespace string
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *httptest.Server) {
+	t.Helper()
+	for _, env := range []string{"VAULT_ADDR", "VAULT_NAMESPACE", "VAULT_CACERT", "VAULT_TOKEN", "VAULT_ROLE_ID", "VAULT_SECRET_ID"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("HOME", t.TempDir())
+
+	v := &fakeVault{
+		secrets: map[string]interface{}{
+			// KV v2
+			"secret/data/ssh/prod": map[string]interface{}{
+				"data":     map[string]interface{}{"password": "v2-pass", "port": 2222},
+				"metadata": map[string]interface{}{"version": 3},
+			},
+			// KV v1
+			"kv/ssh/dev": map[string]interface{}{"password": "v1-pass"},
+		},
+		tokens: map[string]bool{"root": true},
+	}
+	srv := httptest.NewServer(v)
+	t.Cleanup(srv.Close)
+	return v, srv
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.namespace = r.Header.Get("X-Vault-Namespace")
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+	if path == "auth/approle/login" && r.Method == http.MethodPost {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["role_id"] != "role" || body["secret_id"] != "secret" {
+			vaultError(w, http.StatusBadRequest, "invalid role or secret ID")
+			return
+		}
+		v.logins++
+		token := fmt.Sprintf("approle-%d", v.logins)
+		v.tokens[token] = true
+		writeJSON(w, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token, "lease_duration": v.lease},
+		})
+		return
+	}
+
+	if !v.tokens[r.Header.Get("X-Vault-Token")] {
+		vaultError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	data, ok := v.secrets[path]
+	if !ok || r.Method != http.MethodGet {
+		// Vault 对不存在的路径返回空的错误列表
+		vaultError(w, http.StatusNotFound)
+		return
+	}
+	v.reads++
+	writeJSON(w, map[string]interface{}{"data": data})
+}
+
+func vaultError(w http.ResponseWriter, code int, errs ...string) {
+	w.WriteHeader(code)
+	if errs == nil {
+		errs = []string{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func newTestClient(t *testing.T, config Config) *Client {
+	t.Helper()
+	c, err := NewClient(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSecretKV(t *testing.T) {
+	_, srv := newFakeVault(t)
+	c := newTestClient(t, Config{Address: srv.URL, Token: "root"})
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"secret/data/ssh/prod#password", "v2-pass"},
+		{"/secret/data/ssh/prod/#port", "2222"},
+		{"kv/ssh/dev#password", "v1-pass"},
+	}
+	for _, tt := range tests {
+		got, err := c.Secret(tt.ref)
+		if err != nil {
+			t.Errorf("%s: %v", tt.ref, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+
+	// KV v2 的元数据不作为密钥返回
+	if _, err := c.Secret("secret/data/ssh/prod#metadata"); err == nil {
+		t.Error("metadata returned as a key")
+	}
+	if _, err := c.Secret("kv/ssh/dev#missing"); err == nil || err.Error() != `key "missing" not found in kv/ssh/dev` {
+		t.Errorf("missing key: got %v", err)
+	}
+	for _, ref := range []string{"kv/ssh/dev", "#password", "kv/ssh/dev#"} {
+		if _, err := c.Secret(ref); err == nil || !strings.Contains(err.Error(), "invalid vault reference") {
+			t.Errorf("%q: got %v", ref, err)
+		}
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	v, srv := newFakeVault(t)
+	c := newTestClient(t, Config{Address: srv.URL, Token: "root", Namespace: "team"})
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Secret("kv/ssh/dev#password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.reads != 1 {
+		t.Fatalf("secret read %d times, want 1", v.reads)
+	}
+	if v.namespace != "team" {
+		t.Fatalf("namespace header: got %q", v.namespace)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, srv := newFakeVault(t)
+
+	c := newTestClient(t, Config{Address: srv.URL, Token: "expired"})
+	_, err := c.Read("kv/ssh/dev")
+	if err == nil || err.Error() != "vault GET kv/ssh/dev: permission denied" {
+		t.Fatalf("403: got %v", err)
+	}
+
+	c = newTestClient(t, Config{Address: srv.URL, Token: "root"})
+	_, err = c.Read("kv/ssh/missing")
+	if err == nil || err.Error() != "vault GET kv/ssh/missing: 404 Not Found" {
+		t.Fatalf("404: got %v", err)
+	}
+}
+
+func TestTokenFromEnvAndFile(t *testing.T) {
+	_, srv := newFakeVault(t)
+	t.Setenv("VAULT_ADDR", srv.URL)
+
+	c := newTestClient(t, Config{})
+	if _, err := c.Read("kv/ssh/dev"); err != ErrNoToken {
+		t.Fatalf("without token: got %v, want %v", err, ErrNoToken)
+	}
+
+	// vault login 保存的令牌
+	home, _ := os.UserHomeDir()
+	if err := ioutil.WriteFile(filepath.Join(home, ".vault-token"), []byte("root\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c = newTestClient(t, Config{})
+	if _, err := c.Read("kv/ssh/dev"); err != nil {
+		t.Fatalf("token file: %v", err)
+	}
+
+	t.Setenv("VAULT_TOKEN", "expired")
+	c = newTestClient(t, Config{})
+	if _, err := c.Read("kv/ssh/dev"); err == nil {
+		t.Fatal("VAULT_TOKEN should take precedence over the token file")
+	}
+}
+
+func TestAppRoleLogin(t *testing.T) {
+	v, srv := newFakeVault(t)
+
+	c := newTestClient(t, Config{Address: srv.URL, RoleID: "role", SecretID: "wrong"})
+	_, err := c.Read("kv/ssh/dev")
+	if err == nil || err.Error() != "approle login failed: vault POST auth/approle/login: invalid role or secret ID" {
+		t.Fatalf("wrong secret id: got %v", err)
+	}
+
+	t.Setenv("VAULT_ROLE_ID", "role")
+	t.Setenv("VAULT_SECRET_ID", "secret")
+	c = newTestClient(t, Config{Address: srv.URL})
+	for _, path := range []string{"kv/ssh/dev", "secret/data/ssh/prod"} {
+		if _, err := c.Read(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.logins != 1 {
+		t.Fatalf("logged in %d times, want 1", v.logins)
+	}
+}
+
+func TestAppRoleMount(t *testing.T) {
+	v, srv := newFakeVault(t)
+	c := newTestClient(t, Config{Address: srv.URL, RoleID: "role", SecretID: "secret", AppRoleMount: "/ci/"})
+	if _, err := c.Read("kv/ssh/dev"); err == nil || !strings.Contains(err.Error(), "auth/ci/login") {
+		t.Fatalf("custom mount: got %v", err)
+	}
+	if v.logins != 0 {
+		t.Fatal("logged in through the default mount")
+	}
+}
+
+func TestAppRoleTokenExpiry(t *testing.T) {
+	v, srv := newFakeVault(t)
+	v.lease = 3600
+	c := newTestClient(t, Config{Address: srv.URL, RoleID: "role", SecretID: "secret"})
+
+	token, err := c.login()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := time.Until(c.expires); ttl < time.Hour-tokenExpiryMargin-time.Minute || ttl > time.Hour-tokenExpiryMargin {
+		t.Fatalf("token expires in %v", ttl)
+	}
+	if again, _ := c.login(); again != token || v.logins != 1 {
+		t.Fatal("valid token not reused")
+	}
+
+	// 令牌过期后重新登录
+	c.expires = time.Now().Add(-time.Second)
+	renewed, err := c.login()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed == token || v.logins != 2 {
+		t.Fatalf("expired token not renewed: %s, %d logins", renewed, v.logins)
+	}
+
+	// 没有租期的令牌不过期
+	v.lease = 0
+	c = newTestClient(t, Config{Address: srv.URL, RoleID: "role", SecretID: "secret"})
+	if _, err := c.login(); err != nil || !c.expires.IsZero() {
+		t.Fatalf("token without lease: expires %v, %v", c.expires, err)
+	}
+}